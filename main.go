@@ -1,153 +1,341 @@
 package main
 
 import (
+	"bufio"
 	"database/sql"
+	"flag"
+	"fmt"
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/binding"
 	"github.com/martini-contrib/render"
 	_ "github.com/mattn/go-sqlite3"
+	"hiking_trails/src/config"
 	"hiking_trails/src/controllers"
 	"hiking_trails/src/middleware"
+	"hiking_trails/src/migrations"
 	"hiking_trails/src/models"
+	"io"
 	"log"
-)
-
-const (
-	DATABASE_FILE = "hiking_trails.sqlite3"
+	"os"
+	"strings"
+	"time"
 )
 
 func main() {
-	// os.Remove(DATABASE_FILE)
-
-	db, err := sql.Open("sqlite3", DATABASE_FILE)
-	if err != nil {
+	configuration, arguments, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	} else if err != nil {
 		log.Fatal(err)
 	}
+
+	// Also used by migrations that estimate the durations of existing paths.
+	models.WalkingSpeed = models.WalkingSpeedModel{
+		SpeedKilometersPerHour: configuration.WalkingSpeed.SpeedKmh,
+		AscentMetersPerHour:    configuration.WalkingSpeed.AscentMetersPerHour,
+	}
+
+	db := MustOpenDatabase(configuration.Database)
 	defer db.Close()
 
-	MustEnableForeignKeyChecks(db)
+	if argument(arguments, 0) == "migrate" {
+		MustRunMigrateCommand(db, argument(arguments, 1))
+		return
+	}
+
+	MustMigrateUp(db)
+
+	if argument(arguments, 0) == "create-admin" {
+		MustCreateAdministrator(db, configuration.Administrator)
+		return
+	}
 
-	MustCreateUsersDBTableIfNotExist(db)
-	MustCreatePlacesDBTableIfNotExist(db)
-	MustCreatePathsDBTableIfNotExist(db)
-	MustCreateBundlesDBTableIfNotExist(db)
+	MustWarnIfNoAdministrator(db)
 
-	models.MustCreateDefaultAdministratorIfMissing(db)
+	if configuration.ElevationTiles != "" {
+		MustEnableElevationProvider(configuration.ElevationTiles, configuration.ElevationCachedTiles)
+	}
 
 	app := martini.New()
 
 	app.Use(martini.Logger())
 	app.Use(martini.Recovery())
-	app.Use(martini.Static("public"))
+	app.Use(middleware.IndexPage(configuration.StaticRoot, configuration.MapsAPIKey))
+	app.Use(martini.Static(configuration.StaticRoot))
 	app.Use(render.Renderer())
 
 	app.Map(db)
+	app.Map(configuration.Session)
+	app.Map(models.LoginThrottle{
+		MaxFailures:      int64(configuration.Login.MaxFailures),
+		MaxFailuresPerIP: int64(configuration.Login.MaxFailuresPerIP),
+		LockoutDuration:  time.Duration(configuration.Login.LockoutDuration),
+	})
 
-	sessionStore := middleware.NewSessionStore()
-	app.Map(sessionStore)
-	app.Use(middleware.Sessions("store", sessionStore))
+	sessionStore := MustCreateSessionStore(db, configuration.Session)
+	app.MapTo(sessionStore, (*middleware.SessionStore)(nil))
+	app.Use(middleware.Sessions(sessionStore, configuration.Session))
+	app.Use(middleware.CSRFProtection)
+	middleware.StartSessionReaper(sessionStore, configuration.Session)
 
 	router := martini.NewRouter()
 	app.MapTo(router, (*martini.Routes)(nil))
 	app.Action(router.Handle)
 
-	router.Post("/api/v1/login", binding.Bind(models.LoginForm{}), controllers.UsersControllerLogin)
+	router.Post("/api/v1/login", middleware.PasswordHashingLimit, binding.Bind(models.LoginForm{}),
+		controllers.UsersControllerLogin)
 	router.Post("/api/v1/logout", controllers.UsersControllerLogout)
 
+	if configuration.OIDC.Issuer != "" {
+		oidc := configuration.OIDC
+		app.Map(oidc)
+		app.Map(models.NewOIDCProvider(oidc.Issuer, oidc.ClientId, oidc.ClientSecret, oidc.RedirectURL))
+
+		router.Get("/api/v1/auth/oidc/login", controllers.OIDCControllerLogin)
+		router.Get("/api/v1/auth/oidc/callback", controllers.OIDCControllerCallback)
+	}
+
+	router.Get("/api/v1/login-attempts", middleware.RoleRequired(models.ROLE_ADMINISTRATOR),
+		controllers.LoginAttemptsControllerList)
+
+	router.Get("/api/v1/users/me", middleware.LoginRequired, controllers.UsersControllerReadMe)
+	router.Put("/api/v1/users/me/password", middleware.SessionLoginRequired, middleware.PasswordHashingLimit,
+		binding.Bind(models.PasswordChangeForm{}), controllers.UsersControllerChangePassword)
+	router.Group("/api/v1/users/me/tokens", func(router martini.Router) {
+		router.Get("", controllers.APITokensControllerList)
+		router.Post("", binding.Bind(models.APIToken{}), controllers.APITokensControllerCreate)
+		router.Delete("/:id", controllers.APITokensControllerRevoke)
+	}, middleware.SessionLoginRequired)
+	router.Group("/api/v1/users", func(router martini.Router) {
+		router.Get("", controllers.UsersControllerList)
+		router.Post("", middleware.PasswordHashingLimit, binding.Bind(models.User{}), controllers.UsersControllerCreate)
+		router.Get("/:id", controllers.UsersControllerRead)
+		router.Put("/:id", middleware.PasswordHashingLimit, binding.Bind(models.User{}), controllers.UsersControllerUpdate)
+		router.Delete("/:id", controllers.UsersControllerDelete)
+	}, middleware.RoleRequired(models.ROLE_ADMINISTRATOR))
+
 	router.Get("/api/v1/bundles", controllers.BundlesControllerList)
+	router.Get("/api/v1/bundles.geojson", controllers.BundlesControllerList)
+	router.Get("/api/v1/bundles/:id/export.gpx", controllers.BundlesControllerExportGPX)
+	router.Get("/api/v1/bundles/:id/export.kml", controllers.BundlesControllerExportKML)
+	router.Get("/api/v1/bundles/:id/export.kmz", controllers.BundlesControllerExportKML)
 	router.Group("/api/v1/bundles", func(router martini.Router) {
-		router.Post("", binding.Bind(models.Bundle{}), controllers.BundlesControllerCreate)
-		router.Get("/:id", controllers.BundlesControllerRead)
-		router.Put("/:id", binding.Bind(models.Bundle{}), controllers.BundlesControllerUpdate)
-		router.Delete("/:id", controllers.BundlesControllerDelete)
-	}, middleware.AdministratorRequired)
+		canView := middleware.BundlePermissionRequired(models.BundleIdOfBundle, models.PERMISSION_VIEW)
+		canEdit := middleware.BundlePermissionRequired(models.BundleIdOfBundle, models.PERMISSION_EDIT)
+		canOwn := middleware.BundlePermissionRequired(models.BundleIdOfBundle, models.PERMISSION_OWN)
+		isEditor := middleware.RoleRequired(models.ROLE_EDITOR)
+
+		router.Post("", isEditor, binding.Bind(models.Bundle{}), controllers.BundlesControllerCreate)
+		router.Post("/import/kml", isEditor, controllers.BundlesControllerImportKML)
+		router.Get("/:id.geojson", canView, controllers.BundlesControllerRead)
+		router.Get("/:id", canView, controllers.BundlesControllerRead)
+		router.Put("/:id", canEdit, binding.Bind(models.Bundle{}), controllers.BundlesControllerUpdate)
+		router.Delete("/:id", canOwn, controllers.BundlesControllerDelete)
+		router.Get("/:id/permissions", canOwn, controllers.BundlesControllerListPermissions)
+		router.Put("/:id/permissions/:userId", canOwn, binding.Bind(models.BundlePermission{}),
+			controllers.BundlesControllerGrantPermission)
+		router.Delete("/:id/permissions/:userId", canOwn, controllers.BundlesControllerRevokePermission)
+	})
 
 	router.Get("/api/v1/places", controllers.PlacesControllerList)
+	router.Get("/api/v1/places.geojson", controllers.PlacesControllerList)
 	router.Group("/api/v1/places", func(router martini.Router) {
-		router.Post("", binding.Bind(models.Place{}), controllers.PlacesControllerCreate)
-		router.Get("/:id", controllers.PlacesControllerRead)
-		router.Put("/:id", binding.Bind(models.Place{}), controllers.PlacesControllerUpdate)
-		router.Delete("/:id", controllers.PlacesControllerDelete)
-	}, middleware.AdministratorRequired)
+		canView := middleware.BundlePermissionRequired(models.BundleIdOfPlace, models.PERMISSION_VIEW)
+		canEdit := middleware.BundlePermissionRequired(models.BundleIdOfPlace, models.PERMISSION_EDIT)
+
+		// The bundle is given by the new place, and authorized by the controller.
+		router.Post("", middleware.RoleRequired(models.ROLE_VIEWER), binding.Bind(models.Place{}),
+			controllers.PlacesControllerCreate)
+		router.Get("/:id.geojson", canView, controllers.PlacesControllerRead)
+		router.Get("/:id", canView, controllers.PlacesControllerRead)
+		router.Put("/:id", canEdit, binding.Bind(models.Place{}), controllers.PlacesControllerUpdate)
+		router.Delete("/:id", canEdit, controllers.PlacesControllerDelete)
+	})
+
+	router.Get("/api/v1/search", controllers.SearchControllerSearch)
+
+	router.Post("/api/v1/elevation/backfill", middleware.RoleRequired(models.ROLE_ADMINISTRATOR),
+		controllers.ElevationControllerBackfill)
 
 	router.Get("/api/v1/paths", controllers.PathsControllerList)
+	router.Get("/api/v1/paths.geojson", controllers.PathsControllerList)
+	router.Get("/api/v1/paths/:id/elevation", controllers.PathsControllerElevation)
+	router.Get("/api/v1/paths/:id/export.gpx", controllers.PathsControllerExportGPX)
+	router.Get("/api/v1/paths/:id/export.kml", controllers.PathsControllerExportKML)
+	router.Get("/api/v1/paths/:id/export.kmz", controllers.PathsControllerExportKML)
 	router.Group("/api/v1/paths", func(router martini.Router) {
-		router.Post("", binding.Bind(models.Path{}), controllers.PathsControllerCreate)
-		router.Get("/:id", controllers.PathsControllerRead)
-		router.Put("/:id", binding.Bind(models.Path{}), controllers.PathsControllerUpdate)
-		router.Delete("/:id", controllers.PathsControllerDelete)
-	}, middleware.AdministratorRequired)
+		canView := middleware.BundlePermissionRequired(models.BundleIdOfPath, models.PERMISSION_VIEW)
+		canEdit := middleware.BundlePermissionRequired(models.BundleIdOfPath, models.PERMISSION_EDIT)
+
+		// The bundle is given by the new path, and authorized by the controller.
+		router.Post("", middleware.RoleRequired(models.ROLE_VIEWER), binding.Bind(models.Path{}),
+			controllers.PathsControllerCreate)
+		router.Post("/import/gpx", middleware.RoleRequired(models.ROLE_VIEWER), controllers.PathsControllerImportGPX)
+		router.Get("/:id.geojson", canView, controllers.PathsControllerRead)
+		router.Get("/:id", canView, controllers.PathsControllerRead)
+		router.Put("/:id", canEdit, binding.Bind(models.Path{}), controllers.PathsControllerUpdate)
+		router.Delete("/:id", canEdit, controllers.PathsControllerDelete)
+	})
 
-	app.Run()
+	app.RunOnAddr(configuration.ListenAddress)
 }
 
-func MustCreateUsersDBTableIfNotExist(db *sql.DB) {
-	sqlStatement := `
-	CREATE TABLE IF NOT EXISTS users (id integer not null primary key,
-                                    username VARCHAR(255) NOT NULL,
-                                    salt VARCHAR(255),
-                                    hashed_password VARCHAR(255),
-                                    is_administrator BOOLEAN,
-                                    CONSTRAINT username_unique UNIQUE (username));
- `
-
-	_, err := db.Exec(sqlStatement)
+func MustMigrateUp(db *sql.DB) {
+	applied, err := migrations.Up(db)
+	for _, migration := range applied {
+		log.Printf("Applied migration %d %s", migration.Version, migration.Name)
+	}
+
 	if err != nil {
-		log.Fatalf("Failed to create 'users' database table: %s", err)
+		log.Fatalf("Failed to migrate database: %s", err)
 	}
 }
 
-func MustCreateBundlesDBTableIfNotExist(db *sql.DB) {
-	sqlStatement := `
-	CREATE TABLE IF NOT EXISTS bundles (id integer not null primary key,
-                                      name VARCHAR(255),
-                                      info VARCHAR(255),
-                                      image_url VARCHAR(255));
- `
+// Runs 'migrate up', 'migrate down' or 'migrate status'.
+func MustRunMigrateCommand(db *sql.DB, command string) {
+	switch command {
+	case "up":
+		MustMigrateUp(db)
 
-	_, err := db.Exec(sqlStatement)
-	if err != nil {
-		log.Fatalf("Failed to create 'bundles' database table: %s", err)
+	case "down":
+		migration, err := migrations.Down(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if migration == nil {
+			log.Printf("No migration to revert")
+		} else {
+			log.Printf("Reverted migration %d %s", migration.Version, migration.Name)
+		}
+
+	case "status":
+		statuses, err := migrations.GetStatus(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, status := range statuses {
+			appliedAt := status.AppliedAt
+			if appliedAt == "" {
+				appliedAt = "pending"
+			}
+
+			fmt.Printf("%4d  %-30s %s\n", status.Version, status.Name, appliedAt)
+		}
+
+	default:
+		log.Fatalf("Usage: %s migrate up|down|status", os.Args[0])
 	}
 }
 
-func MustCreatePathsDBTableIfNotExist(db *sql.DB) {
-	sqlStatement := `
-	CREATE TABLE IF NOT EXISTS paths (id INTEGER NOT NULL PRIMARY KEY,
-                                    name VARCHAR(255),
-                                    info VARCHAR(255),
-                                    length VARCHAR(255),
-                                    duration VARCHAR(255),
-                                    image_url VARCHAR(255),
-                                    polyline BLOB,
-                                    bundle_id INTEGER NOT NULL REFERENCES bundles(id) ON UPDATE CASCADE ON DELETE CASCADE);
- `
-
-	_, err := db.Exec(sqlStatement)
+// Creates an administrator with the configured credentials. The password is
+// read from standard input if none is configured.
+func MustCreateAdministrator(db *sql.DB, administrator config.AdministratorConfig) {
+	password := administrator.Password
+	if password == "" {
+		fmt.Printf("Password for %s: ", administrator.Username)
+
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			log.Fatalf("Failed to read password: %s", err)
+		}
+
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	user := models.User{Username: administrator.Username, Password: password, Role: models.ROLE_ADMINISTRATOR}
+
+	errors := user.Validate(nil, nil)
+	if len(errors) > 0 {
+		log.Fatalf("Invalid administrator: %s", errors[0].Message)
+	}
+
+	err := models.CheckUsernameAvailable(db, user.Username, 0)
+	if err == nil {
+		err = models.Save(&user, db)
+	}
+
 	if err != nil {
-		log.Fatalf("Failed to create 'paths' database table: %s", err)
+		log.Fatalf("Failed to create administrator: %s", err)
+	}
+
+	log.Printf("Created administrator %s with id %d", user.Username, user.Id)
+}
+
+func MustCreateSessionStore(db *sql.DB, settings config.SessionConfig) middleware.SessionStore {
+	switch settings.Store {
+	case "database":
+		return middleware.NewDatabaseSessionStore(db)
+	case "memory":
+		return middleware.NewMemorySessionStore()
 	}
+
+	log.Fatalf("Unknown session store '%s', expected database or memory", settings.Store)
+	return nil
 }
 
-func MustCreatePlacesDBTableIfNotExist(db *sql.DB) {
-	sqlStatement := `
-	CREATE TABLE IF NOT EXISTS places (id integer not null primary key,
-                                     name VARCHAR(255),
-                                     info VARCHAR(255),
-                                     radius BIGINT,
-                                     position BLOB,
-                                     path_id INTEGER NOT NULL REFERENCES paths(id) ON UPDATE CASCADE ON DELETE CASCADE);
- `
-
-	_, err := db.Exec(sqlStatement)
+func MustWarnIfNoAdministrator(db *sql.DB) {
+	count, err := models.CountAdministrators(db)
 	if err != nil {
-		log.Fatalf("Failed to create 'places' database table: %s", err)
+		log.Fatal(err)
+	}
+
+	if count == 0 {
+		log.Printf("No administrator exists. Create one with '%s create-admin'", os.Args[0])
 	}
 }
 
-func MustEnableForeignKeyChecks(db *sql.DB) {
-	_, err := db.Exec("PRAGMA foreign_keys = ON;")
+// Argument at index, or an empty string if there are fewer arguments.
+func argument(arguments []string, index int) string {
+	if index >= len(arguments) {
+		return ""
+	}
+
+	return arguments[index]
+}
+
+// Opens the database and selects the SQL dialect of the models.
+func MustOpenDatabase(database config.DatabaseConfig) *sql.DB {
+	switch database.Driver {
+	case "postgres":
+		db, err := sql.Open(models.POSTGRES_DRIVER, database.Source)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		models.Dialect = models.PostgresDialect{}
+		return db
+
+	case "sqlite3":
+		db, err := sql.Open("sqlite3", sqliteSourceWithForeignKeyChecks(database.Source))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return db
+	}
+
+	log.Fatalf("Unknown database driver '%s', expected sqlite3 or postgres", database.Driver)
+	return nil
+}
+
+// Foreign key checks are enabled by the connection string, so that every
+// connection of the pool has them and not only the one running a PRAGMA.
+func sqliteSourceWithForeignKeyChecks(source string) string {
+	separator := "?"
+	if strings.Contains(source, "?") {
+		separator = "&"
+	}
+
+	return source + separator + "_foreign_keys=1"
+}
+
+func MustEnableElevationProvider(directory string, maxCachedTiles int) {
+	provider, err := models.NewHGTElevationProvider(directory, maxCachedTiles)
 	if err != nil {
-		log.Fatalf("Failed to enable foreign key checks: %s", err)
+		log.Fatalf("Failed to enable elevation provider: %s", err)
 	}
+
+	models.Elevations = provider
 }
@@ -8,6 +8,11 @@
     // FIXME using html5 routes doesn't really work right now. This one is related to routes.js line 54
     // $locationProvider.html5Mode(true);
 
+    // The server sets the CSRF token of the session in this cookie at login,
+    // and requires it in this header on requests changing data.
+    $httpProvider.defaults.xsrfCookieName = 'XSRF-TOKEN';
+    $httpProvider.defaults.xsrfHeaderName = 'X-XSRF-TOKEN';
+
     $urlRouterProvider.otherwise('/404');
 
     $stateProvider.state('#', {
@@ -134,6 +134,7 @@
         length:"",
         polyline: [],
         duration: "",
+        difficulty: "",
         places: [],
         bundleId: bundle.id,
       }
@@ -157,6 +158,7 @@
         length: path.length,
         polyline: angular.copy(path.polyline),
         duration: path.duration,
+        difficulty: path.difficulty,
         places: angular.copy(path.places),
         bundleId: path.bundleId,
       }
@@ -240,6 +242,7 @@
       path.length = updatedPath.length;
       path.polyline = updatedPath.polyline;
       path.duration = updatedPath.duration;
+      path.difficulty = updatedPath.difficulty;
 
       addOrUpdatePolyline(path);
 
@@ -1,11 +1,16 @@
 (function (angular) {
   'use strict';
 
-  function authService($rootScope, $http, $cookies) {
-    var service = {sessionId: $cookies.SessionId};
+  function authService($rootScope, $http) {
+    var service = {user: null};
 
     $rootScope.messages = [];
 
+    // The session cookie is hidden from scripts, so ask who is logged in.
+    $http.get('api/v1/users/me').then(function (response) {
+      service.user = response.data;
+    });
+
     service.login = function(loginForm) {
       var promise;
 
@@ -22,7 +27,7 @@
     }
 
     function loginSuccess(response) {
-      service.sessionId = response.data.SessionId;
+      service.user = response.data.user;
       $rootScope.messages.length = 0;
       $rootScope.messages.push({message: "Succesfully logged in", messageClass: "bg-success"});
     }
@@ -38,7 +43,7 @@
     }
 
     function logoutSuccess(response) {
-      service.sessionId = "";
+      service.user = null;
       $rootScope.messages.length = 0;
       $rootScope.messages.push({message: "Succesfully logged out", messageClass: "bg-success"});
     }
@@ -50,7 +55,7 @@
 
 
     service.loggedIn = function () {
-      return service.sessionId && service.sessionId !== "";
+      return service.user !== null;
     }
 
     return service;
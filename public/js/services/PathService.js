@@ -13,6 +13,7 @@
                                "polyline",
                                "places",
                                "duration",
+                               "difficulty",
                                "bundleId"]);
       return $http.post(URL, data).then(getPathFromResponse);
     }
@@ -26,6 +27,7 @@
                                "polyline",
                                "places",
                                "duration",
+                               "difficulty",
                                "bundleId"]);
       return $http.put(URL+"/"+path.id, data).then(getPathFromResponse);
     }
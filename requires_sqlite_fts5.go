@@ -0,0 +1,11 @@
+//go:build !sqlite_fts5
+// +build !sqlite_fts5
+
+package main
+
+// Search needs the FTS5 extension of SQLite, which github.com/mattn/go-sqlite3
+// only compiles with the sqlite_fts5 build tag. Without the tag the build fails
+// here, instead of the migrations failing when the server starts:
+//
+//	go build --tags "sqlite_fts5" -o hiking_trails
+var _ = the_sqlite_fts5_build_tag_is_required
@@ -0,0 +1,362 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"os"
+	"strings"
+	"time"
+)
+
+// Settings of the server. Each setting is read from, in increasing order of
+// precedence, the defaults, the TOML configuration file, an environment
+// variable and a command line flag. The environment variable of a flag is its
+// name in upper case with the prefix HIKING_TRAILS_, so -listen-address is
+// also set by HIKING_TRAILS_LISTEN_ADDRESS.
+
+const (
+	DEFAULT_CONFIG_FILE = "hiking_trails.toml"
+	ENVIRONMENT_PREFIX  = "HIKING_TRAILS_"
+)
+
+type Config struct {
+	ListenAddress string `toml:"listen_address"`
+
+	// Directory with the frontend, served as static files.
+	StaticRoot string `toml:"static_root"`
+
+	// Directory with SRTM .hgt tiles used to fill in missing altitudes.
+	ElevationTiles string `toml:"elevation_tiles"`
+
+	// How many elevation tiles are kept in memory. An SRTM1 tile takes about
+	// 25 MB.
+	ElevationCachedTiles int `toml:"elevation_cached_tiles"`
+
+	// Google Maps API key, filled in on the index page.
+	MapsAPIKey string `toml:"maps_api_key"`
+
+	WalkingSpeed  WalkingSpeedConfig  `toml:"walking_speed"`
+	Database      DatabaseConfig      `toml:"database"`
+	Session       SessionConfig       `toml:"session"`
+	Login         LoginConfig         `toml:"login"`
+	OIDC          OIDCConfig          `toml:"oidc"`
+	Administrator AdministratorConfig `toml:"administrator"`
+}
+
+// Model used to estimate hiking times of paths, Naismith's rule by default.
+// Times are estimated when paths are saved.
+type WalkingSpeedConfig struct {
+	// Horizontal walking speed in km/h.
+	SpeedKmh float64 `toml:"speed_kmh"`
+
+	// Meters of ascent that add one hour of walking. Zero ignores ascent.
+	AscentMetersPerHour float64 `toml:"ascent_meters_per_hour"`
+}
+
+type DatabaseConfig struct {
+	// Either sqlite3 or postgres. Empty selects postgres for postgres:// URLs
+	// and sqlite3 otherwise.
+	Driver string `toml:"driver"`
+
+	// SQLite database file or PostgreSQL URL.
+	Source string `toml:"source"`
+}
+
+type SessionConfig struct {
+	// Either database or memory, where sessions are lost on restart.
+	Store string `toml:"store"`
+
+	// Sessions expire this long after login, or after being idle for the idle
+	// timeout, whichever comes first.
+	Lifetime    Duration `toml:"lifetime"`
+	IdleTimeout Duration `toml:"idle_timeout"`
+
+	// How often expired sessions are deleted from the store.
+	ReapInterval Duration `toml:"reap_interval"`
+
+	// Only send the session cookie over HTTPS.
+	CookieSecure bool   `toml:"cookie_secure"`
+	CookieDomain string `toml:"cookie_domain"`
+
+	// Hide the session cookie from scripts.
+	CookieHTTPOnly bool `toml:"cookie_http_only"`
+
+	// Either Strict, Lax or None, or empty to leave out the SameSite
+	// attribute. None requires CookieSecure.
+	CookieSameSite string `toml:"cookie_same_site"`
+}
+
+// Throttling of failed logins. Logins for a username or from an IP address are
+// delayed increasingly after half the maximum number of failures, and locked
+// out for the lockout duration at the maximum.
+type LoginConfig struct {
+	MaxFailures      int      `toml:"max_failures"`
+	MaxFailuresPerIP int      `toml:"max_failures_per_ip"`
+	LockoutDuration  Duration `toml:"lockout_duration"`
+}
+
+// Login with an OpenID Connect identity provider, in addition to passwords.
+type OIDCConfig struct {
+	// Issuer URL of the identity provider, or empty to disable the login.
+	Issuer       string `toml:"issuer"`
+	ClientId     string `toml:"client_id"`
+	ClientSecret string `toml:"client_secret"`
+
+	// Full URL of /api/v1/auth/oidc/callback, as registered at the identity
+	// provider.
+	RedirectURL string `toml:"redirect_url"`
+
+	// Claim used as username of users created on their first login.
+	UsernameClaim string `toml:"username_claim"`
+
+	// Claim with the groups of the user, a string or an array. Users in the
+	// administrator or editor group get that role on every login, others are
+	// viewers. Roles are managed locally if empty.
+	GroupsClaim        string `toml:"groups_claim"`
+	AdministratorGroup string `toml:"administrator_group"`
+	EditorGroup        string `toml:"editor_group"`
+}
+
+// Credentials of the administrator created by the create-admin command.
+type AdministratorConfig struct {
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+}
+
+// Duration written like "48h" or "30m" in the configuration file, environment
+// and flags.
+type Duration time.Duration
+
+func (duration Duration) String() string {
+	return time.Duration(duration).String()
+}
+
+func (duration *Duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*duration = Duration(parsed)
+	return nil
+}
+
+func (duration *Duration) UnmarshalText(text []byte) error {
+	return duration.Set(string(text))
+}
+
+func Default() *Config {
+	return &Config{
+		ListenAddress:        ":3000",
+		StaticRoot:           "public",
+		ElevationCachedTiles: 16,
+		WalkingSpeed: WalkingSpeedConfig{
+			SpeedKmh:            5,
+			AscentMetersPerHour: 600,
+		},
+		Database: DatabaseConfig{
+			Source: "hiking_trails.sqlite3",
+		},
+		Session: SessionConfig{
+			Store:          "database",
+			Lifetime:       Duration(48 * time.Hour),
+			IdleTimeout:    Duration(12 * time.Hour),
+			ReapInterval:   Duration(10 * time.Minute),
+			CookieHTTPOnly: true,
+			CookieSameSite: "Lax",
+		},
+		Login: LoginConfig{
+			MaxFailures:      10,
+			MaxFailuresPerIP: 100,
+			LockoutDuration:  Duration(15 * time.Minute),
+		},
+		OIDC: OIDCConfig{
+			UsernameClaim: "preferred_username",
+		},
+		Administrator: AdministratorConfig{
+			Username: "admin",
+		},
+	}
+}
+
+// Loads the configuration for the command line arguments, without the program
+// name, and returns it with the arguments remaining after the flags.
+func Load(arguments []string) (*Config, []string, error) {
+	config := Default()
+	flags := config.flagSet()
+
+	err := flags.Parse(arguments)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Flags are applied last, so the values given on the command line are
+	// remembered and the configuration reset before reading the file.
+	explicit := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+	*config = *Default()
+
+	err = config.loadFile(explicit["config"])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var setErr error
+	flags.VisitAll(func(f *flag.Flag) {
+		variable := EnvironmentVariable(f.Name)
+		value, exist := os.LookupEnv(variable)
+		if exist && setErr == nil {
+			err := flags.Set(f.Name, value)
+			if err != nil {
+				setErr = fmt.Errorf("Invalid value '%s' of %s: %s", value, variable, err)
+			}
+		}
+	})
+
+	for name, value := range explicit {
+		if setErr == nil {
+			setErr = flags.Set(name, value)
+		}
+	}
+
+	if setErr != nil {
+		return nil, nil, setErr
+	}
+
+	config.Database.Driver = config.databaseDriver()
+
+	err = config.validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return config, flags.Args(), nil
+}
+
+func EnvironmentVariable(flagName string) string {
+	return ENVIRONMENT_PREFIX + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+func (config *Config) flagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	// Only used to locate the file, the value is not stored in the configuration.
+	flags.String("config", "", "TOML configuration file (default "+DEFAULT_CONFIG_FILE+" if it exists)")
+
+	flags.StringVar(&config.ListenAddress, "listen-address", config.ListenAddress, "Address and port to listen on")
+	flags.StringVar(&config.StaticRoot, "static-root", config.StaticRoot, "Directory with the frontend")
+	flags.StringVar(&config.ElevationTiles, "elevation-tiles", config.ElevationTiles, "Directory with SRTM .hgt tiles used to fill in missing altitudes")
+	flags.IntVar(&config.ElevationCachedTiles, "elevation-cached-tiles", config.ElevationCachedTiles, "How many elevation tiles are kept in memory, about 25 MB each")
+	flags.StringVar(&config.MapsAPIKey, "maps-api-key", config.MapsAPIKey, "Google Maps API key")
+	flags.Float64Var(&config.WalkingSpeed.SpeedKmh, "walking-speed-kmh", config.WalkingSpeed.SpeedKmh, "Walking speed on flat ground used to estimate hiking times")
+	flags.Float64Var(&config.WalkingSpeed.AscentMetersPerHour, "walking-ascent-meters-per-hour", config.WalkingSpeed.AscentMetersPerHour, "Meters of ascent that add one hour to hiking times, 0 to ignore ascent")
+	flags.StringVar(&config.Database.Driver, "database-driver", config.Database.Driver, "Database driver, sqlite3 or postgres (default postgres for postgres:// URLs, otherwise sqlite3)")
+	flags.StringVar(&config.Database.Source, "database", config.Database.Source, "SQLite database file, or a postgres:// URL of a PostgreSQL database with PostGIS")
+	flags.StringVar(&config.Session.Store, "session-store", config.Session.Store, "Where sessions are kept, database or memory")
+	flags.Var(&config.Session.Lifetime, "session-lifetime", "How long sessions last after login")
+	flags.Var(&config.Session.IdleTimeout, "session-idle-timeout", "How long sessions last without requests")
+	flags.Var(&config.Session.ReapInterval, "session-reap-interval", "How often expired sessions are deleted")
+	flags.BoolVar(&config.Session.CookieSecure, "cookie-secure", config.Session.CookieSecure, "Only send the session cookie over HTTPS")
+	flags.StringVar(&config.Session.CookieDomain, "cookie-domain", config.Session.CookieDomain, "Domain of the session cookie")
+	flags.BoolVar(&config.Session.CookieHTTPOnly, "cookie-http-only", config.Session.CookieHTTPOnly, "Hide the session cookie from scripts")
+	flags.StringVar(&config.Session.CookieSameSite, "cookie-same-site", config.Session.CookieSameSite, "SameSite attribute of the session cookie, Strict, Lax or None, or empty to leave out")
+	flags.IntVar(&config.Login.MaxFailures, "login-max-failures", config.Login.MaxFailures, "Failed logins for a username before it is locked out")
+	flags.IntVar(&config.Login.MaxFailuresPerIP, "login-max-failures-per-ip", config.Login.MaxFailuresPerIP, "Failed logins from an IP address before it is locked out")
+	flags.Var(&config.Login.LockoutDuration, "login-lockout-duration", "How long logins are locked out, and failed logins remembered")
+	flags.StringVar(&config.OIDC.Issuer, "oidc-issuer", config.OIDC.Issuer, "Issuer URL of the OpenID Connect identity provider (default login with OpenID Connect disabled)")
+	flags.StringVar(&config.OIDC.ClientId, "oidc-client-id", config.OIDC.ClientId, "Client id at the OpenID Connect identity provider")
+	flags.StringVar(&config.OIDC.ClientSecret, "oidc-client-secret", config.OIDC.ClientSecret, "Client secret at the OpenID Connect identity provider")
+	flags.StringVar(&config.OIDC.RedirectURL, "oidc-redirect-url", config.OIDC.RedirectURL, "Full URL of /api/v1/auth/oidc/callback registered at the identity provider")
+	flags.StringVar(&config.OIDC.UsernameClaim, "oidc-username-claim", config.OIDC.UsernameClaim, "Claim used as username of new users")
+	flags.StringVar(&config.OIDC.GroupsClaim, "oidc-groups-claim", config.OIDC.GroupsClaim, "Claim with the groups of the user, which set the role on every login (default roles managed locally)")
+	flags.StringVar(&config.OIDC.AdministratorGroup, "oidc-administrator-group", config.OIDC.AdministratorGroup, "Group of users with the administrator role")
+	flags.StringVar(&config.OIDC.EditorGroup, "oidc-editor-group", config.OIDC.EditorGroup, "Group of users with the editor role")
+	flags.StringVar(&config.Administrator.Username, "admin-username", config.Administrator.Username, "Username of the administrator created by create-admin")
+	flags.StringVar(&config.Administrator.Password, "admin-password", config.Administrator.Password, "Password of the administrator created by create-admin (default read from standard input)")
+
+	return flags
+}
+
+// Reads the file given by the -config flag, by HIKING_TRAILS_CONFIG or the
+// default file. Only a missing default file is not an error.
+func (config *Config) loadFile(fileName string) error {
+	if fileName == "" {
+		fileName = os.Getenv(EnvironmentVariable("config"))
+	}
+
+	if fileName == "" {
+		_, err := os.Stat(DEFAULT_CONFIG_FILE)
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		fileName = DEFAULT_CONFIG_FILE
+	}
+
+	_, err := toml.DecodeFile(fileName, config)
+	if err != nil {
+		return fmt.Errorf("Failed to read configuration file '%s': %s", fileName, err)
+	}
+
+	return nil
+}
+
+func (config *Config) validate() error {
+	if config.WalkingSpeed.SpeedKmh <= 0 || config.WalkingSpeed.AscentMetersPerHour < 0 {
+		return fmt.Errorf("Walking speed must be larger than 0 and ascent per hour not less than 0")
+	}
+
+	if config.ElevationCachedTiles < 1 {
+		return fmt.Errorf("At least 1 elevation tile must be cached")
+	}
+
+	switch config.Session.Store {
+	case "database", "memory":
+	default:
+		return fmt.Errorf("Unknown session store '%s', expected database or memory", config.Session.Store)
+	}
+
+	switch config.Session.CookieSameSite {
+	case "", "Strict", "Lax":
+	case "None":
+		if !config.Session.CookieSecure {
+			return fmt.Errorf("SameSite None requires secure session cookies")
+		}
+	default:
+		return fmt.Errorf("Unknown SameSite attribute '%s', expected Strict, Lax or None", config.Session.CookieSameSite)
+	}
+
+	if config.Session.ReapInterval <= 0 {
+		return fmt.Errorf("Session reap interval must be larger than 0")
+	}
+
+	if config.Login.MaxFailures <= 0 || config.Login.MaxFailuresPerIP <= 0 {
+		return fmt.Errorf("Maximum numbers of failed logins must be larger than 0")
+	}
+
+	if config.Login.LockoutDuration <= 0 {
+		return fmt.Errorf("Login lockout duration must be larger than 0")
+	}
+
+	if config.OIDC.Issuer != "" && (config.OIDC.ClientId == "" || config.OIDC.RedirectURL == "") {
+		return fmt.Errorf("OpenID Connect login requires a client id and a redirect URL")
+	}
+
+	return nil
+}
+
+func (config *Config) databaseDriver() string {
+	if config.Database.Driver != "" {
+		return config.Database.Driver
+	}
+
+	source := config.Database.Source
+	if strings.HasPrefix(source, "postgres://") || strings.HasPrefix(source, "postgresql://") {
+		return "postgres"
+	}
+
+	return "sqlite3"
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"database/sql"
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	"hiking_trails/src/middleware"
+	"hiking_trails/src/models"
+	"log"
+)
+
+// The token is only part of the response of this request.
+func APITokensControllerCreate(token models.APIToken, session *middleware.Session, render render.Render,
+	db *sql.DB, logger *log.Logger) {
+
+	token.UserId = session.Get("userId").(int64)
+
+	err := token.Save(db)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(201, token)
+}
+
+func APITokensControllerList(session *middleware.Session, render render.Render, db *sql.DB, logger *log.Logger) {
+	tokens, err := models.ListAPITokens(db, session.Get("userId").(int64))
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(200, tokens)
+}
+
+func APITokensControllerRevoke(params martini.Params, session *middleware.Session, render render.Render,
+	db *sql.DB, logger *log.Logger) {
+
+	id, err := MustGetIdFromParameters(params, logger)
+	if err == nil {
+		err = models.RevokeAPIToken(db, session.Get("userId").(int64), id)
+	}
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(204, "")
+}
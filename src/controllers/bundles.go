@@ -2,13 +2,20 @@ package controllers
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/go-martini/martini"
+	"github.com/martini-contrib/binding"
 	"github.com/martini-contrib/render"
 	"hiking_trails/src/models"
 	"log"
+	"net/http"
 )
 
-func BundlesControllerCreate(bundle models.Bundle, render render.Render, db *sql.DB, logger *log.Logger) {
+// The user creating the bundle becomes its owner.
+func BundlesControllerCreate(bundle models.Bundle, currentUser *models.User, render render.Render, db *sql.DB,
+	logger *log.Logger) {
+
+	bundle.OwnerId = currentUser.Id
 	err := models.Save(&bundle, db)
 
 	if err != nil {
@@ -19,13 +26,21 @@ func BundlesControllerCreate(bundle models.Bundle, render render.Render, db *sql
 	render.JSON(201, bundle)
 }
 
-func BundlesControllerRead(params martini.Params, render render.Render, db *sql.DB, logger *log.Logger) {
+func BundlesControllerRead(params martini.Params, request *http.Request, render render.Render, db *sql.DB,
+	logger *log.Logger) {
+
 	id, err := MustGetIdFromParameters(params, logger)
 	if err != nil {
 		renderErrorAsJson(err, render, logger)
 		return
 	}
 
+	format, err := getPolylineFormatFromQuery(request)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
 	bundle := models.NewBundle()
 	bundle.Id = id
 	err = models.Load(bundle, db)
@@ -35,6 +50,12 @@ func BundlesControllerRead(params martini.Params, render render.Render, db *sql.
 		return
 	}
 
+	if wantsGeoJSON(request) {
+		renderGeoJSON(200, models.GeoJSONFromBundle(bundle), render, logger)
+		return
+	}
+
+	format.apply(bundle.Paths)
 	render.JSON(200, bundle)
 }
 
@@ -79,7 +100,25 @@ func BundlesControllerDelete(params martini.Params, render render.Render, db *sq
 	render.JSON(204, "")
 }
 
-func BundlesControllerList(render render.Render, db *sql.DB, logger *log.Logger) {
+func BundlesControllerList(request *http.Request, render render.Render, db *sql.DB, logger *log.Logger) {
+	generalization, err := getPolylineGeneralizationFromQuery(request)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	format, err := getPolylineFormatFromQuery(request)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	list, err := getListQueryFromQuery(request, models.Bundle{}, models.BUNDLE_SORT_COLUMNS, "paths")
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
 	transaction, err := db.Begin()
 	if err != nil {
 		err = models.NewAPIError(500, "Failed to begin transaction when reading bundle", err)
@@ -87,7 +126,16 @@ func BundlesControllerList(render render.Render, db *sql.DB, logger *log.Logger)
 		return
 	}
 
-	bundles, err := models.LoadBundles(transaction)
+	bundles, total, err := models.LoadBundles(transaction, list.options)
+
+	paths := make([]*models.Path, 0)
+	for _, bundle := range bundles {
+		paths = append(paths, bundle.Paths...)
+	}
+
+	if err == nil {
+		err = generalization.apply(transaction, paths)
+	}
 
 	if err == nil {
 		err = transaction.Commit()
@@ -101,5 +149,167 @@ func BundlesControllerList(render render.Render, db *sql.DB, logger *log.Logger)
 		return
 	}
 
-	render.JSON(200, bundles)
+	if wantsGeoJSON(request) {
+		setTotalCountHeader(render, total)
+		renderGeoJSON(200, models.GeoJSONFromBundles(bundles), render, logger)
+		return
+	}
+
+	format.apply(paths)
+	list.render(bundles, total, render, logger)
+}
+
+func BundlesControllerExportGPX(params martini.Params, render render.Render, db *sql.DB, logger *log.Logger) {
+	id, err := MustGetIdFromParameters(params, logger)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	bundle := models.NewBundle()
+	bundle.Id = id
+	err = models.Load(bundle, db)
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	gpx := models.NewGPXFromPaths(bundle.Name, bundle.Info, bundle.Paths)
+	renderGPX(gpx, fmt.Sprintf("bundle-%d.gpx", bundle.Id), render, logger)
+}
+
+// Renders the bundle as KML, or as KMZ if requested with the '.kmz' suffix.
+func BundlesControllerExportKML(params martini.Params, request *http.Request, render render.Render, db *sql.DB,
+	logger *log.Logger) {
+
+	id, err := MustGetIdFromParameters(params, logger)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	bundle := models.NewBundle()
+	bundle.Id = id
+	err = models.Load(bundle, db)
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	renderKML(models.NewKMLFromBundle(bundle), fmt.Sprintf("bundle-%d", bundle.Id), request, render, logger)
+}
+
+// Creates a bundle, including its paths and places, from a KML document or
+// KMZ archive sent as request body. The importing user becomes its owner.
+func BundlesControllerImportKML(request *http.Request, response http.ResponseWriter, currentUser *models.User,
+	render render.Render, db *sql.DB, logger *log.Logger) {
+
+	kml, err := models.ParseKML(http.MaxBytesReader(response, request.Body, models.MAX_IMPORT_BYTES))
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	bundle, err := kml.AsBundle()
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	errors := bundle.Validate(binding.Errors{}, request)
+	errors = validateImportedPaths(bundle.Paths, errors, request)
+
+	if len(errors) > 0 {
+		render.JSON(422, errors)
+		return
+	}
+
+	bundle.OwnerId = currentUser.Id
+
+	err = models.Save(bundle, db)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(201, bundle)
+}
+
+func BundlesControllerListPermissions(params martini.Params, render render.Render, db *sql.DB, logger *log.Logger) {
+	id, err := MustGetIdFromParameters(params, logger)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	permissions, err := models.ListBundlePermissions(db, id)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(200, permissions)
+}
+
+// Owners can not change their own permission, so that a bundle does not lose
+// its last owner by accident.
+func BundlesControllerGrantPermission(params martini.Params, permission models.BundlePermission,
+	currentUser *models.User, render render.Render, db *sql.DB, logger *log.Logger) {
+
+	id, userId, err := getBundlePermissionIdsFromParameters(params, currentUser, logger)
+
+	user := &models.User{Id: userId}
+	if err == nil {
+		err = models.Load(user, db)
+	}
+
+	if err == nil {
+		permission.BundleId, permission.UserId, permission.Username = id, user.Id, user.Username
+		err = permission.Grant(db)
+	}
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(200, permission)
+}
+
+func BundlesControllerRevokePermission(params martini.Params, currentUser *models.User, render render.Render,
+	db *sql.DB, logger *log.Logger) {
+
+	id, userId, err := getBundlePermissionIdsFromParameters(params, currentUser, logger)
+	if err == nil {
+		err = models.RevokeBundlePermission(db, id, userId)
+	}
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(204, "")
+}
+
+func getBundlePermissionIdsFromParameters(params martini.Params, currentUser *models.User,
+	logger *log.Logger) (int64, int64, error) {
+
+	id, err := MustGetIdFromParameters(params, logger)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	userId, err := MustGetIdParameter(params, "userId", logger)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if userId == currentUser.Id && !currentUser.HasRole(models.ROLE_ADMINISTRATOR) {
+		return 0, 0, models.NewAPIError(400, "Not allowed to change your own bundle permission", nil)
+	}
+
+	return id, userId, nil
 }
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"database/sql"
+	"github.com/martini-contrib/render"
+	"hiking_trails/src/models"
+	"log"
+)
+
+// Fills in missing altitudes for all existing paths and places using the
+// configured elevation provider.
+func ElevationControllerBackfill(render render.Render, db *sql.DB, logger *log.Logger) {
+	if models.Elevations == nil {
+		err := models.NewAPIError(503, "No elevation provider is configured", nil)
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	transaction, err := db.Begin()
+	if err != nil {
+		LogAndRenderError500(logger, render, "Failed to begin transaction when backfilling elevation", err)
+		return
+	}
+
+	updatedPaths, updatedPlaces, err := backfillElevation(transaction)
+
+	if err == nil {
+		err = transaction.Commit()
+	} else {
+		transaction.Rollback()
+	}
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(200, map[string]int{"paths": updatedPaths, "places": updatedPlaces})
+}
+
+func backfillElevation(transaction *sql.Tx) (int, int, error) {
+	updatedPaths, updatedPlaces := 0, 0
+
+	paths, err := models.LoadPathsFromDatabase(transaction, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, path := range paths {
+		filled, err := path.Polyline.FillMissingAltitudes()
+		if err != nil {
+			return 0, 0, models.NewAPIError(500, "Failed to look up elevation", err)
+		}
+
+		if filled > 0 {
+			err = path.Update(transaction)
+			if err != nil {
+				return 0, 0, err
+			}
+
+			updatedPaths++
+		}
+
+		for _, place := range path.Places {
+			filled, err := place.Position.FillMissingAltitude()
+			if err != nil {
+				return 0, 0, models.NewAPIError(500, "Failed to look up elevation", err)
+			}
+
+			if filled {
+				err = place.Update(transaction)
+				if err != nil {
+					return 0, 0, err
+				}
+
+				updatedPlaces++
+			}
+		}
+	}
+
+	return updatedPaths, updatedPlaces, nil
+}
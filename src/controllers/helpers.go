@@ -5,16 +5,26 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/go-martini/martini"
+	"github.com/martini-contrib/binding"
 	"github.com/martini-contrib/render"
 	"hiking_trails/src/models"
 	"log"
+	"math"
+	"net"
+	"net/http"
+	"reflect"
 	"strconv"
+	"strings"
 )
 
 func MustGetIdFromParameters(params martini.Params, logger *log.Logger) (int64, error) {
-	idString, exist := params["id"]
+	return MustGetIdParameter(params, "id", logger)
+}
+
+func MustGetIdParameter(params martini.Params, name string, logger *log.Logger) (int64, error) {
+	idString, exist := params[name]
 	if !exist {
-		logger.Panicf("Parameter 'id' is not present in params. Router must be misconfigured.")
+		logger.Panicf("Parameter '%s' is not present in params. Router must be misconfigured.", name)
 	}
 
 	id, err := strconv.Atoi(idString)
@@ -25,6 +35,312 @@ func MustGetIdFromParameters(params martini.Params, logger *log.Logger) (int64,
 	return int64(id), nil
 }
 
+// IP address of the client. Proxies in front of the server are not taken
+// into account.
+func remoteIPAddress(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return host
+}
+
+func GetIdFromQuery(request *http.Request, name string) (int64, error) {
+	idString := request.URL.Query().Get(name)
+	if idString == "" {
+		return 0, models.NewAPIError(400, fmt.Sprintf("Query parameter '%s' is required.", name), nil)
+	}
+
+	id, err := strconv.ParseInt(idString, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, models.NewAPIError(400, fmt.Sprintf("%s is not a valid %s.", idString, name), nil)
+	}
+
+	return id, nil
+}
+
+// Parses the area to load paths or places within from either the query
+// parameter bbox=minLng,minLat,maxLng,maxLat or near=lat,lng&radius=meters.
+// Returns nil if neither is given.
+func GetAreaFromQuery(request *http.Request) (*models.GEOArea, error) {
+	query := request.URL.Query()
+	bbox, near := query.Get("bbox"), query.Get("near")
+
+	if bbox != "" && near != "" {
+		return nil, models.NewAPIError(400, "Query parameters 'bbox' and 'near' can not be combined.", nil)
+	}
+
+	if bbox != "" {
+		values, err := parseFloats(bbox, 4)
+		if err != nil {
+			return nil, models.NewAPIError(400, "Query parameter 'bbox' must be minLng,minLat,maxLng,maxLat.", nil)
+		}
+
+		area, err := models.NewGEOAreaFromBoundingBox(models.GEOBoundingBox{
+			MinLongitude: values[0],
+			MinLatitude:  values[1],
+			MaxLongitude: values[2],
+			MaxLatitude:  values[3],
+		})
+		if err != nil {
+			return nil, models.NewAPIError(400, err.Error(), nil)
+		}
+
+		return area, nil
+	}
+
+	if near != "" {
+		values, err := parseFloats(near, 2)
+		if err != nil {
+			return nil, models.NewAPIError(400, "Query parameter 'near' must be lat,lng.", nil)
+		}
+
+		radius, err := strconv.ParseFloat(query.Get("radius"), 64)
+		if err != nil {
+			return nil, models.NewAPIError(400, "Query parameter 'radius' must be given in meters together with 'near'.", nil)
+		}
+
+		area, err := models.NewGEOAreaAroundCoordinate(models.NewGEOCoordinate(float32(values[0]), float32(values[1])), radius)
+		if err != nil {
+			return nil, models.NewAPIError(400, err.Error(), nil)
+		}
+
+		return area, nil
+	}
+
+	return nil, nil
+}
+
+// Generalization of path polylines, requested with either
+// simplify=<tolerance in meters> or zoom=<map zoom level>.
+type polylineGeneralization struct {
+	tolerance float64
+	zoom      int
+}
+
+// Returns nil if no generalization is requested.
+func getPolylineGeneralizationFromQuery(request *http.Request) (*polylineGeneralization, error) {
+	query := request.URL.Query()
+	simplify, zoom := query.Get("simplify"), query.Get("zoom")
+
+	if simplify != "" && zoom != "" {
+		return nil, models.NewAPIError(400, "Query parameters 'simplify' and 'zoom' can not be combined.", nil)
+	}
+
+	if simplify != "" {
+		tolerance, err := strconv.ParseFloat(simplify, 64)
+		if err != nil || math.IsNaN(tolerance) || math.IsInf(tolerance, 0) || tolerance <= 0 {
+			return nil, models.NewAPIError(400, "Query parameter 'simplify' must be a tolerance in meters larger than 0.", nil)
+		}
+
+		return &polylineGeneralization{tolerance: tolerance}, nil
+	}
+
+	if zoom != "" {
+		level, err := strconv.Atoi(zoom)
+		if err != nil || level < 0 || level > models.MAX_ZOOM_LEVEL {
+			return nil, models.NewAPIError(400,
+				fmt.Sprintf("Query parameter 'zoom' must be a zoom level between 0 and %d.", models.MAX_ZOOM_LEVEL), nil)
+		}
+
+		return &polylineGeneralization{zoom: level}, nil
+	}
+
+	return nil, nil
+}
+
+func (generalization *polylineGeneralization) apply(queryer models.SQLQueryer, paths []*models.Path) error {
+	if generalization == nil {
+		return nil
+	}
+
+	if generalization.tolerance > 0 {
+		models.SimplifyPolylines(paths, generalization.tolerance)
+		return nil
+	}
+
+	return models.GeneralizePolylines(queryer, paths, generalization.zoom)
+}
+
+const (
+	POLYLINE_FORMAT_ARRAY   = "array"
+	POLYLINE_FORMAT_ENCODED = "encoded"
+)
+
+// Format of path polylines in responses, requested with
+// polylineFormat=array|encoded. Defaults to array.
+type polylineFormat string
+
+func getPolylineFormatFromQuery(request *http.Request) (polylineFormat, error) {
+	format := request.URL.Query().Get("polylineFormat")
+
+	switch format {
+	case "", POLYLINE_FORMAT_ARRAY:
+		return POLYLINE_FORMAT_ARRAY, nil
+	case POLYLINE_FORMAT_ENCODED:
+		return POLYLINE_FORMAT_ENCODED, nil
+	}
+
+	return "", models.NewAPIError(400, fmt.Sprintf("Query parameter 'polylineFormat' must be either '%s' or '%s'.",
+		POLYLINE_FORMAT_ARRAY, POLYLINE_FORMAT_ENCODED), nil)
+}
+
+func (format polylineFormat) apply(paths []*models.Path) {
+	if format == POLYLINE_FORMAT_ENCODED {
+		models.EncodePolylines(paths)
+	}
+}
+
+const (
+	TOTAL_COUNT_HEADER = "X-Total-Count"
+
+	// Larger limits are lowered to this, a limit of 0 still returns the whole
+	// list.
+	MAX_LIST_LIMIT = 1000
+)
+
+// Paging, sorting and field selection of lists, requested with
+// limit=<n>&offset=<n>, sort=<field>,-<field> and fields=<field>,<field>.
+type listQuery struct {
+	options *models.ListOptions
+
+	// Nil if all fields are selected.
+	fields map[string]bool
+}
+
+// Sort fields and column names are given by sortColumns. Selectable fields are
+// the JSON fields of item. Children are the field with nested objects, which
+// are not loaded unless selected.
+func getListQueryFromQuery(request *http.Request, item interface{}, sortColumns map[string]string,
+	children string) (*listQuery, error) {
+
+	query := request.URL.Query()
+	list := &listQuery{options: &models.ListOptions{}}
+
+	for name, value := range map[string]*int64{"limit": &list.options.Limit, "offset": &list.options.Offset} {
+		if query.Get(name) == "" {
+			continue
+		}
+
+		number, err := strconv.ParseInt(query.Get(name), 10, 64)
+		if err != nil || number < 0 {
+			return nil, models.NewAPIError(400, fmt.Sprintf("Query parameter '%s' must be a number not less than 0.", name), nil)
+		}
+
+		*value = number
+	}
+
+	if list.options.Limit > MAX_LIST_LIMIT {
+		list.options.Limit = MAX_LIST_LIMIT
+	}
+
+	if query.Get("sort") != "" {
+		for _, field := range strings.Split(query.Get("sort"), ",") {
+			order := models.SortOrder{Descending: strings.HasPrefix(field, "-")}
+
+			column, exist := sortColumns[strings.TrimPrefix(field, "-")]
+			if !exist {
+				return nil, models.NewAPIError(400, fmt.Sprintf("Can not sort by '%s'.", field), nil)
+			}
+
+			order.Column = column
+			list.options.Sort = append(list.options.Sort, order)
+		}
+	}
+
+	// GeoJSON features always have all properties.
+	if query.Get("fields") != "" && !wantsGeoJSON(request) {
+		selectable := jsonFieldNames(item)
+		list.fields = make(map[string]bool)
+
+		for _, field := range strings.Split(query.Get("fields"), ",") {
+			if !selectable[field] {
+				return nil, models.NewAPIError(400, fmt.Sprintf("Unknown field '%s'.", field), nil)
+			}
+
+			list.fields[field] = true
+		}
+
+		list.options.WithoutChildren = !list.fields[children]
+		list.options.WithoutPolylines = selectable["polyline"] && !list.fields["polyline"] &&
+			!list.fields["encodedPolyline"]
+	}
+
+	return list, nil
+}
+
+func jsonFieldNames(item interface{}) map[string]bool {
+	names := make(map[string]bool)
+	itemType := reflect.TypeOf(item)
+
+	for i := 0; i < itemType.NumField(); i++ {
+		name := strings.Split(itemType.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+
+	return names
+}
+
+// Renders the items with only the selected fields, and the total number of
+// items in the list in the X-Total-Count header.
+func (list *listQuery) render(items interface{}, total int64, render render.Render, logger *log.Logger) {
+	setTotalCountHeader(render, total)
+
+	if list.fields == nil {
+		render.JSON(200, items)
+		return
+	}
+
+	data, err := json.Marshal(items)
+
+	objects := make([]map[string]json.RawMessage, 0)
+	if err == nil {
+		err = json.Unmarshal(data, &objects)
+	}
+
+	if err != nil {
+		err = models.NewAPIError(500, "Failed to select fields", err)
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	for _, object := range objects {
+		for field := range object {
+			if !list.fields[field] {
+				delete(object, field)
+			}
+		}
+	}
+
+	render.JSON(200, objects)
+}
+
+func setTotalCountHeader(render render.Render, total int64) {
+	render.Header().Set(TOTAL_COUNT_HEADER, strconv.FormatInt(total, 10))
+}
+
+func parseFloats(commaSeparated string, count int) ([]float64, error) {
+	parts := strings.Split(commaSeparated, ",")
+	if len(parts) != count {
+		return nil, fmt.Errorf("Expected %d values, got %d", count, len(parts))
+	}
+
+	values := make([]float64, 0, count)
+	for _, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
 func MustGetLastInsertedId(result sql.Result, logger *log.Logger) int64 {
 	lastInsertedId, err := result.LastInsertId()
 	if err != nil {
@@ -49,6 +365,46 @@ func LogAndRenderError500(logger *log.Logger, render render.Render, message stri
 	render.JSON(500, apiError)
 }
 
+// Imported paths have not been validated by the binding middleware, so
+// validate them and their places before saving.
+func validateImportedPaths(paths []*models.Path, errors binding.Errors, request *http.Request) binding.Errors {
+	for _, path := range paths {
+		errors = path.Validate(errors, request)
+
+		for _, place := range path.Places {
+			errors = place.Validate(errors, request)
+		}
+	}
+
+	return errors
+}
+
+// Renders data as a file download, e.g. an exported GPX document.
+func renderAttachment(render render.Render, contentType string, filename string, data []byte) {
+	render.Header().Set("Content-Type", contentType)
+	render.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	render.Data(200, data)
+}
+
+// Clients ask for GeoJSON either with the Accept header or by adding a
+// '.geojson' suffix to the resource URL.
+func wantsGeoJSON(request *http.Request) bool {
+	return strings.HasSuffix(request.URL.Path, ".geojson") ||
+		strings.Contains(request.Header.Get("Accept"), models.GEOJSON_CONTENT_TYPE)
+}
+
+func renderGeoJSON(status int, value interface{}, render render.Render, logger *log.Logger) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		err = models.NewAPIError(500, "Failed to encode GeoJSON", err)
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.Header().Set("Content-Type", models.GEOJSON_CONTENT_TYPE)
+	render.Data(status, data)
+}
+
 func printAsJson(data interface{}) {
 	asJson, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"hiking_trails/src/models"
+	"net/http"
+	"testing"
+)
+
+func TestPolylineFormatOfRequest(t *testing.T) {
+	tests := []struct {
+		query  string
+		format polylineFormat
+		status int
+	}{
+		{"", POLYLINE_FORMAT_ARRAY, 0},
+		{"?polylineFormat=array", POLYLINE_FORMAT_ARRAY, 0},
+		{"?polylineFormat=encoded", POLYLINE_FORMAT_ENCODED, 0},
+		{"?polylineFormat=geojson", "", 400},
+	}
+
+	for _, test := range tests {
+		request, err := http.NewRequest("GET", "/api/v1/paths"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		format, err := getPolylineFormatFromQuery(request)
+
+		status := 0
+		if apiError, isApiError := err.(*models.APIError); isApiError {
+			status = apiError.Status
+		}
+
+		if format != test.format || status != test.status {
+			t.Errorf("Format of '%s' is '%s' with error %v", test.query, format, err)
+		}
+	}
+}
+
+func TestAppliesPolylineFormat(t *testing.T) {
+	polyline := models.GEOCoordinates{models.NewGEOCoordinate(38.5, -120.2)}
+	paths := []*models.Path{{Polyline: polyline}}
+
+	polylineFormat(POLYLINE_FORMAT_ARRAY).apply(paths)
+	if paths[0].EncodedPolyline != "" {
+		t.Errorf("Array format encoded polyline as '%s'", paths[0].EncodedPolyline)
+	}
+
+	polylineFormat(POLYLINE_FORMAT_ENCODED).apply(paths)
+	if paths[0].EncodedPolyline != "_p~iF~ps|U" {
+		t.Errorf("Encoded format encoded polyline as '%s'", paths[0].EncodedPolyline)
+	}
+}
+
+func TestAreaOfRequest(t *testing.T) {
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"", 0},
+		{"?bbox=24,62,25,63", 0},
+		{"?near=62.5,24.5&radius=1000", 0},
+		{"?bbox=25,62,24,63", 400},
+		{"?bbox=NaN,62,25,63", 400},
+		{"?bbox=24,62,Inf,63", 400},
+		{"?near=62.5,24.5", 400},
+		{"?near=62.5,24.5&radius=0", 400},
+		{"?near=62.5,24.5&radius=NaN", 400},
+		{"?near=62.5,24.5&radius=Inf", 400},
+		{"?near=62.5,24.5&radius=-Inf", 400},
+		{"?near=NaN,24.5&radius=1000", 400},
+		{"?near=1e300,24.5&radius=1000", 400},
+	}
+
+	for _, test := range tests {
+		request, err := http.NewRequest("GET", "/api/v1/paths"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = GetAreaFromQuery(request)
+
+		status := 0
+		if apiError, isApiError := err.(*models.APIError); isApiError {
+			status = apiError.Status
+		}
+
+		if status != test.status {
+			t.Errorf("Area of '%s' has error %v, expected status %d", test.query, err, test.status)
+		}
+	}
+}
+
+func TestPolylineGeneralizationOfRequest(t *testing.T) {
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"", 0},
+		{"?simplify=10", 0},
+		{"?zoom=12", 0},
+		{"?simplify=10&zoom=12", 400},
+		{"?simplify=0", 400},
+		{"?simplify=-10", 400},
+		{"?simplify=NaN", 400},
+		{"?simplify=Inf", 400},
+		{"?simplify=-Inf", 400},
+		{"?simplify=1e400", 400},
+		{"?zoom=-1", 400},
+	}
+
+	for _, test := range tests {
+		request, err := http.NewRequest("GET", "/api/v1/paths"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = getPolylineGeneralizationFromQuery(request)
+
+		status := 0
+		if apiError, isApiError := err.(*models.APIError); isApiError {
+			status = apiError.Status
+		}
+
+		if status != test.status {
+			t.Errorf("Generalization of '%s' has error %v, expected status %d", test.query, err, test.status)
+		}
+	}
+}
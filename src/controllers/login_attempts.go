@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"database/sql"
+	"github.com/martini-contrib/render"
+	"hiking_trails/src/models"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Lists login attempts, newest first unless sorted otherwise. Filtered by the
+// query parameters username, ipAddress and succeeded=true|false.
+func LoginAttemptsControllerList(request *http.Request, render render.Render, db *sql.DB, logger *log.Logger) {
+	filter, err := getLoginAttemptFilterFromQuery(request)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	list, err := getListQueryFromQuery(request, models.LoginAttempt{}, models.LOGIN_ATTEMPT_SORT_COLUMNS, "")
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	if len(list.options.Sort) == 0 {
+		list.options.Sort = []models.SortOrder{{Column: "attempted_at", Descending: true}}
+	}
+
+	attempts, total, err := models.ListLoginAttempts(db, filter, list.options)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	list.render(attempts, total, render, logger)
+}
+
+func getLoginAttemptFilterFromQuery(request *http.Request) (*models.LoginAttemptFilter, error) {
+	query := request.URL.Query()
+	filter := &models.LoginAttemptFilter{Username: query.Get("username"), IPAddress: query.Get("ipAddress")}
+
+	if query.Get("succeeded") != "" {
+		succeeded, err := strconv.ParseBool(query.Get("succeeded"))
+		if err != nil {
+			return nil, models.NewAPIError(400, "Query parameter 'succeeded' must be either 'true' or 'false'.", nil)
+		}
+
+		filter.Succeeded = &succeeded
+	}
+
+	return filter, nil
+}
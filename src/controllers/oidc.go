@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"github.com/martini-contrib/render"
+	"hiking_trails/src/config"
+	"hiking_trails/src/middleware"
+	"hiking_trails/src/models"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// How long users have to log in at the identity provider.
+	OIDC_LOGIN_TIMEOUT = 10 * time.Minute
+
+	OIDC_STATE_KEY         = "oidcState"
+	OIDC_NONCE_KEY         = "oidcNonce"
+	OIDC_CODE_VERIFIER_KEY = "oidcCodeVerifier"
+)
+
+// Sends the browser to the identity provider. The state, nonce and PKCE code
+// verifier of the login are kept in a stored session without a user, referred
+// to by its own cookie.
+func OIDCControllerLogin(provider *models.OIDCProvider, store middleware.SessionStore, settings config.SessionConfig,
+	request *http.Request, response http.ResponseWriter, render render.Render, logger *log.Logger) {
+
+	login := middleware.NewSession(store)
+	login.Set(OIDC_STATE_KEY, models.MustGenerateOIDCSecret())
+	login.Set(OIDC_NONCE_KEY, models.MustGenerateOIDCSecret())
+	login.Set(OIDC_CODE_VERIFIER_KEY, models.MustGenerateOIDCSecret())
+
+	authorizationURL, err := provider.AuthorizationURL(login.Get(OIDC_STATE_KEY).(string),
+		login.Get(OIDC_NONCE_KEY).(string), login.Get(OIDC_CODE_VERIFIER_KEY).(string))
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	err = login.Create()
+	if err != nil {
+		LogAndRenderError500(logger, render, "Failed to store OpenID Connect login", err)
+		return
+	}
+
+	middleware.SetOIDCLoginCookie(response, login.Id, OIDC_LOGIN_TIMEOUT, settings)
+	http.Redirect(response, request, authorizationURL, 302)
+}
+
+// The identity provider redirects back here. Logs in the user of the ID token
+// like UsersControllerLogin, creating the user on the first login, and sends
+// the browser to the frontend.
+func OIDCControllerCallback(provider *models.OIDCProvider, oidcSettings config.OIDCConfig, store middleware.SessionStore,
+	settings config.SessionConfig, request *http.Request, response http.ResponseWriter, render render.Render, db *sql.DB,
+	logger *log.Logger) {
+
+	claims, err := finishOIDCLogin(provider, store, settings, request, response, logger)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	user, err := loadOrCreateOIDCUser(claims, oidcSettings, db)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	recordLoginAttempt(db, user.Username, remoteIPAddress(request), true, logger)
+
+	_, err = startSession(user, store, settings, response)
+	if err != nil {
+		LogAndRenderError500(logger, render, "Failed to create session", err)
+		return
+	}
+
+	http.Redirect(response, request, "/", 302)
+}
+
+// Checks the state of the login in progress, which can only be finished once,
+// and exchanges the authorization code for the claims of the user.
+func finishOIDCLogin(provider *models.OIDCProvider, store middleware.SessionStore, settings config.SessionConfig,
+	request *http.Request, response http.ResponseWriter, logger *log.Logger) (models.OIDCClaims, error) {
+
+	middleware.ClearOIDCLoginCookie(response, settings)
+
+	cookie, err := request.Cookie(middleware.OIDC_LOGIN_COOKIE_NAME)
+	if err != nil {
+		return nil, models.NewAPIError(400, "No OpenID Connect login in progress", nil)
+	}
+
+	login, err := store.Get(cookie.Value)
+	if err != nil {
+		return nil, models.NewAPIError(500, "Failed to load OpenID Connect login", err)
+	}
+
+	if login.Id == "" || time.Since(login.CreatedAt) > OIDC_LOGIN_TIMEOUT {
+		return nil, models.NewAPIError(400, "OpenID Connect login has expired, log in again", nil)
+	}
+
+	err = store.Delete(login.Id)
+	if err != nil {
+		logger.Printf("Failed to delete OpenID Connect login: %s", err)
+	}
+
+	query := request.URL.Query()
+	if query.Get("error") != "" {
+		message := strings.TrimSpace("Identity provider rejected the login: " + query.Get("error") + " " + query.Get("error_description"))
+		return nil, models.NewAPIError(401, message, nil)
+	}
+
+	state, _ := login.Get(OIDC_STATE_KEY).(string)
+	if state == "" || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+		return nil, models.NewAPIError(400, "Invalid OpenID Connect state", nil)
+	}
+
+	if query.Get("code") == "" {
+		return nil, models.NewAPIError(400, "Query parameter 'code' is required.", nil)
+	}
+
+	nonce, _ := login.Get(OIDC_NONCE_KEY).(string)
+	codeVerifier, _ := login.Get(OIDC_CODE_VERIFIER_KEY).(string)
+
+	return provider.Exchange(query.Get("code"), codeVerifier, nonce)
+}
+
+// Users are matched by subject, never by username, so users of the identity
+// provider can not take over local users with the same name.
+func loadOrCreateOIDCUser(claims models.OIDCClaims, settings config.OIDCConfig, db *sql.DB) (*models.User, error) {
+	role := oidcRole(claims, settings)
+
+	user := &models.User{}
+	err := user.LoadFromOIDCSubject(claims.Subject(), db)
+
+	if apiError, isApiError := err.(*models.APIError); isApiError && apiError.Status == 404 {
+		username := claims.String(settings.UsernameClaim)
+		if username == "" {
+			username = claims.Subject()
+		}
+
+		if len(username) > 255 {
+			return nil, models.NewAPIError(400, "Username of the identity provider is too long", nil)
+		}
+
+		user = models.NewOIDCUser(username, claims.Subject(), role)
+
+		err = models.CheckUsernameAvailable(db, user.Username, 0)
+		if err == nil {
+			err = models.Save(user, db)
+		}
+
+		return user, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Roles are only synchronized when the groups come from the provider.
+	if settings.GroupsClaim != "" && user.Role != role {
+		user.Role = role
+		err = models.Update(user, db)
+	}
+
+	return user, err
+}
+
+func oidcRole(claims models.OIDCClaims, settings config.OIDCConfig) string {
+	role := models.ROLE_VIEWER
+
+	if settings.GroupsClaim == "" {
+		return role
+	}
+
+	for _, group := range claims.Strings(settings.GroupsClaim) {
+		if group == "" {
+			continue
+		}
+
+		if group == settings.AdministratorGroup {
+			return models.ROLE_ADMINISTRATOR
+		}
+
+		if group == settings.EditorGroup {
+			role = models.ROLE_EDITOR
+		}
+	}
+
+	return role
+}
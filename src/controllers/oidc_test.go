@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"encoding/json"
+	"hiking_trails/src/config"
+	"hiking_trails/src/middleware"
+	"hiking_trails/src/models"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Identity provider that rejects every authorization code.
+func newRejectingOIDCIssuer() *httptest.Server {
+	var server *httptest.Server
+
+	server = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		value := map[string]string{"error": "invalid_grant"}
+		if request.URL.Path == "/.well-known/openid-configuration" {
+			value = map[string]string{
+				"issuer":                 server.URL,
+				"authorization_endpoint": server.URL + "/authorize",
+				"token_endpoint":         server.URL + "/token",
+				"jwks_uri":               server.URL + "/jwks",
+			}
+		} else {
+			response.WriteHeader(400)
+		}
+
+		json.NewEncoder(response).Encode(value)
+	}))
+
+	return server
+}
+
+func TestOIDCLoginStateCanOnlyBeUsedOnce(t *testing.T) {
+	issuer := newRejectingOIDCIssuer()
+	defer issuer.Close()
+
+	provider := models.NewOIDCProvider(issuer.URL, "hiking-trails", "", "http://localhost/api/v1/oidc/callback")
+	store := middleware.NewMemorySessionStore()
+	logger := log.New(ioutil.Discard, "", 0)
+
+	startLogin := func() string {
+		login := middleware.NewSession(store)
+		login.Set(OIDC_STATE_KEY, "state")
+		login.Set(OIDC_NONCE_KEY, "nonce")
+		login.Set(OIDC_CODE_VERIFIER_KEY, "verifier")
+
+		err := login.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return login.Id
+	}
+
+	finishLogin := func(loginId string, state string) int {
+		request, err := http.NewRequest("GET", "/api/v1/oidc/callback?code=code&state="+state, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.AddCookie(&http.Cookie{Name: middleware.OIDC_LOGIN_COOKIE_NAME, Value: loginId})
+
+		_, err = finishOIDCLogin(provider, store, config.SessionConfig{}, request, httptest.NewRecorder(), logger)
+
+		apiError, isApiError := err.(*models.APIError)
+		if !isApiError {
+			t.Fatalf("Finished login with error %v", err)
+		}
+
+		return apiError.Status
+	}
+
+	// The code is exchanged, and rejected by the provider.
+	loginId := startLogin()
+	if status := finishLogin(loginId, "state"); status != 401 {
+		t.Errorf("Finished login with status %d, expected 401", status)
+	}
+
+	if status := finishLogin(loginId, "state"); status != 400 {
+		t.Errorf("Finished login again with status %d, expected 400", status)
+	}
+
+	loginId = startLogin()
+	if status := finishLogin(loginId, "other-state"); status != 400 {
+		t.Errorf("Finished login with wrong state with status %d, expected 400", status)
+	}
+
+	if status := finishLogin(loginId, "state"); status != 400 {
+		t.Errorf("Finished login after wrong state with status %d, expected 400", status)
+	}
+}
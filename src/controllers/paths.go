@@ -1,15 +1,37 @@
 package controllers
 
 import (
+	"bytes"
 	"database/sql"
+	"fmt"
 	"github.com/go-martini/martini"
+	"github.com/martini-contrib/binding"
 	"github.com/martini-contrib/render"
 	"hiking_trails/src/models"
 	"log"
+	"net/http"
+	"strconv"
+	"strings"
 )
 
-func PathsControllerCreate(path models.Path, render render.Render, db *sql.DB, logger *log.Logger) {
-	err := models.Save(&path, db)
+const (
+	GPX_CONTENT_TYPE = "application/gpx+xml"
+)
+
+func PathsControllerCreate(path models.Path, currentUser *models.User, request *http.Request, render render.Render,
+	db *sql.DB, logger *log.Logger) {
+
+	format, err := getPolylineFormatFromQuery(request)
+	if err == nil {
+		err = models.AuthorizeBundle(db, currentUser, path.BundleId, models.PERMISSION_EDIT)
+	}
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	err = models.Save(&path, db)
 
 	if err != nil {
 		err = models.NewAPIError(500, "Failed to insert path into database", err)
@@ -17,10 +39,13 @@ func PathsControllerCreate(path models.Path, render render.Render, db *sql.DB, l
 		return
 	}
 
-	render.JSON(201, path)
+	format.apply([]*models.Path{&path})
+	renderPath(201, &path, request, render, logger)
 }
 
-func PathsControllerRead(params martini.Params, render render.Render, db *sql.DB, logger *log.Logger) {
+func PathsControllerRead(params martini.Params, request *http.Request, render render.Render, db *sql.DB,
+	logger *log.Logger) {
+
 	id, err := MustGetIdFromParameters(params, logger)
 	if err != nil {
 		err = models.NewAPIError(500, "Failed to load path from database", err)
@@ -28,19 +53,38 @@ func PathsControllerRead(params martini.Params, render render.Render, db *sql.DB
 		return
 	}
 
+	generalization, err := getPolylineGeneralizationFromQuery(request)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	format, err := getPolylineFormatFromQuery(request)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
 	path := models.NewPath()
 	path.Id = id
 	err = models.Load(path, db)
 
+	if err == nil {
+		err = generalization.apply(db, []*models.Path{path})
+	}
+
 	if err != nil {
 		renderErrorAsJson(err, render, logger)
 		return
 	}
 
-	render.JSON(200, path)
+	format.apply([]*models.Path{path})
+	renderPath(200, path, request, render, logger)
 }
 
-func PathsControllerUpdate(params martini.Params, path models.Path,
+// Moving the path to another bundle requires permission to edit that bundle
+// as well.
+func PathsControllerUpdate(params martini.Params, path models.Path, currentUser *models.User, request *http.Request,
 	render render.Render, db *sql.DB, logger *log.Logger) {
 
 	id, err := MustGetIdFromParameters(params, logger)
@@ -53,6 +97,15 @@ func PathsControllerUpdate(params martini.Params, path models.Path,
 		err = models.NewAPIError(400, "Not allowed to change path id", nil)
 	}
 
+	format, formatErr := getPolylineFormatFromQuery(request)
+	if err == nil {
+		err = formatErr
+	}
+
+	if err == nil {
+		err = models.AuthorizeBundle(db, currentUser, path.BundleId, models.PERMISSION_EDIT)
+	}
+
 	if err == nil {
 		err = models.Update(&path, db)
 	}
@@ -62,7 +115,8 @@ func PathsControllerUpdate(params martini.Params, path models.Path,
 		return
 	}
 
-	render.JSON(200, path)
+	format.apply([]*models.Path{&path})
+	renderPath(200, &path, request, render, logger)
 }
 
 func PathsControllerDelete(params martini.Params, render render.Render, db *sql.DB,
@@ -84,7 +138,31 @@ func PathsControllerDelete(params martini.Params, render render.Render, db *sql.
 	render.Text(204, "")
 }
 
-func PathsControllerList(render render.Render, db *sql.DB, logger *log.Logger) {
+func PathsControllerList(request *http.Request, render render.Render, db *sql.DB, logger *log.Logger) {
+
+	filter, err := getPathFilterFromQuery(request)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	generalization, err := getPolylineGeneralizationFromQuery(request)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	format, err := getPolylineFormatFromQuery(request)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	list, err := getListQueryFromQuery(request, models.Path{}, models.PATH_SORT_COLUMNS, "places")
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
 
 	transaction, err := db.Begin()
 	if err != nil {
@@ -92,7 +170,11 @@ func PathsControllerList(render render.Render, db *sql.DB, logger *log.Logger) {
 		return
 	}
 
-	paths, err := models.LoadPathsFromDatabase(transaction, 0)
+	paths, total, err := models.ListPaths(transaction, filter, list.options)
+
+	if err == nil && !list.options.WithoutPolylines {
+		err = generalization.apply(transaction, paths)
+	}
 
 	if err == nil {
 		err = transaction.Commit()
@@ -105,5 +187,221 @@ func PathsControllerList(render render.Render, db *sql.DB, logger *log.Logger) {
 		return
 	}
 
-	render.JSON(200, paths)
+	if wantsGeoJSON(request) {
+		setTotalCountHeader(render, total)
+		renderGeoJSON(200, models.GeoJSONFromPaths(paths), render, logger)
+		return
+	}
+
+	format.apply(paths)
+	list.render(paths, total, render, logger)
+}
+
+// Parses the path filter from the query parameters bundleId, minLength and
+// maxLength in meters, maxDuration in seconds, difficulty as a comma separated
+// list, placeName and the area parameters.
+func getPathFilterFromQuery(request *http.Request) (*models.PathFilter, error) {
+	query := request.URL.Query()
+	filter := &models.PathFilter{}
+
+	var err error
+	if query.Get("bundleId") != "" {
+		filter.BundleId, err = GetIdFromQuery(request, "bundleId")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for name, value := range map[string]*float64{"minLength": &filter.MinLength, "maxLength": &filter.MaxLength} {
+		if query.Get(name) == "" {
+			continue
+		}
+
+		*value, err = strconv.ParseFloat(query.Get(name), 64)
+		if err != nil || *value <= 0 {
+			return nil, models.NewAPIError(400, fmt.Sprintf("Query parameter '%s' must be a length in meters larger than 0.", name), nil)
+		}
+	}
+
+	if filter.MaxLength > 0 && filter.MinLength > filter.MaxLength {
+		return nil, models.NewAPIError(400, "Query parameter 'minLength' must not be larger than 'maxLength'.", nil)
+	}
+
+	if query.Get("maxDuration") != "" {
+		filter.MaxDuration, err = strconv.ParseInt(query.Get("maxDuration"), 10, 64)
+		if err != nil || filter.MaxDuration <= 0 {
+			return nil, models.NewAPIError(400, "Query parameter 'maxDuration' must be a duration in seconds larger than 0.", nil)
+		}
+	}
+
+	if query.Get("difficulty") != "" {
+		for _, difficulty := range strings.Split(query.Get("difficulty"), ",") {
+			if !models.IsPathDifficulty(difficulty) {
+				return nil, models.NewAPIError(400, fmt.Sprintf("Query parameter 'difficulty' must be one of %s.",
+					strings.Join(models.PATH_DIFFICULTIES, ", ")), nil)
+			}
+
+			filter.Difficulties = append(filter.Difficulties, difficulty)
+		}
+	}
+
+	filter.PlaceName = strings.TrimSpace(query.Get("placeName"))
+
+	filter.Area, err = GetAreaFromQuery(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func renderPath(status int, path *models.Path, request *http.Request, render render.Render, logger *log.Logger) {
+	if wantsGeoJSON(request) {
+		renderGeoJSON(status, models.GeoJSONFromPaths([]*models.Path{path}), render, logger)
+		return
+	}
+
+	render.JSON(status, path)
+}
+
+// Creates a path, including its places, from a GPX document sent as request
+// body. The bundle to add the path to is given by the 'bundleId' query parameter.
+func PathsControllerImportGPX(request *http.Request, response http.ResponseWriter, currentUser *models.User,
+	render render.Render, db *sql.DB, logger *log.Logger) {
+
+	bundleId, err := GetIdFromQuery(request, "bundleId")
+	if err == nil {
+		err = models.AuthorizeBundle(db, currentUser, bundleId, models.PERMISSION_EDIT)
+	}
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	gpx, err := models.ParseGPX(http.MaxBytesReader(response, request.Body, models.MAX_IMPORT_BYTES))
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	path, err := gpx.AsPath()
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	path.BundleId = bundleId
+
+	errors := validateImportedPaths([]*models.Path{path}, binding.Errors{}, request)
+
+	if len(errors) > 0 {
+		render.JSON(422, errors)
+		return
+	}
+
+	err = models.Save(path, db)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(201, path)
+}
+
+func PathsControllerExportGPX(params martini.Params, render render.Render, db *sql.DB, logger *log.Logger) {
+	id, err := MustGetIdFromParameters(params, logger)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	path := models.NewPath()
+	path.Id = id
+	err = models.Load(path, db)
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	gpx := models.NewGPXFromPaths(path.Name, path.Info, []*models.Path{path})
+	renderGPX(gpx, fmt.Sprintf("path-%d.gpx", path.Id), render, logger)
+}
+
+// Renders the path as KML, or as KMZ if requested with the '.kmz' suffix.
+func PathsControllerExportKML(params martini.Params, request *http.Request, render render.Render, db *sql.DB,
+	logger *log.Logger) {
+
+	id, err := MustGetIdFromParameters(params, logger)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	path := models.NewPath()
+	path.Id = id
+	err = models.Load(path, db)
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	renderKML(models.NewKMLFromPath(path), fmt.Sprintf("path-%d", path.Id), request, render, logger)
+}
+
+// Renders distance-vs-elevation samples for the path together with total
+// ascent, descent and min and max elevation.
+func PathsControllerElevation(params martini.Params, render render.Render, db *sql.DB, logger *log.Logger) {
+	id, err := MustGetIdFromParameters(params, logger)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	path := models.NewPath()
+	path.Id = id
+	err = models.Load(path, db)
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(200, path.Polyline.ElevationProfile())
+}
+
+func renderGPX(gpx *models.GPX, filename string, render render.Render, logger *log.Logger) {
+	buffer := &bytes.Buffer{}
+
+	err := gpx.Encode(buffer)
+	if err != nil {
+		err = models.NewAPIError(500, "Failed to encode GPX document", err)
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	renderAttachment(render, GPX_CONTENT_TYPE, filename, buffer.Bytes())
+}
+
+func renderKML(kml *models.KML, basename string, request *http.Request, render render.Render, logger *log.Logger) {
+	buffer := &bytes.Buffer{}
+	contentType, filename := models.KML_CONTENT_TYPE, basename+".kml"
+
+	var err error
+	if strings.HasSuffix(request.URL.Path, ".kmz") {
+		contentType, filename = models.KMZ_CONTENT_TYPE, basename+".kmz"
+		err = kml.EncodeAsKMZ(buffer)
+	} else {
+		err = kml.Encode(buffer)
+	}
+
+	if err != nil {
+		err = models.NewAPIError(500, "Failed to encode KML document", err)
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	renderAttachment(render, contentType, filename, buffer.Bytes())
 }
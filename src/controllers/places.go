@@ -6,20 +6,31 @@ import (
 	"github.com/martini-contrib/render"
 	"hiking_trails/src/models"
 	"log"
+	"net/http"
 )
 
-func PlacesControllerCreate(place models.Place, render render.Render, db *sql.DB, logger *log.Logger) {
-	err := models.Save(&place, db)
+func PlacesControllerCreate(place models.Place, currentUser *models.User, request *http.Request,
+	render render.Render, db *sql.DB, logger *log.Logger) {
+
+	err := authorizeBundleOfPath(db, currentUser, place.PathId)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	err = models.Save(&place, db)
 
 	if err != nil {
 		LogAndRenderError500(logger, render, "Failed to insert place into database", err)
 		return
 	}
 
-	render.JSON(201, place)
+	renderPlace(201, &place, request, render, logger)
 }
 
-func PlacesControllerRead(params martini.Params, render render.Render, db *sql.DB, logger *log.Logger) {
+func PlacesControllerRead(params martini.Params, request *http.Request, render render.Render, db *sql.DB,
+	logger *log.Logger) {
+
 	id, err := MustGetIdFromParameters(params, logger)
 	if err != nil {
 		renderErrorAsJson(err, render, logger)
@@ -35,11 +46,13 @@ func PlacesControllerRead(params martini.Params, render render.Render, db *sql.D
 		return
 	}
 
-	render.JSON(200, place)
+	renderPlace(200, place, request, render, logger)
 }
 
-func PlacesControllerUpdate(params martini.Params, place models.Place,
-	render render.Render, db *sql.DB, logger *log.Logger) {
+// Moving the place to another path requires permission to edit the bundle of
+// that path as well.
+func PlacesControllerUpdate(params martini.Params, place models.Place, currentUser *models.User,
+	request *http.Request, render render.Render, db *sql.DB, logger *log.Logger) {
 
 	id, err := MustGetIdFromParameters(params, logger)
 	if err != nil {
@@ -51,6 +64,10 @@ func PlacesControllerUpdate(params martini.Params, place models.Place,
 		err = models.NewAPIError(400, "Not allowed to change place id", nil)
 	}
 
+	if err == nil {
+		err = authorizeBundleOfPath(db, currentUser, place.PathId)
+	}
+
 	if err == nil {
 		err = models.Update(&place, db)
 	}
@@ -60,7 +77,7 @@ func PlacesControllerUpdate(params martini.Params, place models.Place,
 		return
 	}
 
-	render.JSON(200, place)
+	renderPlace(200, &place, request, render, logger)
 }
 
 func PlacesControllerDelete(params martini.Params, render render.Render, db *sql.DB,
@@ -82,12 +99,48 @@ func PlacesControllerDelete(params martini.Params, render render.Render, db *sql
 	render.JSON(204, "")
 }
 
-func PlacesControllerList(render render.Render, db *sql.DB, logger *log.Logger) {
-	places, err := models.LoadPlaces(db, nil)
+func PlacesControllerList(request *http.Request, render render.Render, db *sql.DB, logger *log.Logger) {
+	area, err := GetAreaFromQuery(request)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	list, err := getListQueryFromQuery(request, models.Place{}, models.PLACE_SORT_COLUMNS, "")
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	places, total, err := models.ListPlaces(db, &models.PlaceFilter{Area: area}, list.options)
 	if err != nil {
 		LogAndRenderError500(logger, render, "Got error when trying to list places", err)
 		return
 	}
 
-	render.JSON(200, places)
+	if wantsGeoJSON(request) {
+		setTotalCountHeader(render, total)
+		renderGeoJSON(200, models.GeoJSONFromPlaces(places), render, logger)
+		return
+	}
+
+	list.render(places, total, render, logger)
+}
+
+func renderPlace(status int, place *models.Place, request *http.Request, render render.Render, logger *log.Logger) {
+	if wantsGeoJSON(request) {
+		renderGeoJSON(status, place.AsGeoJSONFeature(), render, logger)
+		return
+	}
+
+	render.JSON(status, place)
+}
+
+func authorizeBundleOfPath(queryer models.SQLQueryer, user *models.User, pathId int64) error {
+	bundleId, err := models.BundleIdOfPath(queryer, pathId)
+	if err != nil {
+		return err
+	}
+
+	return models.AuthorizeBundle(queryer, user, bundleId, models.PERMISSION_EDIT)
 }
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/martini-contrib/render"
+	"hiking_trails/src/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Renders bundles, paths and places matching the words in query parameter 'q',
+// ordered by relevance. The number of hits is limited by 'limit'.
+func SearchControllerSearch(request *http.Request, render render.Render, db *sql.DB, logger *log.Logger) {
+	query := request.URL.Query()
+
+	text := strings.TrimSpace(query.Get("q"))
+	if text == "" {
+		renderErrorAsJson(models.NewAPIError(400, "Query parameter 'q' is required.", nil), render, logger)
+		return
+	}
+
+	limit := models.DEFAULT_SEARCH_HITS
+	if query.Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(query.Get("limit"))
+
+		if err != nil || limit < 1 || limit > models.MAX_SEARCH_HITS {
+			err = models.NewAPIError(400,
+				fmt.Sprintf("Query parameter 'limit' must be a number between 1 and %d.", models.MAX_SEARCH_HITS), nil)
+			renderErrorAsJson(err, render, logger)
+			return
+		}
+	}
+
+	hits, err := models.Search(db, text, limit)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(200, hits)
+}
@@ -2,60 +2,282 @@ package controllers
 
 import (
 	"database/sql"
+	"github.com/go-martini/martini"
 	"github.com/martini-contrib/render"
+	"hiking_trails/src/config"
 	"hiking_trails/src/middleware"
 	"hiking_trails/src/models"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 )
 
-func UsersControllerLogin(form models.LoginForm, store *middleware.SessionStore, render render.Render, db *sql.DB, logger *log.Logger, response http.ResponseWriter) {
-	user := &models.User{}
+// Repeated failed logins are answered with 429 and a Retry-After header until
+// the throttle allows logins again, without checking the password.
+func UsersControllerLogin(form models.LoginForm, store middleware.SessionStore, settings config.SessionConfig,
+	throttle models.LoginThrottle, request *http.Request, render render.Render, db *sql.DB, logger *log.Logger,
+	response http.ResponseWriter) {
+
+	attempt := models.NewLoginAttempt(form.Username, remoteIPAddress(request), false)
 
-	err := user.LoadFromUsername(form.Username, db)
+	retryAfter, err := throttle.Reserve(db, attempt)
 	if err != nil {
-		err := models.NewAPIError(401, "Invalid username and password", nil)
 		renderErrorAsJson(err, render, logger)
 		return
 	}
 
-	if !user.IsCorrectPassword(form.Password) {
+	if retryAfter > 0 {
+		seconds := int64((retryAfter + time.Second - 1) / time.Second)
+		response.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+
+		err = models.NewAPIError(429, "Too many failed logins, try again later", nil)
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	user := &models.User{}
+
+	err = user.LoadFromUsername(form.Username, db)
+	if err != nil {
+		models.VerifyDummyPassword(form.Password)
+	}
+
+	if err != nil || !user.IsCorrectPassword(form.Password) {
 		err := models.NewAPIError(401, "Invalid username and password", nil)
 		renderErrorAsJson(err, render, logger)
 		return
 	}
 
+	err = attempt.Succeed(db)
+	if err != nil {
+		logger.Printf("Failed to record successful login of user with id %d: %s", user.Id, err)
+	}
+
+	// The password is only known here, so outdated hashes are replaced on login.
+	if user.NeedsPasswordRehash() {
+		user.SetPassword(form.Password)
+
+		err = user.UpdatePassword(db)
+		if err != nil {
+			logger.Printf("Failed to rehash password of user with id %d: %s", user.Id, err)
+		}
+	}
+
+	session, err := startSession(user, store, settings, response)
+	if err != nil {
+		LogAndRenderError500(logger, render, "Failed to create session", err)
+		return
+	}
+
+	responseData := map[string]interface{}{"SessionId": session.Id, "csrfToken": session.CSRFToken(), "user": user}
+	render.JSON(200, responseData)
+}
+
+// Creates a session for the user with a new CSRF token, and sets its cookies.
+func startSession(user *models.User, store middleware.SessionStore, settings config.SessionConfig,
+	response http.ResponseWriter) (middleware.Session, error) {
+
 	session := middleware.NewSession(store)
 	session.Set("userId", user.Id)
-	session.Set("isAdministrator", user.IsAdministrator)
-	session.Create()
+	session.GenerateCSRFToken()
 
-	cookie := &http.Cookie{
-		Name:    "SessionId",
-		Value:   session.Id,
-		Path:    "/",
-		Expires: time.Now().Add(48 * time.Hour),
+	err := session.Create()
+	if err != nil {
+		return session, err
 	}
-	http.SetCookie(response, cookie)
 
-	responseData := map[string]string{"SessionId": session.Id}
-	render.JSON(200, responseData)
+	middleware.SetSessionCookie(response, session, settings)
+	return session, nil
+}
+
+// Login attempts are recorded for the audit and the throttle. Failing to record
+// one does not stop the login.
+func recordLoginAttempt(db *sql.DB, username string, ipAddress string, succeeded bool, logger *log.Logger) {
+	err := models.NewLoginAttempt(username, ipAddress, succeeded).Save(db)
+	if err != nil {
+		logger.Printf("Failed to record login attempt for '%s' from %s: %s", username, ipAddress, err)
+	}
 }
 
-func UsersControllerLogout(session *middleware.Session, render render.Render, response http.ResponseWriter) {
-	session.Delete()
+func UsersControllerLogout(session *middleware.Session, settings config.SessionConfig, render render.Render,
+	logger *log.Logger, response http.ResponseWriter) {
 
-	// Set session cookie to expired(1970...) so browser knows to remove it.
-	cookie := &http.Cookie{
-		Name:    "SessionId",
-		Value:   "",
-		Path:    "/",
-		MaxAge:  -1,
-		Expires: time.Time{},
+	err := session.Delete()
+	if err != nil {
+		logger.Printf("Failed to delete session: %s", err)
 	}
 
-	http.SetCookie(response, cookie)
+	middleware.ClearSessionCookie(response, settings)
 
 	render.JSON(200, "")
 }
+
+// The logged in user, which tells the frontend whether it is logged in since
+// the session cookie is hidden from scripts.
+func UsersControllerReadMe(session *middleware.Session, render render.Render, db *sql.DB, logger *log.Logger) {
+	user := &models.User{Id: session.Get("userId").(int64)}
+	err := models.Load(user, db)
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(200, user)
+}
+
+func UsersControllerCreate(user models.User, render render.Render, db *sql.DB, logger *log.Logger) {
+	err := models.CheckUsernameAvailable(db, user.Username, 0)
+	if err == nil {
+		err = models.Save(&user, db)
+	}
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(201, user)
+}
+
+func UsersControllerRead(params martini.Params, render render.Render, db *sql.DB, logger *log.Logger) {
+	id, err := MustGetIdFromParameters(params, logger)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	user := &models.User{Id: id}
+	err = models.Load(user, db)
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	render.JSON(200, user)
+}
+
+// Administrators can not remove their own administrator role, so that at
+// least one administrator always remains.
+func UsersControllerUpdate(params martini.Params, user models.User, session *middleware.Session,
+	store middleware.SessionStore, render render.Render, db *sql.DB, logger *log.Logger) {
+
+	id, err := MustGetIdFromParameters(params, logger)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	if id != user.Id {
+		err = models.NewAPIError(400, "Not allowed to change user id", nil)
+	} else if isSessionUser(session, id) && user.Role != models.ROLE_ADMINISTRATOR {
+		err = models.NewAPIError(400, "Not allowed to remove your own administrator role", nil)
+	}
+
+	if err == nil {
+		err = models.CheckUsernameAvailable(db, user.Username, user.Id)
+	}
+
+	// The password is cleared when it is hashed.
+	passwordChanged := user.Password != ""
+
+	if err == nil {
+		err = models.Update(&user, db)
+	}
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	if passwordChanged {
+		deleteOtherSessions(store, user.Id, session, logger)
+	}
+
+	render.JSON(200, user)
+}
+
+func UsersControllerDelete(params martini.Params, session *middleware.Session, store middleware.SessionStore,
+	render render.Render, db *sql.DB, logger *log.Logger) {
+
+	id, err := MustGetIdFromParameters(params, logger)
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	if isSessionUser(session, id) {
+		err = models.NewAPIError(400, "Not allowed to delete yourself", nil)
+	} else {
+		err = models.DeleteUser(db, id)
+	}
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	_, err = store.DeleteByUser(id, "")
+	if err != nil {
+		LogAndRenderError500(logger, render, "Failed to delete sessions of deleted user", err)
+		return
+	}
+
+	render.JSON(204, "")
+}
+
+func UsersControllerList(request *http.Request, render render.Render, db *sql.DB, logger *log.Logger) {
+	list, err := getListQueryFromQuery(request, models.User{}, models.USER_SORT_COLUMNS, "")
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	users, total, err := models.ListUsers(db, list.options)
+	if err != nil {
+		LogAndRenderError500(logger, render, "Got error when trying to list users", err)
+		return
+	}
+
+	list.render(users, total, render, logger)
+}
+
+func UsersControllerChangePassword(form models.PasswordChangeForm, session *middleware.Session,
+	store middleware.SessionStore, render render.Render, db *sql.DB, logger *log.Logger) {
+
+	user := &models.User{Id: session.Get("userId").(int64)}
+	err := models.Load(user, db)
+
+	if err == nil && !user.IsCorrectPassword(form.CurrentPassword) {
+		err = models.NewAPIError(403, "Current password is incorrect", nil)
+	}
+
+	if err == nil {
+		user.SetPassword(form.NewPassword)
+		err = user.UpdatePassword(db)
+	}
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	deleteOtherSessions(store, user.Id, session, logger)
+	render.JSON(204, "")
+}
+
+// Logs the user out everywhere except in the current session, after the
+// password has changed. The password is changed even if this fails.
+func deleteOtherSessions(store middleware.SessionStore, userId int64, session *middleware.Session, logger *log.Logger) {
+	_, err := store.DeleteByUser(userId, session.Id)
+	if err != nil {
+		logger.Printf("Failed to delete sessions of user with id %d: %s", userId, err)
+	}
+}
+
+func isSessionUser(session *middleware.Session, id int64) bool {
+	userId, isId := session.Get("userId").(int64)
+	return isId && userId == id
+}
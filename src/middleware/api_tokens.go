@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	"hiking_trails/src/models"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Returns the token of an 'Authorization: Bearer <token>' header.
+func bearerToken(request *http.Request) (string, bool) {
+	authorization := request.Header.Get("Authorization")
+
+	scheme := "Bearer "
+	if len(authorization) < len(scheme) || !strings.EqualFold(authorization[:len(scheme)], scheme) {
+		return "", false
+	}
+
+	return strings.TrimSpace(authorization[len(scheme):]), true
+}
+
+// Maps a session acting as the user of the token, or renders a 401 error if
+// the token is invalid or expired.
+func apiTokenSession(token string, store SessionStore, response http.ResponseWriter, c martini.Context,
+	render render.Render, db *sql.DB, logger *log.Logger) {
+
+	apiToken, err := models.LoadAPITokenFromToken(db, token)
+	if err != nil {
+		response.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+
+	err = apiToken.UpdateLastUsed(db)
+	if err != nil {
+		logger.Printf("Failed to update last use of API token: %s", err)
+	}
+
+	session := NewSession(store)
+	session.Set("userId", apiToken.UserId)
+	session.APIToken = apiToken
+
+	c.Map(&session)
+}
+
+// Returns a 403 error if the request is authenticated with an API token
+// without the scope needed for the request. Requests requiring the
+// administrator role need the admin scope, other requests need the read scope
+// to read and the write scope to change resources.
+func authorizeScope(session *Session, request *http.Request, role string) error {
+	if session.APIToken == nil {
+		return nil
+	}
+
+	scope := models.API_TOKEN_SCOPE_WRITE
+	if role == models.ROLE_ADMINISTRATOR {
+		scope = models.API_TOKEN_SCOPE_ADMIN
+	} else if request.Method == "GET" || request.Method == "HEAD" {
+		scope = models.API_TOKEN_SCOPE_READ
+	}
+
+	if !session.APIToken.HasScope(scope) {
+		return models.NewAPIError(403, fmt.Sprintf("API token does not have the '%s' scope", scope), nil)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	"hiking_trails/src/models"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Resolves the bundle a resource with id belongs to.
+type BundleIdResolver func(queryer models.SQLQueryer, id int64) (int64, error)
+
+// Allows users with the role or a more privileged one, and maps the logged in
+// user for the following handlers. The role is loaded on every request, so
+// role changes take effect without logging in again.
+func RoleRequired(role string) martini.Handler {
+	return func(session *Session, request *http.Request, c martini.Context, render render.Render, db *sql.DB,
+		logger *log.Logger) {
+
+		user, err := loadSessionUser(session, db)
+
+		if err == nil && !user.HasRole(role) {
+			err = models.NewAPIError(403, "Forbidden", nil)
+		}
+
+		if err == nil {
+			err = authorizeScope(session, request, role)
+		}
+
+		if err != nil {
+			renderErrorAsJson(err, render, logger)
+			return
+		}
+
+		c.Map(user)
+	}
+}
+
+// Allows users with the permission for the bundle of the resource given by
+// the id route parameter, and maps the logged in user for the following
+// handlers.
+func BundlePermissionRequired(bundleIdOf BundleIdResolver, permission models.Permission) martini.Handler {
+	return func(params martini.Params, session *Session, request *http.Request, c martini.Context,
+		render render.Render, db *sql.DB, logger *log.Logger) {
+
+		user, err := loadSessionUser(session, db)
+
+		if err == nil {
+			err = authorizeScope(session, request, "")
+		}
+
+		var bundleId int64
+		if err == nil {
+			bundleId, err = resourceBundleId(params, bundleIdOf, db)
+		}
+
+		if err == nil {
+			err = models.AuthorizeBundle(db, user, bundleId, permission)
+		}
+
+		if err != nil {
+			renderErrorAsJson(err, render, logger)
+			return
+		}
+
+		c.Map(user)
+	}
+}
+
+// Returns a 401 error if no user is logged in, or the user no longer exists.
+func loadSessionUser(session *Session, db *sql.DB) (*models.User, error) {
+	userId, isId := session.Get("userId").(int64)
+	if !isId {
+		return nil, models.NewAPIError(401, "Unauthorized", nil)
+	}
+
+	user := &models.User{Id: userId}
+
+	err := models.Load(user, db)
+	if apiError, isApiError := err.(*models.APIError); isApiError && apiError.Status == 404 {
+		return nil, models.NewAPIError(401, "Unauthorized", nil)
+	}
+
+	return user, err
+}
+
+func resourceBundleId(params martini.Params, bundleIdOf BundleIdResolver, queryer models.SQLQueryer) (int64, error) {
+	id, err := strconv.ParseInt(params["id"], 10, 64)
+	if err != nil {
+		return 0, models.NewAPIError(400, fmt.Sprintf("%s is not a valid id.", params["id"]), nil)
+	}
+
+	return bundleIdOf(queryer, id)
+}
@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"database/sql"
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	"hiking_trails/src/config"
+	"hiking_trails/src/models"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Users and bundle of the authorization tests, and the server authorizing
+// their requests like the routes of main.
+type authorizationTest struct {
+	db     *sql.DB
+	store  SessionStore
+	server http.Handler
+
+	bundleId int64
+	viewer   *models.User
+
+	// Viewer with the editor role for the bundle.
+	bundleEditor *models.User
+
+	// Editor with the editor role for the bundle, who can create and change
+	// bundles.
+	editor *models.User
+}
+
+func newAuthorizationTest(t *testing.T) (*authorizationTest, func()) {
+	db, cleanup := openTestDatabase(t)
+	test := &authorizationTest{db: db, store: NewMemorySessionStore()}
+
+	result, err := db.Exec("INSERT INTO bundles(name, info) VALUES('Lakes', '')")
+	if err == nil {
+		test.bundleId, err = result.LastInsertId()
+	}
+
+	test.viewer = models.NewUser("viewer", "correct horse battery staple", models.ROLE_VIEWER)
+	test.bundleEditor = models.NewUser("bundle-editor", "correct horse battery staple", models.ROLE_VIEWER)
+	test.editor = models.NewUser("editor", "correct horse battery staple", models.ROLE_EDITOR)
+
+	for _, user := range []*models.User{test.viewer, test.bundleEditor, test.editor} {
+		if err == nil {
+			err = models.Save(user, db)
+		}
+	}
+
+	for _, user := range []*models.User{test.bundleEditor, test.editor} {
+		if err == nil {
+			permission := &models.BundlePermission{BundleId: test.bundleId, UserId: user.Id, Role: models.BUNDLE_ROLE_EDITOR}
+			err = permission.Grant(db)
+		}
+	}
+
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	settings := config.Default().Session
+	authorized := func(render render.Render) {
+		render.JSON(200, "")
+	}
+
+	app := martini.New()
+	app.Use(render.Renderer())
+	app.Map(db)
+	app.Map(log.New(ioutil.Discard, "", 0))
+	app.Use(Sessions(test.store, settings))
+	app.Use(CSRFProtection)
+
+	router := martini.NewRouter()
+	app.Action(router.Handle)
+
+	router.Post("/api/v1/bundles", RoleRequired(models.ROLE_EDITOR), authorized)
+	router.Get("/api/v1/bundles/:id", BundlePermissionRequired(models.BundleIdOfBundle, models.PERMISSION_VIEW), authorized)
+	router.Put("/api/v1/bundles/:id", BundlePermissionRequired(models.BundleIdOfBundle, models.PERMISSION_EDIT), authorized)
+	router.Delete("/api/v1/bundles/:id", BundlePermissionRequired(models.BundleIdOfBundle, models.PERMISSION_OWN), authorized)
+
+	test.server = app
+	return test, cleanup
+}
+
+// Logs the user in and returns the session.
+func (test *authorizationTest) login(t *testing.T, user *models.User) Session {
+	session := NewSession(test.store)
+	session.Set("userId", user.Id)
+	session.GenerateCSRFToken()
+
+	err := session.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return session
+}
+
+// Returns a token of the user with the scopes.
+func (test *authorizationTest) apiToken(t *testing.T, user *models.User, scopes ...string) string {
+	token := &models.APIToken{Name: "test", Scopes: scopes, UserId: user.Id}
+
+	err := token.Save(test.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.Token
+}
+
+// Sends the request with the session cookie and CSRF token of session, and
+// returns the response status.
+func (test *authorizationTest) sendWithSession(method string, path string, session Session, csrfToken string) int {
+	request := httptest.NewRequest(method, path, nil)
+	request.AddCookie(&http.Cookie{Name: SESSION_COOKIE_NAME, Value: session.Id})
+	if csrfToken != "" {
+		request.Header.Set(CSRF_HEADER_NAME, csrfToken)
+	}
+
+	return test.send(request)
+}
+
+func (test *authorizationTest) sendWithToken(method string, path string, token string) int {
+	request := httptest.NewRequest(method, path, nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	return test.send(request)
+}
+
+func (test *authorizationTest) send(request *http.Request) int {
+	response := httptest.NewRecorder()
+	test.server.ServeHTTP(response, request)
+
+	return response.Code
+}
+
+func TestViewerCanNotChangeBundles(t *testing.T) {
+	test, cleanup := newAuthorizationTest(t)
+	defer cleanup()
+
+	session := test.login(t, test.viewer)
+
+	if status := test.sendWithSession("GET", "/api/v1/bundles/1", session, ""); status != 200 {
+		t.Errorf("Viewer read bundle with status %d, expected 200", status)
+	}
+
+	for _, method := range []string{"POST", "PUT", "DELETE"} {
+		path := "/api/v1/bundles/1"
+		if method == "POST" {
+			path = "/api/v1/bundles"
+		}
+
+		if status := test.sendWithSession(method, path, session, session.CSRFToken()); status != 403 {
+			t.Errorf("Viewer sent %s %s with status %d, expected 403", method, path, status)
+		}
+	}
+}
+
+func TestBundleEditorCanNotDeleteBundle(t *testing.T) {
+	test, cleanup := newAuthorizationTest(t)
+	defer cleanup()
+
+	session := test.login(t, test.bundleEditor)
+
+	if status := test.sendWithSession("PUT", "/api/v1/bundles/1", session, session.CSRFToken()); status != 200 {
+		t.Errorf("Bundle editor updated bundle with status %d, expected 200", status)
+	}
+
+	if status := test.sendWithSession("POST", "/api/v1/bundles", session, session.CSRFToken()); status != 403 {
+		t.Errorf("Bundle editor created bundle with status %d, expected 403", status)
+	}
+
+	if status := test.sendWithSession("DELETE", "/api/v1/bundles/1", session, session.CSRFToken()); status != 403 {
+		t.Errorf("Bundle editor deleted bundle with status %d, expected 403", status)
+	}
+}
+
+func TestReadScopedTokenCanNotChangeBundles(t *testing.T) {
+	test, cleanup := newAuthorizationTest(t)
+	defer cleanup()
+
+	token := test.apiToken(t, test.editor, models.API_TOKEN_SCOPE_READ)
+
+	if status := test.sendWithToken("GET", "/api/v1/bundles/1", token); status != 200 {
+		t.Errorf("Read token read bundle with status %d, expected 200", status)
+	}
+
+	if status := test.sendWithToken("POST", "/api/v1/bundles", token); status != 403 {
+		t.Errorf("Read token created bundle with status %d, expected 403", status)
+	}
+
+	if status := test.sendWithToken("PUT", "/api/v1/bundles/1", token); status != 403 {
+		t.Errorf("Read token updated bundle with status %d, expected 403", status)
+	}
+
+	if status := test.sendWithToken("GET", "/api/v1/bundles/1", "ht_invalid"); status != 401 {
+		t.Errorf("Invalid token read bundle with status %d, expected 401", status)
+	}
+}
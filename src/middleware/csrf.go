@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"github.com/martini-contrib/render"
+	"hiking_trails/src/config"
+	"hiking_trails/src/models"
+	"log"
+	"net/http"
+)
+
+// Protection against cross site request forgery. Each session has a random
+// token, sent to the browser in a cookie readable by scripts. State changing
+// requests authenticated by the session cookie must repeat the token in a
+// header, which other sites can not do since they can not read the cookie.
+// The names follow the convention of AngularJS $http, which sends the header
+// by itself.
+
+const (
+	CSRF_COOKIE_NAME = "XSRF-TOKEN"
+	CSRF_HEADER_NAME = "X-XSRF-TOKEN"
+
+	// Key of the token in the session values.
+	CSRF_TOKEN_KEY = "csrfToken"
+)
+
+func (session *Session) CSRFToken() string {
+	token, _ := session.Get(CSRF_TOKEN_KEY).(string)
+	return token
+}
+
+// Gives the session a new token, e.g. on login.
+func (session *Session) GenerateCSRFToken() {
+	session.Set(CSRF_TOKEN_KEY, mustGenerateSessionId())
+}
+
+// Rejects state changing requests of logged in users without the token of
+// their session. Requests authenticated with API tokens are not sent by
+// browsers by themselves, and are not checked.
+func CSRFProtection(session *Session, request *http.Request, render render.Render, logger *log.Logger) {
+	if isSafeMethod(request.Method) || session.APIToken != nil || session.Get("userId") == nil {
+		return
+	}
+
+	token := session.CSRFToken()
+	header := request.Header.Get(CSRF_HEADER_NAME)
+
+	if token == "" || subtle.ConstantTimeCompare([]byte(header), []byte(token)) != 1 {
+		err := models.NewAPIError(403, "Invalid or missing CSRF token", nil)
+		renderErrorAsJson(err, render, logger)
+	}
+}
+
+func isSafeMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS"
+}
+
+// Gives sessions from before CSRF tokens a token, so their users do not have
+// to log in again.
+func issueMissingCSRFToken(session *Session, response http.ResponseWriter, settings config.SessionConfig,
+	logger *log.Logger) {
+
+	if session.CSRFToken() != "" {
+		return
+	}
+
+	session.GenerateCSRFToken()
+
+	err := session.Save()
+	if err != nil {
+		logger.Printf("Failed to save CSRF token of session: %s", err)
+		return
+	}
+
+	SetSessionCookie(response, *session, settings)
+}
+
+// Scripts must be able to read the token, so the cookie is never HttpOnly.
+func csrfCookie(value string, settings config.SessionConfig) *http.Cookie {
+	cookie := sessionCookie(value, settings)
+	cookie.Name = CSRF_COOKIE_NAME
+	cookie.HttpOnly = false
+
+	return cookie
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"hiking_trails/src/models"
+	"testing"
+)
+
+func TestRejectsSessionRequestsWithoutCSRFToken(t *testing.T) {
+	test, cleanup := newAuthorizationTest(t)
+	defer cleanup()
+
+	session := test.login(t, test.editor)
+
+	tokens := map[string]string{"missing": "", "wrong": mustGenerateSessionId()}
+	for name, token := range tokens {
+		if status := test.sendWithSession("PUT", "/api/v1/bundles/1", session, token); status != 403 {
+			t.Errorf("Updated bundle with %s CSRF token with status %d, expected 403", name, status)
+		}
+	}
+
+	if status := test.sendWithSession("PUT", "/api/v1/bundles/1", session, session.CSRFToken()); status != 200 {
+		t.Errorf("Updated bundle with CSRF token with status %d, expected 200", status)
+	}
+
+	// Reading needs no token.
+	if status := test.sendWithSession("GET", "/api/v1/bundles/1", session, ""); status != 200 {
+		t.Errorf("Read bundle without CSRF token with status %d, expected 200", status)
+	}
+}
+
+func TestBearerTokenRequestsSkipCSRFProtection(t *testing.T) {
+	test, cleanup := newAuthorizationTest(t)
+	defer cleanup()
+
+	token := test.apiToken(t, test.editor, models.API_TOKEN_SCOPE_READ, models.API_TOKEN_SCOPE_WRITE)
+
+	if status := test.sendWithToken("PUT", "/api/v1/bundles/1", token); status != 200 {
+		t.Errorf("Updated bundle with API token and without CSRF token with status %d, expected 200", status)
+	}
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Sessions kept in the sessions database table, which survive restarts of the
+// server. Timestamps are stored as Unix seconds. The id of the logged in user
+// is also stored in its own column, so that the sessions of a user are found
+// without decoding the values of all sessions.
+type DatabaseSessionStore struct {
+	db *sql.DB
+}
+
+func NewDatabaseSessionStore(db *sql.DB) *DatabaseSessionStore {
+	return &DatabaseSessionStore{db}
+}
+
+func (store *DatabaseSessionStore) Get(id string) (Session, error) {
+	var data []byte
+	var createdAt, lastSeenAt int64
+
+	err := store.db.QueryRow("SELECT data, created_at, last_seen_at FROM sessions WHERE id=?", id).
+		Scan(&data, &createdAt, &lastSeenAt)
+
+	if err == sql.ErrNoRows {
+		return Session{}, nil
+	} else if err != nil {
+		return Session{}, fmt.Errorf("Failed to load session: %s", err)
+	}
+
+	values, err := decodeSessionValues(data)
+	if err != nil {
+		return Session{}, fmt.Errorf("Failed to decode session values: %s", err)
+	}
+
+	return Session{id, time.Unix(createdAt, 0), time.Unix(lastSeenAt, 0), values, store, nil}, nil
+}
+
+func (store *DatabaseSessionStore) Delete(id string) error {
+	_, err := store.db.Exec("DELETE FROM sessions WHERE id=?", id)
+	if err != nil {
+		return fmt.Errorf("Failed to delete session: %s", err)
+	}
+
+	return nil
+}
+
+func (store *DatabaseSessionStore) Create(session Session) error {
+	data, err := encodeSessionValues(session.values)
+	if err != nil {
+		return fmt.Errorf("Failed to encode session values: %s", err)
+	}
+
+	_, err = store.db.Exec("INSERT INTO sessions(id, data, created_at, last_seen_at, user_id) VALUES(?,?,?,?,?)",
+		session.Id, data, session.CreatedAt.Unix(), session.LastSeenAt.Unix(), sessionUserId(session))
+
+	if err != nil {
+		return fmt.Errorf("Failed to create session: %s", err)
+	}
+
+	return nil
+}
+
+func (store *DatabaseSessionStore) Save(session Session) error {
+	data, err := encodeSessionValues(session.values)
+	if err != nil {
+		return fmt.Errorf("Failed to encode session values: %s", err)
+	}
+
+	result, err := store.db.Exec("UPDATE sessions SET data=?, last_seen_at=?, user_id=? WHERE id=?",
+		data, session.LastSeenAt.Unix(), sessionUserId(session), session.Id)
+
+	if err != nil {
+		return fmt.Errorf("Failed to save session: %s", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Failed to save session: %s", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("Session does not exist")
+	}
+
+	return nil
+}
+
+func (store *DatabaseSessionStore) DeleteExpired(idleBefore time.Time, createdBefore time.Time) (int64, error) {
+	result, err := store.db.Exec("DELETE FROM sessions WHERE last_seen_at<? OR created_at<?",
+		idleBefore.Unix(), createdBefore.Unix())
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to delete expired sessions: %s", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (store *DatabaseSessionStore) DeleteByUser(userId int64, exceptId string) (int64, error) {
+	result, err := store.db.Exec("DELETE FROM sessions WHERE user_id=? AND id<>?", userId, exceptId)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to delete sessions of user: %s", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Id of the logged in user of the session, or NULL when no user is logged in.
+func sessionUserId(session Session) sql.NullInt64 {
+	userId, isId := session.values["userId"].(int64)
+	return sql.NullInt64{Int64: userId, Valid: isId}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"bytes"
+	"github.com/go-martini/martini"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+)
+
+const (
+	// Placeholder in index.html replaced by the configured Google Maps API key.
+	MAPS_API_KEY_PLACEHOLDER = "GOOGLE_MAPS_API_KEY"
+)
+
+// Serves index.html of the static root with the maps API key filled in. Must
+// be used before martini.Static, which would otherwise serve the page as is.
+func IndexPage(staticRoot string, mapsAPIKey string) martini.Handler {
+	fileName := filepath.Join(staticRoot, "index.html")
+	key := []byte(url.QueryEscape(mapsAPIKey))
+
+	return func(response http.ResponseWriter, request *http.Request, logger *log.Logger) {
+		if request.Method != "GET" && request.Method != "HEAD" {
+			return
+		}
+
+		if request.URL.Path != "/" && request.URL.Path != "/index.html" {
+			return
+		}
+
+		page, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			logger.Printf("Failed to read index page: %s", err)
+			http.Error(response, "Internal Server Error", 500)
+			return
+		}
+
+		page = bytes.Replace(page, []byte(MAPS_API_KEY_PLACEHOLDER), key, -1)
+
+		response.Header().Set("Content-Type", "text/html; charset=utf-8")
+		response.Write(page)
+	}
+}
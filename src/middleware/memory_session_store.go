@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Sessions kept in memory, which are lost when the server restarts.
+type MemorySessionStore struct {
+	lock     *sync.Mutex
+	sessions map[string]Session
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{&sync.Mutex{}, make(map[string]Session, 0)}
+}
+
+// Sessions are stored and returned as copies, including their values, so that
+// they are thread safe.
+func copySession(session Session) Session {
+	values := make(map[string]interface{}, len(session.values))
+	for key, value := range session.values {
+		values[key] = value
+	}
+
+	session.values = values
+	return session
+}
+
+func (store *MemorySessionStore) Get(id string) (Session, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	session, exist := store.sessions[id]
+	if !exist {
+		return Session{}, nil
+	}
+
+	return copySession(session), nil
+}
+
+func (store *MemorySessionStore) Delete(id string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	delete(store.sessions, id)
+	return nil
+}
+
+func (store *MemorySessionStore) Create(session Session) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.sessions[session.Id] = copySession(session)
+	return nil
+}
+
+func (store *MemorySessionStore) Save(session Session) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	_, exist := store.sessions[session.Id]
+	if !exist {
+		return fmt.Errorf("Session does not exist")
+	}
+
+	store.sessions[session.Id] = copySession(session)
+	return nil
+}
+
+func (store *MemorySessionStore) DeleteExpired(idleBefore time.Time, createdBefore time.Time) (int64, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	var count int64
+	for id, session := range store.sessions {
+		if session.LastSeenAt.Before(idleBefore) || session.CreatedAt.Before(createdBefore) {
+			delete(store.sessions, id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (store *MemorySessionStore) DeleteByUser(userId int64, exceptId string) (int64, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	var count int64
+	for id, session := range store.sessions {
+		if id != exceptId && session.values["userId"] == userId {
+			delete(store.sessions, id)
+			count++
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	"hiking_trails/src/models"
+	"log"
+	"net/http"
+)
+
+// Limits how many requests hash passwords at once, since each hash takes a lot
+// of memory. Requests over the limit are answered with 503 and a Retry-After
+// header instead of waiting.
+func PasswordHashingLimit(c martini.Context, render render.Render, logger *log.Logger, response http.ResponseWriter) {
+	release, err := models.ReservePasswordHashing()
+	if err != nil {
+		response.Header().Set("Retry-After", "1")
+		renderErrorAsJson(err, render, logger)
+		return
+	}
+	defer release()
+
+	c.Next()
+}
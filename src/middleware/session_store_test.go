@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"hiking_trails/src/migrations"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestDatabase(t *testing.T) (*sql.DB, func()) {
+	directory, err := ioutil.TempDir("", "hiking_trails")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(directory, "hiking_trails.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(directory)
+	}
+
+	_, err = migrations.Up(db)
+	if err != nil {
+		cleanup()
+		t.Fatalf("Failed to migrate test database, run the tests with -tags sqlite_fts5: %s", err)
+	}
+
+	return db, cleanup
+}
+
+func TestDeletesSessionsOfUser(t *testing.T) {
+	db, cleanup := openTestDatabase(t)
+	defer cleanup()
+
+	databaseStore := NewDatabaseSessionStore(db)
+
+	for name, store := range map[string]SessionStore{"memory": NewMemorySessionStore(), "database": databaseStore} {
+		sessions := make([]Session, 0)
+		for _, userId := range []int64{1, 1, 1, 2} {
+			session := NewSession(store)
+			session.Set("userId", userId)
+
+			err := session.Create()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sessions = append(sessions, session)
+		}
+
+		// The user logs in to a session created without a user.
+		loggedIn := NewSession(store)
+		err := loggedIn.Create()
+		if err == nil {
+			loggedIn.Set("userId", int64(1))
+			err = loggedIn.Save()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Logins in progress have no user.
+		login := NewSession(store)
+		err = login.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		count, err := store.DeleteByUser(1, sessions[0].Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != 3 {
+			t.Errorf("Deleted %d sessions from %s store, expected 3", count, name)
+		}
+
+		for i, session := range append(sessions, loggedIn, login) {
+			stored, err := store.Get(session.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if kept := stored.Id != ""; kept != (i == 0 || i == 3 || i == 5) {
+				t.Errorf("Session %d is kept %t in %s store", i, kept, name)
+			}
+		}
+	}
+}
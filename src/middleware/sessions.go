@@ -1,38 +1,78 @@
 package middleware
 
 import (
+	"bytes"
 	"crypto/rand"
+	"database/sql"
+	"encoding/gob"
 	"encoding/hex"
-	"fmt"
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/render"
+	"hiking_trails/src/config"
 	"hiking_trails/src/models"
 	"log"
 	"net/http"
-	"sync"
+	"time"
+)
+
+const (
+	SESSION_COOKIE_NAME = "SessionId"
+
+	// Refers to a stored session with the state of a login in progress at an
+	// OpenID Connect identity provider.
+	OIDC_LOGIN_COOKIE_NAME = "OIDCLogin"
+
+	// Sessions are renewed, extending their idle timeout and cookie, at most
+	// this often to avoid writing to the store on every request.
+	SESSION_RENEWAL_INTERVAL = time.Minute
 )
 
 type Session struct {
-	Id     string
-	values map[string]interface{}
-	store  *SessionStore
+	Id         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	values     map[string]interface{}
+	store      SessionStore
+
+	// Token the request is authenticated with, nil for sessions of logged in
+	// users. Sessions of tokens only last for the request and are never stored.
+	APIToken *models.APIToken
 }
 
-func NewSession(store *SessionStore) Session {
+// Storage of sessions. Expiration is decided by the Sessions middleware and
+// the session reaper, stores only keep the timestamps.
+type SessionStore interface {
+	// Returns a session with an empty id if no session with id exist.
+	Get(id string) (Session, error)
+	Create(session Session) error
+	Save(session Session) error
+	Delete(id string) error
+
+	// Deletes sessions last seen before idleBefore or created before
+	// createdBefore, and returns how many were deleted.
+	DeleteExpired(idleBefore time.Time, createdBefore time.Time) (int64, error)
+
+	// Deletes the sessions of the user except the session with exceptId, and
+	// returns how many were deleted.
+	DeleteByUser(userId int64, exceptId string) (int64, error)
+}
+
+func NewSession(store SessionStore) Session {
 	id := mustGenerateSessionId()
-	return Session{id, make(map[string]interface{}), store}
+	now := time.Now()
+	return Session{id, now, now, make(map[string]interface{}), store, nil}
 }
 
 func (session *Session) Save() error {
 	return session.store.Save(*session)
 }
 
-func (session *Session) Create() {
-	session.store.Create(*session)
+func (session *Session) Create() error {
+	return session.store.Create(*session)
 }
 
-func (session *Session) Delete() {
-	session.store.Delete(session.Id)
+func (session *Session) Delete() error {
+	return session.store.Delete(session.Id)
 }
 
 func (session *Session) Set(key string, value interface{}) {
@@ -48,6 +88,19 @@ func (session *Session) Get(key string) interface{} {
 	return value
 }
 
+// The session expires when it has been idle too long or reaches its lifetime,
+// whichever comes first.
+func (session *Session) expiresAt(settings config.SessionConfig) time.Time {
+	idleExpiry := session.LastSeenAt.Add(time.Duration(settings.IdleTimeout))
+	absoluteExpiry := session.CreatedAt.Add(time.Duration(settings.Lifetime))
+
+	if idleExpiry.Before(absoluteExpiry) {
+		return idleExpiry
+	}
+
+	return absoluteExpiry
+}
+
 func mustGenerateSessionId() string {
 	id := make([]byte, 32)
 
@@ -62,75 +115,196 @@ func mustGenerateSessionId() string {
 	return string(idAsHex)
 }
 
-// FIXME Implement session expiration.
-type SessionStore struct {
-	lock     *sync.Mutex
-	sessions map[string]Session
+// Session values are gob encoded, which keeps their types, such as int64 user
+// ids, when stored outside of memory.
+func encodeSessionValues(values map[string]interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	err := gob.NewEncoder(&buffer).Encode(values)
+	return buffer.Bytes(), err
 }
 
-func NewSessionStore() *SessionStore {
-	return &SessionStore{&sync.Mutex{}, make(map[string]Session, 0)}
+func decodeSessionValues(data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values)
+	return values, err
 }
 
-func (store *SessionStore) Get(id string) Session {
-	store.lock.Lock()
-	defer store.lock.Unlock()
+// Requests with an API token in the Authorization header are authenticated
+// with the token instead of the session cookie.
+func Sessions(store SessionStore, settings config.SessionConfig) martini.Handler {
+	return func(response http.ResponseWriter, request *http.Request, c martini.Context, render render.Render,
+		db *sql.DB, logger *log.Logger) {
 
-	// Returns empty session if not in map.
-	session, _ := store.sessions[id]
+		if token, hasToken := bearerToken(request); hasToken {
+			apiTokenSession(token, store, response, c, render, db, logger)
+			return
+		}
 
-	// Do not return as pointer since we want a copy. Else it would not be thread safe.
-	return session
-}
+		session := NewSession(store)
 
-func (store *SessionStore) Delete(id string) {
-	store.lock.Lock()
-	defer store.lock.Unlock()
+		cookie, err := request.Cookie(SESSION_COOKIE_NAME)
+		if err == nil {
+			storedSession, err := store.Get(cookie.Value)
+			if err != nil {
+				logger.Printf("Failed to load session: %s", err)
+			} else if storedSession.Id != "" && !deleteIfExpired(storedSession, response, settings, logger) {
+				session = storedSession
+				issueMissingCSRFToken(&session, response, settings, logger)
+				renewSession(&session, response, settings, logger)
+			}
+		}
 
-	delete(store.sessions, id)
+		c.Map(&session)
+	}
 }
 
-func (store *SessionStore) Create(session Session) {
-	store.lock.Lock()
-	defer store.lock.Unlock()
+// Deletes the session and its cookie if the session has expired.
+func deleteIfExpired(session Session, response http.ResponseWriter, settings config.SessionConfig,
+	logger *log.Logger) bool {
+
+	if time.Now().Before(session.expiresAt(settings)) {
+		return false
+	}
+
+	err := session.Delete()
+	if err != nil {
+		logger.Printf("Failed to delete expired session: %s", err)
+	}
 
-	store.sessions[session.Id] = session
+	ClearSessionCookie(response, settings)
+	return true
 }
 
-func (store *SessionStore) Save(session Session) error {
-	store.lock.Lock()
-	defer store.lock.Unlock()
+// Extends the idle timeout and cookie of the session, unless it was renewed
+// recently.
+func renewSession(session *Session, response http.ResponseWriter, settings config.SessionConfig,
+	logger *log.Logger) {
 
-	_, exist := store.sessions[session.Id]
-	if !exist {
-		return fmt.Errorf("Session %d does not exist.", session.Id)
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < SESSION_RENEWAL_INTERVAL {
+		return
 	}
 
-	store.sessions[session.Id] = session
-	return nil
-}
+	session.LastSeenAt = now
 
-func Sessions(name string, store *SessionStore) martini.Handler {
-	return func(res http.ResponseWriter, request *http.Request, c martini.Context, logger *log.Logger) {
-		session := NewSession(store)
+	err := session.Save()
+	if err != nil {
+		logger.Printf("Failed to renew session: %s", err)
+		return
+	}
 
-		cookie, err := request.Cookie("SessionId")
-		if err == nil {
-			tmpSession := store.Get(cookie.Value)
-			if tmpSession.Id != "" {
-				session = tmpSession
+	SetSessionCookie(response, *session, settings)
+}
+
+// Deletes expired sessions every reap interval until the program exits.
+func StartSessionReaper(store SessionStore, settings config.SessionConfig) {
+	go func() {
+		for range time.Tick(time.Duration(settings.ReapInterval)) {
+			now := time.Now()
+			idleBefore := now.Add(-time.Duration(settings.IdleTimeout))
+			createdBefore := now.Add(-time.Duration(settings.Lifetime))
+
+			_, err := store.DeleteExpired(idleBefore, createdBefore)
+			if err != nil {
+				log.Printf("Failed to delete expired sessions: %s", err)
 			}
 		}
+	}()
+}
 
-		c.Map(&session)
+// Sets the session cookie and the CSRF cookie of the session.
+func SetSessionCookie(response http.ResponseWriter, session Session, settings config.SessionConfig) {
+	cookie := sessionCookie(session.Id, settings)
+	cookie.Expires = session.expiresAt(settings)
+	setCookie(response, cookie, settings)
+
+	if session.CSRFToken() != "" {
+		cookie = csrfCookie(session.CSRFToken(), settings)
+		cookie.Expires = session.expiresAt(settings)
+		setCookie(response, cookie, settings)
+	}
+}
+
+// Makes the browser remove the session and CSRF cookies.
+func ClearSessionCookie(response http.ResponseWriter, settings config.SessionConfig) {
+	for _, cookie := range []*http.Cookie{sessionCookie("", settings), csrfCookie("", settings)} {
+		cookie.MaxAge = -1
+		setCookie(response, cookie, settings)
+	}
+}
+
+// Sets the cookie of a login in progress at an OpenID Connect identity
+// provider. The provider redirects back from another site, so SameSite Strict
+// is relaxed to Lax for this cookie.
+func SetOIDCLoginCookie(response http.ResponseWriter, value string, maxAge time.Duration, settings config.SessionConfig) {
+	cookie := sessionCookie(value, settings)
+	cookie.Name = OIDC_LOGIN_COOKIE_NAME
+	cookie.HttpOnly = true
+	cookie.MaxAge = int(maxAge / time.Second)
+
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+
+	if settings.CookieSameSite == "Strict" {
+		settings.CookieSameSite = "Lax"
+	}
+
+	setCookie(response, cookie, settings)
+}
+
+func ClearOIDCLoginCookie(response http.ResponseWriter, settings config.SessionConfig) {
+	SetOIDCLoginCookie(response, "", -1, settings)
+}
+
+func sessionCookie(value string, settings config.SessionConfig) *http.Cookie {
+	return &http.Cookie{
+		Name:     SESSION_COOKIE_NAME,
+		Value:    value,
+		Path:     "/",
+		Domain:   settings.CookieDomain,
+		Secure:   settings.CookieSecure,
+		HttpOnly: settings.CookieHTTPOnly,
+	}
+}
+
+// The SameSite attribute is appended by hand, since http.Cookie only supports
+// it from Go 1.11.
+func setCookie(response http.ResponseWriter, cookie *http.Cookie, settings config.SessionConfig) {
+	value := cookie.String()
+	if settings.CookieSameSite != "" {
+		value += "; SameSite=" + settings.CookieSameSite
+	}
+
+	response.Header().Add("Set-Cookie", value)
+}
+
+func LoginRequired(session *Session, request *http.Request, render render.Render, logger *log.Logger) {
+	var err error
+	if session.Get("userId") == nil {
+		err = models.NewAPIError(401, "Unauthorized", nil)
+	} else {
+		err = authorizeScope(session, request, "")
+	}
+
+	if err != nil {
+		renderErrorAsJson(err, render, logger)
 	}
 }
 
-func AdministratorRequired(session *Session, render render.Render, logger *log.Logger) {
-	isAdministrator := session.Get("isAdministrator")
+// Like LoginRequired, but does not allow API tokens, e.g. for changing the
+// password or managing API tokens.
+func SessionLoginRequired(session *Session, render render.Render, logger *log.Logger) {
+	var err error
+	if session.Get("userId") == nil {
+		err = models.NewAPIError(401, "Unauthorized", nil)
+	} else if session.APIToken != nil {
+		err = models.NewAPIError(403, "Not allowed with an API token", nil)
+	}
 
-	if isAdministrator == nil || !isAdministrator.(bool) {
-		err := models.NewAPIError(401, "Unauthorized", nil)
+	if err != nil {
 		renderErrorAsJson(err, render, logger)
 	}
 }
@@ -0,0 +1,219 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"hiking_trails/src/models"
+)
+
+// Versioned schema migrations. Migrations are applied in version order, each
+// in its own transaction, and applied versions are recorded in the
+// schema_migrations table. Each database dialect has its own migrations, and
+// a new migration is appended to the migrations of every dialect. Released
+// migrations must never change.
+
+var ALL_MIGRATIONS = map[string][]Migration{
+	models.SQLiteDialect{}.Name():   SQLITE_MIGRATIONS,
+	models.PostgresDialect{}.Name(): POSTGRES_MIGRATIONS,
+}
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+
+	// Fills in values of existing rows that SQL can not compute, such as
+	// lengths of polylines. Runs after Up in the same transaction.
+	Backfill func(handle models.DatabaseHandle) error
+
+	// Query that counts the tables or columns the migration creates. Databases
+	// created before migrations existed already have the changes of the first
+	// migrations, which are recorded as applied without running them if the
+	// count is not 0.
+	Detect string
+}
+
+// Migration and when it was applied. AppliedAt is empty for pending migrations.
+type Status struct {
+	Migration
+	AppliedAt string
+}
+
+// Migrations of the database dialect in use.
+func mustGetMigrations() []Migration {
+	migrations, exist := ALL_MIGRATIONS[models.Dialect.Name()]
+	if !exist {
+		panic(fmt.Sprintf("No migrations for database dialect '%s'", models.Dialect.Name()))
+	}
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			panic(fmt.Sprintf("Migration '%s' has version %d, expected %d", migration.Name, migration.Version, i+1))
+		}
+	}
+
+	return migrations
+}
+
+func createMigrationsTableIfNotExist(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY,
+                                                name VARCHAR(255),
+                                                applied_at VARCHAR(255) NOT NULL DEFAULT CURRENT_TIMESTAMP);
+ `)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create 'schema_migrations' database table: %s", err)
+	}
+
+	return nil
+}
+
+// Returns when each applied version was applied.
+func appliedVersions(db *sql.DB) (map[int]string, error) {
+	err := createMigrationsTableIfNotExist(db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load applied migrations: %s", err)
+	}
+	defer rows.Close()
+
+	versions := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var appliedAt string
+
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load applied migration from row: %s", err)
+		}
+
+		versions[version] = appliedAt
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load applied migrations: %s", err)
+	}
+
+	return versions, nil
+}
+
+func GetStatus(db *sql.DB) ([]Status, error) {
+	migrations := mustGetMigrations()
+
+	versions, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(migrations))
+	for _, migration := range migrations {
+		statuses = append(statuses, Status{migration, versions[migration.Version]})
+	}
+
+	return statuses, nil
+}
+
+// Applies all pending migrations and returns them.
+func Up(db *sql.DB) ([]Migration, error) {
+	migrations := mustGetMigrations()
+
+	versions, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	unversioned := len(versions) == 0
+
+	applied := make([]Migration, 0)
+	for _, migration := range migrations {
+		if _, exist := versions[migration.Version]; exist {
+			continue
+		}
+
+		statements, backfill := migration.Up, migration.Backfill
+		if unversioned && migration.Detect != "" {
+			var count int
+			err = db.QueryRow(migration.Detect).Scan(&count)
+			if err != nil {
+				return applied, fmt.Errorf("Failed to detect migration %d '%s': %s", migration.Version, migration.Name, err)
+			}
+
+			if count > 0 {
+				statements, backfill = "", nil
+			}
+		}
+
+		err = migrate(db, statements, backfill, "INSERT INTO schema_migrations(version, name) VALUES(?,?)",
+			migration.Version, migration.Name)
+
+		if err != nil {
+			return applied, fmt.Errorf("Failed to apply migration %d '%s': %s", migration.Version, migration.Name, err)
+		}
+
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+// Reverts the latest applied migration and returns it, or nil if no migration
+// has been applied.
+func Down(db *sql.DB) (*Migration, error) {
+	migrations := mustGetMigrations()
+
+	versions, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if _, exist := versions[migration.Version]; !exist {
+			continue
+		}
+
+		err = migrate(db, migration.Down, nil, "DELETE FROM schema_migrations WHERE version=?", migration.Version)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to revert migration %d '%s': %s", migration.Version, migration.Name, err)
+		}
+
+		return &migration, nil
+	}
+
+	return nil, nil
+}
+
+// Runs the migration statements and the backfill, if any, and records the
+// change in schema_migrations in one transaction.
+func migrate(db *sql.DB, statements string, backfill func(handle models.DatabaseHandle) error, record string,
+	arguments ...interface{}) error {
+
+	transaction, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if statements != "" {
+		_, err = transaction.Exec(statements)
+	}
+	if err == nil && backfill != nil {
+		err = backfill(transaction)
+	}
+	if err == nil {
+		_, err = transaction.Exec(record, arguments...)
+	}
+
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+
+	return transaction.Commit()
+}
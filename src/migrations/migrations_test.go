@@ -0,0 +1,259 @@
+package migrations
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"hiking_trails/src/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tables as created by the application before migrations existed.
+const BASELINE_SCHEMA = `
+	CREATE TABLE IF NOT EXISTS users (id integer not null primary key,
+                                    username VARCHAR(255) NOT NULL,
+                                    salt VARCHAR(255),
+                                    hashed_password VARCHAR(255),
+                                    is_administrator BOOLEAN,
+                                    CONSTRAINT username_unique UNIQUE (username));
+	CREATE TABLE IF NOT EXISTS bundles (id integer not null primary key,
+                                      name VARCHAR(255),
+                                      info VARCHAR(255),
+                                      image_url VARCHAR(255));
+	CREATE TABLE IF NOT EXISTS paths (id INTEGER NOT NULL PRIMARY KEY,
+                                    name VARCHAR(255),
+                                    info VARCHAR(255),
+                                    length VARCHAR(255),
+                                    duration VARCHAR(255),
+                                    image_url VARCHAR(255),
+                                    polyline BLOB,
+                                    bundle_id INTEGER NOT NULL REFERENCES bundles(id) ON UPDATE CASCADE ON DELETE CASCADE);
+	CREATE TABLE IF NOT EXISTS places (id integer not null primary key,
+                                     name VARCHAR(255),
+                                     info VARCHAR(255),
+                                     radius BIGINT,
+                                     position BLOB,
+                                     path_id INTEGER NOT NULL REFERENCES paths(id) ON UPDATE CASCADE ON DELETE CASCADE);
+`
+
+// Database file created by the baseline application, with a user, a bundle, a
+// path and a place stored in the legacy coordinate format.
+func openBaselineDatabase(t *testing.T) (*sql.DB, func()) {
+	directory, err := ioutil.TempDir("", "hiking_trails")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(directory, "hiking_trails.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(directory)
+	}
+
+	_, err = db.Exec("CREATE VIRTUAL TABLE fts5_check USING fts5(text); DROP TABLE fts5_check;")
+	if err != nil {
+		cleanup()
+		t.Fatalf("SQLite is built without FTS5, run the tests with -tags sqlite_fts5: %s", err)
+	}
+
+	// Legacy coordinates are latitude and longitude as little endian float32
+	// values without a header.
+	polyline := []byte{
+		0x00, 0x00, 0x78, 0x42, 0x00, 0x00, 0xc0, 0x41, // 62.0, 24.0
+		0x00, 0x00, 0x7c, 0x42, 0x00, 0x00, 0xc8, 0x41, // 63.0, 25.0
+	}
+	position := polyline[8:]
+
+	_, err = db.Exec(BASELINE_SCHEMA + `
+	INSERT INTO users(id, username, salt, hashed_password, is_administrator) VALUES (1, 'admin', 'salt', 'hash', 1);
+	INSERT INTO bundles(id, name, info, image_url) VALUES (1, 'Lakes', 'Trails around lakes', '');`)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO paths(id, name, info, length, duration, image_url, polyline, bundle_id)
+		                  VALUES (1, 'Shore trail', 'Along the shore', '111', '30', '', ?, 1)`, polyline)
+	}
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO places(id, name, info, radius, position, path_id)
+		                  VALUES (1, 'Campfire site', 'Firewood available', 10, ?, 1)`, position)
+	}
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return db, cleanup
+}
+
+func TestUpgradesBaselineDatabase(t *testing.T) {
+	db, cleanup := openBaselineDatabase(t)
+	defer cleanup()
+
+	applied, err := Up(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != len(SQLITE_MIGRATIONS) {
+		t.Fatalf("Applied %d migrations, expected %d", len(applied), len(SQLITE_MIGRATIONS))
+	}
+
+	var role, difficulty string
+	err = db.QueryRow("SELECT role FROM users WHERE id=1").Scan(&role)
+	if err == nil {
+		err = db.QueryRow("SELECT difficulty FROM paths WHERE id=1").Scan(&difficulty)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if role != models.ROLE_ADMINISTRATOR {
+		t.Errorf("Administrator has role '%s'", role)
+	}
+
+	hits, err := models.Search(db, "campfire", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hits) != 1 || hits[0].Kind != "place" || hits[0].Id != 1 {
+		t.Errorf("Search of existing place returned %v", hits)
+	}
+
+	checkBackfilledPath(t, db)
+	checkBackfilledPlace(t, db)
+}
+
+func checkBackfilledPath(t *testing.T, db *sql.DB) {
+	var lengthMeters, minLatitude, maxLongitude float64
+	var durationSeconds, indexed, polylines int
+
+	err := db.QueryRow("SELECT length_meters, duration_seconds, min_latitude, max_longitude FROM paths WHERE id=1").
+		Scan(&lengthMeters, &durationSeconds, &minLatitude, &maxLongitude)
+	if err == nil {
+		err = db.QueryRow("SELECT COUNT(*) FROM paths_rtree WHERE id=1 AND min_latitude<=62.5 AND max_latitude>=62.5").
+			Scan(&indexed)
+	}
+	if err == nil {
+		err = db.QueryRow("SELECT COUNT(*) FROM path_polylines WHERE path_id=1").Scan(&polylines)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The points are about 124 kilometers apart.
+	if lengthMeters < 120000 || lengthMeters > 130000 || durationSeconds <= 0 {
+		t.Errorf("Path has length %f m and duration %d s", lengthMeters, durationSeconds)
+	}
+
+	if minLatitude != 62 || maxLongitude != 25 {
+		t.Errorf("Path has bounding box from latitude %f to longitude %f", minLatitude, maxLongitude)
+	}
+
+	if indexed != 1 {
+		t.Errorf("Path is not in the R*Tree index")
+	}
+
+	if polylines != len(models.GENERALIZED_ZOOM_LEVELS) {
+		t.Errorf("Path has %d generalized polylines, expected %d", polylines, len(models.GENERALIZED_ZOOM_LEVELS))
+	}
+}
+
+func checkBackfilledPlace(t *testing.T, db *sql.DB) {
+	var latitude, longitude float64
+	var indexed int
+
+	err := db.QueryRow("SELECT latitude, longitude FROM places WHERE id=1").Scan(&latitude, &longitude)
+	if err == nil {
+		err = db.QueryRow("SELECT COUNT(*) FROM places_rtree WHERE id=1 AND min_latitude=63 AND min_longitude=25").
+			Scan(&indexed)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if latitude != 63 || longitude != 25 {
+		t.Errorf("Place is at %f, %f", latitude, longitude)
+	}
+
+	if indexed != 1 {
+		t.Errorf("Place is not in the R*Tree index")
+	}
+}
+
+func TestRevertsAndReappliesAllMigrations(t *testing.T) {
+	db, cleanup := openBaselineDatabase(t)
+	defer cleanup()
+
+	_, err := Up(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := len(SQLITE_MIGRATIONS); i > 0; i-- {
+		migration, err := Down(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if migration == nil || migration.Version != i {
+			t.Fatalf("Reverted %v, expected version %d", migration, i)
+		}
+	}
+
+	_, err = db.Exec(BASELINE_SCHEMA)
+	if err == nil {
+		_, err = Up(db)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Tables and columns of the first migrations were created on startup before
+// migrations existed.
+const LAST_PRE_MIGRATIONS_VERSION = 6
+
+func TestRecordsMigrationsOfDatabaseCreatedBeforeMigrations(t *testing.T) {
+	db, cleanup := openBaselineDatabase(t)
+	defer cleanup()
+
+	applied, err := Up(db)
+	for i := len(applied); err == nil && i > LAST_PRE_MIGRATIONS_VERSION; i-- {
+		_, err = Down(db)
+	}
+	if err == nil {
+		_, err = db.Exec("DROP TABLE schema_migrations")
+	}
+	if err == nil {
+		_, err = Up(db)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := GetStatus(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range statuses {
+		if status.AppliedAt == "" {
+			t.Errorf("Migration %d '%s' is pending", status.Version, status.Name)
+		}
+	}
+
+	var indexed int
+	err = db.QueryRow("SELECT COUNT(*) FROM search_index WHERE kind='place' AND item_id=1").Scan(&indexed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if indexed != 1 {
+		t.Errorf("Place is %d times in the search index", indexed)
+	}
+}
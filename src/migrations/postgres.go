@@ -0,0 +1,251 @@
+package migrations
+
+import (
+	"fmt"
+	"hiking_trails/src/models"
+)
+
+// PostgreSQL migrations, which require the PostGIS extension. The geom columns
+// hold the geometry of paths and places for spatial queries.
+var POSTGRES_MIGRATIONS = []Migration{
+	{
+		Version: 1,
+		Name:    "create_tables",
+		Up: `
+	CREATE TABLE IF NOT EXISTS users (id BIGSERIAL NOT NULL PRIMARY KEY,
+                                    username VARCHAR(255) NOT NULL,
+                                    salt BYTEA,
+                                    hashed_password BYTEA,
+                                    is_administrator BOOLEAN,
+                                    CONSTRAINT username_unique UNIQUE (username));
+
+	-- Id 1 is reserved for the default administrator.
+	SELECT setval('users_id_seq', GREATEST((SELECT MAX(id) FROM users), 1));
+
+	CREATE TABLE IF NOT EXISTS bundles (id BIGSERIAL NOT NULL PRIMARY KEY,
+                                      name VARCHAR(255),
+                                      info VARCHAR(255),
+                                      image_url VARCHAR(255));
+
+	CREATE TABLE IF NOT EXISTS paths (id BIGSERIAL NOT NULL PRIMARY KEY,
+                                    name VARCHAR(255),
+                                    info VARCHAR(255),
+                                    length VARCHAR(255),
+                                    duration VARCHAR(255),
+                                    image_url VARCHAR(255),
+                                    polyline BYTEA,
+                                    bundle_id BIGINT NOT NULL REFERENCES bundles(id) ON UPDATE CASCADE ON DELETE CASCADE);
+
+	CREATE TABLE IF NOT EXISTS places (id BIGSERIAL NOT NULL PRIMARY KEY,
+                                     name VARCHAR(255),
+                                     info VARCHAR(255),
+                                     radius BIGINT,
+                                     position BYTEA,
+                                     path_id BIGINT NOT NULL REFERENCES paths(id) ON UPDATE CASCADE ON DELETE CASCADE);
+
+	CREATE INDEX IF NOT EXISTS paths_bundle_id ON paths(bundle_id);
+	CREATE INDEX IF NOT EXISTS places_path_id ON places(path_id);
+`,
+		Down: `
+	DROP TABLE IF EXISTS places;
+	DROP TABLE IF EXISTS paths;
+	DROP TABLE IF EXISTS bundles;
+	DROP TABLE IF EXISTS users;
+`,
+	},
+	{
+		Version: 2,
+		Name:    "add_path_length_and_duration",
+		Up: `
+	ALTER TABLE paths ADD COLUMN IF NOT EXISTS length_meters DOUBLE PRECISION NOT NULL DEFAULT 0;
+	ALTER TABLE paths ADD COLUMN IF NOT EXISTS duration_seconds BIGINT NOT NULL DEFAULT 0;
+`,
+		Down: `
+	ALTER TABLE paths DROP COLUMN IF EXISTS duration_seconds;
+	ALTER TABLE paths DROP COLUMN IF EXISTS length_meters;
+`,
+		Backfill: models.BackfillPathLengthsAndDurations,
+	},
+	{
+		// The bounding box columns are returned with paths and places, while the
+		// geom columns are used for spatial queries.
+		Version: 3,
+		Name:    "add_bounding_boxes",
+		Up: `
+	CREATE EXTENSION IF NOT EXISTS postgis;
+
+	ALTER TABLE paths ADD COLUMN IF NOT EXISTS min_latitude DOUBLE PRECISION;
+	ALTER TABLE paths ADD COLUMN IF NOT EXISTS min_longitude DOUBLE PRECISION;
+	ALTER TABLE paths ADD COLUMN IF NOT EXISTS max_latitude DOUBLE PRECISION;
+	ALTER TABLE paths ADD COLUMN IF NOT EXISTS max_longitude DOUBLE PRECISION;
+	ALTER TABLE paths ADD COLUMN IF NOT EXISTS geom geometry(Geometry, 4326);
+	ALTER TABLE places ADD COLUMN IF NOT EXISTS latitude REAL;
+	ALTER TABLE places ADD COLUMN IF NOT EXISTS longitude REAL;
+	ALTER TABLE places ADD COLUMN IF NOT EXISTS geom geometry(Point, 4326);
+
+	CREATE INDEX IF NOT EXISTS paths_geom ON paths USING GIST (geom);
+	CREATE INDEX IF NOT EXISTS places_geom ON places USING GIST (geom);
+`,
+		Down: `
+	DROP INDEX IF EXISTS places_geom;
+	DROP INDEX IF EXISTS paths_geom;
+
+	ALTER TABLE places DROP COLUMN IF EXISTS geom;
+	ALTER TABLE places DROP COLUMN IF EXISTS longitude;
+	ALTER TABLE places DROP COLUMN IF EXISTS latitude;
+	ALTER TABLE paths DROP COLUMN IF EXISTS geom;
+	ALTER TABLE paths DROP COLUMN IF EXISTS max_longitude;
+	ALTER TABLE paths DROP COLUMN IF EXISTS max_latitude;
+	ALTER TABLE paths DROP COLUMN IF EXISTS min_longitude;
+	ALTER TABLE paths DROP COLUMN IF EXISTS min_latitude;
+`,
+		Backfill: models.BackfillBoundingBoxes,
+	},
+	{
+		// Generalized polylines of paths, precomputed for a number of zoom levels.
+		Version: 4,
+		Name:    "create_path_polylines",
+		Up: `
+	CREATE TABLE IF NOT EXISTS path_polylines (path_id BIGINT NOT NULL REFERENCES paths(id) ON UPDATE CASCADE ON DELETE CASCADE,
+                                             zoom INTEGER NOT NULL,
+                                             polyline BYTEA,
+                                             PRIMARY KEY (path_id, zoom));
+`,
+		Down: `
+	DROP TABLE IF EXISTS path_polylines;
+`,
+		Backfill: models.BackfillGeneralizedPolylines,
+	},
+	{
+		// Search uses the built in text search of PostgreSQL, with indexes on the
+		// search documents of the tables.
+		Version: 5,
+		Name:    "create_search_index",
+		Up: fmt.Sprintf(`
+	CREATE INDEX IF NOT EXISTS bundles_search ON bundles USING GIN (%[1]s);
+	CREATE INDEX IF NOT EXISTS paths_search ON paths USING GIN (%[1]s);
+	CREATE INDEX IF NOT EXISTS places_search ON places USING GIN (%[1]s);
+`, models.POSTGRES_SEARCH_DOCUMENT),
+		Down: `
+	DROP INDEX IF EXISTS places_search;
+	DROP INDEX IF EXISTS paths_search;
+	DROP INDEX IF EXISTS bundles_search;
+`,
+	},
+	{
+		Version: 6,
+		Name:    "add_path_difficulty",
+		Up: `
+	ALTER TABLE paths ADD COLUMN IF NOT EXISTS difficulty VARCHAR(255) NOT NULL DEFAULT '';
+`,
+		Down: `
+	ALTER TABLE paths DROP COLUMN IF EXISTS difficulty;
+`,
+	},
+	{
+		// Existing passwords are MD5 hashes, replaced by Argon2id hashes on the
+		// next login. Reverting makes users with Argon2id hashes unable to log in
+		// until their passwords are reset.
+		Version: 7,
+		Name:    "add_password_hash_algorithm",
+		Up: `
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS hash_algorithm VARCHAR(255) NOT NULL DEFAULT 'md5';
+`,
+		Down: `
+	ALTER TABLE users DROP COLUMN IF EXISTS hash_algorithm;
+`,
+	},
+	{
+		Version: 8,
+		Name:    "create_sessions",
+		Up: `
+	CREATE TABLE IF NOT EXISTS sessions (id VARCHAR(64) NOT NULL PRIMARY KEY,
+                                       data BYTEA,
+                                       created_at BIGINT NOT NULL,
+                                       last_seen_at BIGINT NOT NULL,
+                                       user_id BIGINT);
+	CREATE INDEX IF NOT EXISTS sessions_last_seen_at ON sessions(last_seen_at);
+	CREATE INDEX IF NOT EXISTS sessions_user_id ON sessions(user_id);
+`,
+		Down: `
+	DROP TABLE IF EXISTS sessions;
+`,
+	},
+	{
+		// Administrators keep their rights through the administrator role, all
+		// other users become viewers. Bundles created before roles have no owner
+		// and are managed by administrators.
+		Version: 9,
+		Name:    "add_roles",
+		Up: `
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(255) NOT NULL DEFAULT 'viewer';
+	UPDATE users SET role='administrator' WHERE is_administrator;
+	ALTER TABLE users DROP COLUMN IF EXISTS is_administrator;
+
+	CREATE TABLE IF NOT EXISTS bundle_permissions (bundle_id BIGINT NOT NULL REFERENCES bundles(id) ON UPDATE CASCADE ON DELETE CASCADE,
+                                                 user_id BIGINT NOT NULL REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+                                                 role VARCHAR(255) NOT NULL,
+                                                 PRIMARY KEY (bundle_id, user_id));
+	CREATE INDEX IF NOT EXISTS bundle_permissions_user_id ON bundle_permissions(user_id);
+`,
+		Down: `
+	DROP TABLE IF EXISTS bundle_permissions;
+
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS is_administrator BOOLEAN;
+	UPDATE users SET is_administrator=(role='administrator');
+	ALTER TABLE users DROP COLUMN IF EXISTS role;
+`,
+	},
+	{
+		// Only SHA-256 hashes of API tokens are stored. Scopes are comma separated,
+		// timestamps are Unix seconds.
+		Version: 10,
+		Name:    "create_api_tokens",
+		Up: `
+	CREATE TABLE IF NOT EXISTS api_tokens (id BIGSERIAL NOT NULL PRIMARY KEY,
+                                         user_id BIGINT NOT NULL REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+                                         name VARCHAR(255) NOT NULL,
+                                         token_hash VARCHAR(64) NOT NULL,
+                                         scopes VARCHAR(255) NOT NULL,
+                                         created_at BIGINT NOT NULL,
+                                         expires_at BIGINT NOT NULL,
+                                         last_used_at BIGINT,
+                                         CONSTRAINT token_hash_unique UNIQUE (token_hash));
+	CREATE INDEX IF NOT EXISTS api_tokens_user_id ON api_tokens(user_id);
+`,
+		Down: `
+	DROP TABLE IF EXISTS api_tokens;
+`,
+	},
+	{
+		// Audit of logins, also used to throttle repeated failed logins.
+		Version: 11,
+		Name:    "create_login_attempts",
+		Up: `
+	CREATE TABLE IF NOT EXISTS login_attempts (id BIGSERIAL NOT NULL PRIMARY KEY,
+                                             username VARCHAR(255) NOT NULL,
+                                             ip_address VARCHAR(64) NOT NULL,
+                                             attempted_at BIGINT NOT NULL,
+                                             succeeded BOOLEAN NOT NULL);
+	CREATE INDEX IF NOT EXISTS login_attempts_username ON login_attempts(username, attempted_at);
+	CREATE INDEX IF NOT EXISTS login_attempts_ip_address ON login_attempts(ip_address, attempted_at);
+`,
+		Down: `
+	DROP TABLE IF EXISTS login_attempts;
+`,
+	},
+	{
+		// Users logging in with OpenID Connect are identified by the subject of
+		// the identity provider. They have no password.
+		Version: 12,
+		Name:    "add_oidc_subject",
+		Up: `
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_subject VARCHAR(255);
+	CREATE UNIQUE INDEX IF NOT EXISTS users_oidc_subject ON users(oidc_subject);
+`,
+		Down: `
+	DROP INDEX IF EXISTS users_oidc_subject;
+	ALTER TABLE users DROP COLUMN IF EXISTS oidc_subject;
+`,
+	},
+}
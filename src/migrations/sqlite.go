@@ -0,0 +1,298 @@
+package migrations
+
+import (
+	"fmt"
+	"hiking_trails/src/models"
+)
+
+var SQLITE_MIGRATIONS = []Migration{
+	{
+		// The schema of databases created before migrations existed, which
+		// therefore must never change.
+		Version: 1,
+		Name:    "create_tables",
+		Up: `
+	CREATE TABLE IF NOT EXISTS users (id integer not null primary key,
+                                    username VARCHAR(255) NOT NULL,
+                                    salt VARCHAR(255),
+                                    hashed_password VARCHAR(255),
+                                    is_administrator BOOLEAN,
+                                    CONSTRAINT username_unique UNIQUE (username));
+
+	CREATE TABLE IF NOT EXISTS bundles (id integer not null primary key,
+                                      name VARCHAR(255),
+                                      info VARCHAR(255),
+                                      image_url VARCHAR(255));
+
+	CREATE TABLE IF NOT EXISTS paths (id INTEGER NOT NULL PRIMARY KEY,
+                                    name VARCHAR(255),
+                                    info VARCHAR(255),
+                                    length VARCHAR(255),
+                                    duration VARCHAR(255),
+                                    image_url VARCHAR(255),
+                                    polyline BLOB,
+                                    bundle_id INTEGER NOT NULL REFERENCES bundles(id) ON UPDATE CASCADE ON DELETE CASCADE);
+
+	CREATE TABLE IF NOT EXISTS places (id integer not null primary key,
+                                     name VARCHAR(255),
+                                     info VARCHAR(255),
+                                     radius BIGINT,
+                                     position BLOB,
+                                     path_id INTEGER NOT NULL REFERENCES paths(id) ON UPDATE CASCADE ON DELETE CASCADE);
+`,
+		Down: `
+	DROP TABLE IF EXISTS places;
+	DROP TABLE IF EXISTS paths;
+	DROP TABLE IF EXISTS bundles;
+	DROP TABLE IF EXISTS users;
+`,
+	},
+	{
+		Version: 2,
+		Name:    "add_path_length_and_duration",
+		Up: `
+	ALTER TABLE paths ADD COLUMN length_meters REAL NOT NULL DEFAULT 0;
+	ALTER TABLE paths ADD COLUMN duration_seconds INTEGER NOT NULL DEFAULT 0;
+`,
+		Down: `
+	ALTER TABLE paths DROP COLUMN duration_seconds;
+	ALTER TABLE paths DROP COLUMN length_meters;
+`,
+		Backfill: models.BackfillPathLengthsAndDurations,
+		Detect:   "SELECT COUNT(*) FROM pragma_table_info('paths') WHERE name='length_meters'",
+	},
+	{
+		// R*Tree indexes of path bounding boxes and place positions, kept in sync
+		// with the paths and places tables by triggers.
+		Version: 3,
+		Name:    "add_bounding_boxes",
+		Up: `
+	ALTER TABLE paths ADD COLUMN min_latitude REAL;
+	ALTER TABLE paths ADD COLUMN min_longitude REAL;
+	ALTER TABLE paths ADD COLUMN max_latitude REAL;
+	ALTER TABLE paths ADD COLUMN max_longitude REAL;
+	ALTER TABLE places ADD COLUMN latitude REAL;
+	ALTER TABLE places ADD COLUMN longitude REAL;
+
+	CREATE VIRTUAL TABLE IF NOT EXISTS paths_rtree USING rtree(id, min_latitude, max_latitude, min_longitude, max_longitude);
+	CREATE VIRTUAL TABLE IF NOT EXISTS places_rtree USING rtree(id, min_latitude, max_latitude, min_longitude, max_longitude);
+
+	CREATE TRIGGER IF NOT EXISTS paths_rtree_insert AFTER INSERT ON paths WHEN new.min_latitude IS NOT NULL BEGIN
+		INSERT INTO paths_rtree VALUES (new.id, new.min_latitude, new.max_latitude, new.min_longitude, new.max_longitude);
+	END;
+	CREATE TRIGGER IF NOT EXISTS paths_rtree_update AFTER UPDATE ON paths BEGIN
+		DELETE FROM paths_rtree WHERE id=old.id;
+		INSERT INTO paths_rtree SELECT new.id, new.min_latitude, new.max_latitude, new.min_longitude, new.max_longitude
+		                        WHERE new.min_latitude IS NOT NULL;
+	END;
+	CREATE TRIGGER IF NOT EXISTS paths_rtree_delete AFTER DELETE ON paths BEGIN
+		DELETE FROM paths_rtree WHERE id=old.id;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS places_rtree_insert AFTER INSERT ON places WHEN new.latitude IS NOT NULL BEGIN
+		INSERT INTO places_rtree VALUES (new.id, new.latitude, new.latitude, new.longitude, new.longitude);
+	END;
+	CREATE TRIGGER IF NOT EXISTS places_rtree_update AFTER UPDATE ON places BEGIN
+		DELETE FROM places_rtree WHERE id=old.id;
+		INSERT INTO places_rtree SELECT new.id, new.latitude, new.latitude, new.longitude, new.longitude
+		                         WHERE new.latitude IS NOT NULL;
+	END;
+	CREATE TRIGGER IF NOT EXISTS places_rtree_delete AFTER DELETE ON places BEGIN
+		DELETE FROM places_rtree WHERE id=old.id;
+	END;
+`,
+		Down: `
+	DROP TRIGGER IF EXISTS places_rtree_delete;
+	DROP TRIGGER IF EXISTS places_rtree_update;
+	DROP TRIGGER IF EXISTS places_rtree_insert;
+	DROP TRIGGER IF EXISTS paths_rtree_delete;
+	DROP TRIGGER IF EXISTS paths_rtree_update;
+	DROP TRIGGER IF EXISTS paths_rtree_insert;
+	DROP TABLE IF EXISTS places_rtree;
+	DROP TABLE IF EXISTS paths_rtree;
+
+	ALTER TABLE places DROP COLUMN longitude;
+	ALTER TABLE places DROP COLUMN latitude;
+	ALTER TABLE paths DROP COLUMN max_longitude;
+	ALTER TABLE paths DROP COLUMN max_latitude;
+	ALTER TABLE paths DROP COLUMN min_longitude;
+	ALTER TABLE paths DROP COLUMN min_latitude;
+`,
+		Backfill: models.BackfillBoundingBoxes,
+		Detect:   "SELECT COUNT(*) FROM sqlite_master WHERE name='paths_rtree'",
+	},
+	{
+		// Generalized polylines of paths, precomputed for a number of zoom levels.
+		Version: 4,
+		Name:    "create_path_polylines",
+		Up: `
+	CREATE TABLE IF NOT EXISTS path_polylines (path_id INTEGER NOT NULL REFERENCES paths(id) ON UPDATE CASCADE ON DELETE CASCADE,
+                                             zoom INTEGER NOT NULL,
+                                             polyline BLOB,
+                                             PRIMARY KEY (path_id, zoom));
+`,
+		Down: `
+	DROP TABLE IF EXISTS path_polylines;
+`,
+		Backfill: models.BackfillGeneralizedPolylines,
+		Detect:   "SELECT COUNT(*) FROM sqlite_master WHERE name='path_polylines'",
+	},
+	{
+		// FTS5 full text index of names and descriptions of bundles, paths and
+		// places, kept in sync by triggers. Requires the sqlite_fts5 build tag.
+		Version: 5,
+		Name:    "create_search_index",
+		Up: `
+	CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(kind UNINDEXED, item_id UNINDEXED, name, info,
+	                                                        tokenize='unicode61 remove_diacritics 1');
+` + searchIndexTriggers("bundles", "bundle") + searchIndexTriggers("paths", "path") + searchIndexTriggers("places", "place") + `
+	INSERT INTO search_index(kind, item_id, name, info)
+	SELECT 'bundle', id, name, info FROM bundles
+	UNION ALL SELECT 'path', id, name, info FROM paths
+	UNION ALL SELECT 'place', id, name, info FROM places;
+`,
+		Down: dropSearchIndexTriggers("places") + dropSearchIndexTriggers("paths") + dropSearchIndexTriggers("bundles") + `
+	DROP TABLE IF EXISTS search_index;
+`,
+		Detect: "SELECT COUNT(*) FROM sqlite_master WHERE name='search_index'",
+	},
+	{
+		Version: 6,
+		Name:    "add_path_difficulty",
+		Up: `
+	ALTER TABLE paths ADD COLUMN difficulty VARCHAR(255) NOT NULL DEFAULT '';
+`,
+		Down: `
+	ALTER TABLE paths DROP COLUMN difficulty;
+`,
+		Detect: "SELECT COUNT(*) FROM pragma_table_info('paths') WHERE name='difficulty'",
+	},
+	{
+		// Existing passwords are MD5 hashes, replaced by Argon2id hashes on the
+		// next login. Reverting makes users with Argon2id hashes unable to log in
+		// until their passwords are reset.
+		Version: 7,
+		Name:    "add_password_hash_algorithm",
+		Up: `
+	ALTER TABLE users ADD COLUMN hash_algorithm VARCHAR(255) NOT NULL DEFAULT 'md5';
+`,
+		Down: `
+	ALTER TABLE users DROP COLUMN hash_algorithm;
+`,
+	},
+	{
+		Version: 8,
+		Name:    "create_sessions",
+		Up: `
+	CREATE TABLE IF NOT EXISTS sessions (id VARCHAR(64) NOT NULL PRIMARY KEY,
+                                       data BLOB,
+                                       created_at INTEGER NOT NULL,
+                                       last_seen_at INTEGER NOT NULL,
+                                       user_id INTEGER);
+	CREATE INDEX IF NOT EXISTS sessions_last_seen_at ON sessions(last_seen_at);
+	CREATE INDEX IF NOT EXISTS sessions_user_id ON sessions(user_id);
+`,
+		Down: `
+	DROP TABLE IF EXISTS sessions;
+`,
+	},
+	{
+		// Administrators keep their rights through the administrator role, all
+		// other users become viewers. Bundles created before roles have no owner
+		// and are managed by administrators.
+		Version: 9,
+		Name:    "add_roles",
+		Up: `
+	ALTER TABLE users ADD COLUMN role VARCHAR(255) NOT NULL DEFAULT 'viewer';
+	UPDATE users SET role='administrator' WHERE is_administrator;
+	ALTER TABLE users DROP COLUMN is_administrator;
+
+	CREATE TABLE IF NOT EXISTS bundle_permissions (bundle_id INTEGER NOT NULL REFERENCES bundles(id) ON UPDATE CASCADE ON DELETE CASCADE,
+                                                 user_id INTEGER NOT NULL REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+                                                 role VARCHAR(255) NOT NULL,
+                                                 PRIMARY KEY (bundle_id, user_id));
+	CREATE INDEX IF NOT EXISTS bundle_permissions_user_id ON bundle_permissions(user_id);
+`,
+		Down: `
+	DROP TABLE IF EXISTS bundle_permissions;
+
+	ALTER TABLE users ADD COLUMN is_administrator BOOLEAN;
+	UPDATE users SET is_administrator=(role='administrator');
+	ALTER TABLE users DROP COLUMN role;
+`,
+	},
+	{
+		// Only SHA-256 hashes of API tokens are stored. Scopes are comma separated,
+		// timestamps are Unix seconds.
+		Version: 10,
+		Name:    "create_api_tokens",
+		Up: `
+	CREATE TABLE IF NOT EXISTS api_tokens (id INTEGER NOT NULL PRIMARY KEY,
+                                         user_id INTEGER NOT NULL REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+                                         name VARCHAR(255) NOT NULL,
+                                         token_hash VARCHAR(64) NOT NULL,
+                                         scopes VARCHAR(255) NOT NULL,
+                                         created_at INTEGER NOT NULL,
+                                         expires_at INTEGER NOT NULL,
+                                         last_used_at INTEGER,
+                                         CONSTRAINT token_hash_unique UNIQUE (token_hash));
+	CREATE INDEX IF NOT EXISTS api_tokens_user_id ON api_tokens(user_id);
+`,
+		Down: `
+	DROP TABLE IF EXISTS api_tokens;
+`,
+	},
+	{
+		// Audit of logins, also used to throttle repeated failed logins.
+		Version: 11,
+		Name:    "create_login_attempts",
+		Up: `
+	CREATE TABLE IF NOT EXISTS login_attempts (id INTEGER NOT NULL PRIMARY KEY,
+                                             username VARCHAR(255) NOT NULL,
+                                             ip_address VARCHAR(64) NOT NULL,
+                                             attempted_at INTEGER NOT NULL,
+                                             succeeded BOOLEAN NOT NULL);
+	CREATE INDEX IF NOT EXISTS login_attempts_username ON login_attempts(username, attempted_at);
+	CREATE INDEX IF NOT EXISTS login_attempts_ip_address ON login_attempts(ip_address, attempted_at);
+`,
+		Down: `
+	DROP TABLE IF EXISTS login_attempts;
+`,
+	},
+	{
+		// Users logging in with OpenID Connect are identified by the subject of
+		// the identity provider. They have no password.
+		Version: 12,
+		Name:    "add_oidc_subject",
+		Up: `
+	ALTER TABLE users ADD COLUMN oidc_subject VARCHAR(255);
+	CREATE UNIQUE INDEX IF NOT EXISTS users_oidc_subject ON users(oidc_subject);
+`,
+		Down: `
+	DROP INDEX IF EXISTS users_oidc_subject;
+	ALTER TABLE users DROP COLUMN oidc_subject;
+`,
+	},
+}
+
+func searchIndexTriggers(table string, kind string) string {
+	return fmt.Sprintf(`
+	CREATE TRIGGER IF NOT EXISTS %[1]s_search_insert AFTER INSERT ON %[1]s BEGIN
+		INSERT INTO search_index(kind, item_id, name, info) VALUES ('%[2]s', new.id, new.name, new.info);
+	END;
+	CREATE TRIGGER IF NOT EXISTS %[1]s_search_update AFTER UPDATE OF name, info ON %[1]s BEGIN
+		UPDATE search_index SET name=new.name, info=new.info WHERE kind='%[2]s' AND item_id=old.id;
+	END;
+	CREATE TRIGGER IF NOT EXISTS %[1]s_search_delete AFTER DELETE ON %[1]s BEGIN
+		DELETE FROM search_index WHERE kind='%[2]s' AND item_id=old.id;
+	END;
+`, table, kind)
+}
+
+func dropSearchIndexTriggers(table string) string {
+	return fmt.Sprintf(`
+	DROP TRIGGER IF EXISTS %[1]s_search_delete;
+	DROP TRIGGER IF EXISTS %[1]s_search_update;
+	DROP TRIGGER IF EXISTS %[1]s_search_insert;
+`, table)
+}
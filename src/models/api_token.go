@@ -0,0 +1,242 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"github.com/martini-contrib/binding"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Personal access tokens let scripts use the API without logging in. A token
+// acts as its user, limited to the scopes of the token. Tokens are random, so
+// they are stored as SHA-256 hashes rather than slow password hashes, and the
+// token itself is only returned when it is created.
+
+const (
+	// Reading resources, i.e. GET and HEAD requests.
+	API_TOKEN_SCOPE_READ = "read"
+
+	// Creating, updating and deleting bundles, paths and places.
+	API_TOKEN_SCOPE_WRITE = "write"
+
+	// Requests requiring the administrator role.
+	API_TOKEN_SCOPE_ADMIN = "admin"
+
+	// Makes tokens recognizable, e.g. by secret scanners.
+	API_TOKEN_PREFIX = "ht_"
+
+	API_TOKEN_BYTES = 32
+
+	DEFAULT_API_TOKEN_LIFETIME = 90 * 24 * time.Hour
+	MAX_API_TOKEN_LIFETIME     = 366 * 24 * time.Hour
+)
+
+var API_TOKEN_SCOPES = []string{API_TOKEN_SCOPE_READ, API_TOKEN_SCOPE_WRITE, API_TOKEN_SCOPE_ADMIN}
+
+// id (int) Token id.
+// name (string) What the token is used for.
+// scopes (array) Scopes of the token, read, write and admin.
+// expiresAt (string) When the token expires, defaults to 90 days after creation.
+// token (string) The token, only returned when it is created.
+
+type APIToken struct {
+	Id         int64      `json:"id"`
+	Name       string     `json:"name"               binding:"required"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	Token      string     `json:"token,omitempty"`
+	UserId     int64      `json:"-"`
+}
+
+func (token APIToken) Validate(errors binding.Errors, req *http.Request) binding.Errors {
+	validateStringLength("name", token.Name, 1, 255, &errors)
+
+	if len(token.Scopes) == 0 {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"scopes"},
+			Classification: "ComplaintError",
+			Message:        "At least one scope is required",
+		})
+	}
+
+	for _, scope := range token.Scopes {
+		if !isAPITokenScope(scope) {
+			errors = append(errors, binding.Error{
+				FieldNames:     []string{"scopes"},
+				Classification: "ComplaintError",
+				Message:        fmt.Sprintf("Scopes must be some of %s", strings.Join(API_TOKEN_SCOPES, ", ")),
+			})
+			break
+		}
+	}
+
+	now := time.Now()
+	if !token.ExpiresAt.IsZero() && (token.ExpiresAt.Before(now) || token.ExpiresAt.After(now.Add(MAX_API_TOKEN_LIFETIME))) {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"expiresAt"},
+			Classification: "ComplaintError",
+			Message:        fmt.Sprintf("Expiry must be in the future and at most %d days ahead", MAX_API_TOKEN_LIFETIME/(24*time.Hour)),
+		})
+	}
+
+	return errors
+}
+
+func isAPITokenScope(scope string) bool {
+	for _, candidate := range API_TOKEN_SCOPES {
+		if candidate == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (token *APIToken) HasScope(scope string) bool {
+	for _, candidate := range token.Scopes {
+		if candidate == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Generates the token and saves its hash. The token is kept in Token, so it
+// can be returned once.
+func (token *APIToken) Save(execer SQLExecer) error {
+	token.Token = API_TOKEN_PREFIX + mustGenerateAPITokenSecret()
+	token.CreatedAt = time.Now()
+
+	if token.ExpiresAt.IsZero() {
+		token.ExpiresAt = token.CreatedAt.Add(DEFAULT_API_TOKEN_LIFETIME)
+	}
+
+	id, err := Dialect.insert(execer, "INSERT INTO api_tokens(user_id, name, token_hash, scopes, created_at, expires_at) VALUES(?,?,?,?,?,?)",
+		token.UserId,
+		token.Name,
+		hashAPIToken(token.Token),
+		strings.Join(token.Scopes, ","),
+		token.CreatedAt.Unix(),
+		token.ExpiresAt.Unix(),
+	)
+
+	if err != nil {
+		return NewAPIError(500, "Failed to create API token", err)
+	}
+
+	token.Id = id
+	return nil
+}
+
+func (token *APIToken) UpdateLastUsed(execer SQLExecer) error {
+	now := time.Now()
+
+	_, err := execer.Exec("UPDATE api_tokens SET last_used_at=? WHERE id=?", now.Unix(), token.Id)
+	if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to update API token with id %d", token.Id), err)
+	}
+
+	token.LastUsedAt = &now
+	return nil
+}
+
+// Returns a 401 error if the token does not exist or has expired.
+func LoadAPITokenFromToken(queryer SQLQueryer, secret string) (*APIToken, error) {
+	tokens, err := loadAPITokens(queryer, "token_hash=?", hashAPIToken(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 || !time.Now().Before(tokens[0].ExpiresAt) {
+		return nil, NewAPIError(401, "Invalid or expired API token", nil)
+	}
+
+	return tokens[0], nil
+}
+
+// Tokens of the user, including expired ones.
+func ListAPITokens(queryer SQLQueryer, userId int64) ([]*APIToken, error) {
+	return loadAPITokens(queryer, "user_id=?", userId)
+}
+
+func RevokeAPIToken(execer SQLExecer, userId int64, id int64) error {
+	result, err := execer.Exec("DELETE FROM api_tokens WHERE id=? AND user_id=?", id, userId)
+	if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to revoke API token with id %d", id), err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to revoke API token with id %d", id), err)
+	}
+
+	if rowsAffected == 0 {
+		return NewAPIError(404, fmt.Sprintf("No API token with id %d exist", id), nil)
+	}
+
+	return nil
+}
+
+func loadAPITokens(queryer SQLQueryer, condition string, arguments ...interface{}) ([]*APIToken, error) {
+	rows, err := queryer.Query(selectStatement("id, user_id, name, scopes, created_at, expires_at, last_used_at", "api_tokens", condition)+
+		" ORDER BY id ASC", arguments...)
+	if err != nil {
+		return nil, NewAPIError(500, "Failed to load API tokens", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]*APIToken, 0)
+	for rows.Next() {
+		token := &APIToken{}
+		var scopes string
+		var createdAt, expiresAt int64
+		var lastUsedAt sql.NullInt64
+
+		err = rows.Scan(&token.Id, &token.UserId, &token.Name, &scopes, &createdAt, &expiresAt, &lastUsedAt)
+		if err != nil {
+			return nil, NewAPIError(500, "Failed to load API token from row", err)
+		}
+
+		token.Scopes = strings.Split(scopes, ",")
+		token.CreatedAt = time.Unix(createdAt, 0)
+		token.ExpiresAt = time.Unix(expiresAt, 0)
+
+		if lastUsedAt.Valid {
+			usedAt := time.Unix(lastUsedAt.Int64, 0)
+			token.LastUsedAt = &usedAt
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, NewAPIError(500, "Failed to load API tokens", err)
+	}
+
+	return tokens, nil
+}
+
+func hashAPIToken(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func mustGenerateAPITokenSecret() string {
+	secret := make([]byte, API_TOKEN_BYTES)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(secret)
+}
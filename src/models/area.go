@@ -0,0 +1,175 @@
+package models
+
+import (
+	"fmt"
+	"math"
+)
+
+// Areas used to only load paths and places in the viewport of the map. Paths
+// and places are indexed by bounding box, see SQLDialect.boundingBoxCondition.
+
+const (
+	METERS_PER_DEGREE_LATITUDE = EARTH_RADIUS_METERS * math.Pi / 180
+)
+
+type GEOBoundingBox struct {
+	MinLatitude  float64 `json:"minLat"`
+	MinLongitude float64 `json:"minLng"`
+	MaxLatitude  float64 `json:"maxLat"`
+	MaxLongitude float64 `json:"maxLng"`
+}
+
+// Returns nil if there are no coordinates.
+func (coordinates GEOCoordinates) BoundingBox() *GEOBoundingBox {
+	if len(coordinates) == 0 {
+		return nil
+	}
+
+	box := &GEOBoundingBox{
+		MinLatitude:  math.Inf(1),
+		MinLongitude: math.Inf(1),
+		MaxLatitude:  math.Inf(-1),
+		MaxLongitude: math.Inf(-1),
+	}
+
+	for _, coordinate := range coordinates {
+		box.MinLatitude = math.Min(box.MinLatitude, float64(coordinate.Latitude))
+		box.MinLongitude = math.Min(box.MinLongitude, float64(coordinate.Longitude))
+		box.MaxLatitude = math.Max(box.MaxLatitude, float64(coordinate.Latitude))
+		box.MaxLongitude = math.Max(box.MaxLongitude, float64(coordinate.Longitude))
+	}
+
+	return box
+}
+
+// Values for the min_latitude, min_longitude, max_latitude and max_longitude
+// columns, which are NULL for a nil bounding box.
+func (box *GEOBoundingBox) columnValues() []interface{} {
+	if box == nil {
+		return []interface{}{nil, nil, nil, nil}
+	}
+
+	return []interface{}{box.MinLatitude, box.MinLongitude, box.MaxLatitude, box.MaxLongitude}
+}
+
+// Either a bounding box or a circle with radius in meters around a center.
+type GEOArea struct {
+	BoundingBox GEOBoundingBox
+	Center      *GEOCoordinate
+	Radius      float64
+}
+
+func NewGEOAreaFromBoundingBox(box GEOBoundingBox) (*GEOArea, error) {
+	if !isFinite(box.MinLatitude, box.MinLongitude, box.MaxLatitude, box.MaxLongitude) {
+		return nil, fmt.Errorf("Bounding box must consist of finite numbers")
+	}
+
+	if box.MinLatitude > box.MaxLatitude || box.MinLongitude > box.MaxLongitude {
+		return nil, fmt.Errorf("Bounding box minimum must not be larger than maximum")
+	}
+
+	return &GEOArea{BoundingBox: box}, nil
+}
+
+func NewGEOAreaAroundCoordinate(center GEOCoordinate, radius float64) (*GEOArea, error) {
+	if !isFinite(float64(center.Latitude), float64(center.Longitude)) {
+		return nil, fmt.Errorf("Center must consist of finite numbers")
+	}
+
+	if !isFinite(radius) || radius <= 0 {
+		return nil, fmt.Errorf("Radius must be a finite number larger than 0")
+	}
+
+	latitude := float64(center.Latitude)
+	longitude := float64(center.Longitude)
+	deltaLatitude := radius / METERS_PER_DEGREE_LATITUDE
+	deltaLongitude := 180.0
+
+	cosLatitude := math.Cos(latitude * math.Pi / 180)
+	if cosLatitude > 1e-9 {
+		deltaLongitude = math.Min(180, deltaLatitude/cosLatitude)
+	}
+
+	area := &GEOArea{
+		BoundingBox: GEOBoundingBox{
+			MinLatitude:  latitude - deltaLatitude,
+			MinLongitude: longitude - deltaLongitude,
+			MaxLatitude:  latitude + deltaLatitude,
+			MaxLongitude: longitude + deltaLongitude,
+		},
+		Center: &center,
+		Radius: radius,
+	}
+
+	return area, nil
+}
+
+func (area *GEOArea) ContainsCoordinate(coordinate GEOCoordinate) bool {
+	if area.Center != nil {
+		return area.Center.DistanceTo(coordinate) <= area.Radius
+	}
+
+	box := area.BoundingBox
+	return float64(coordinate.Latitude) >= box.MinLatitude && float64(coordinate.Latitude) <= box.MaxLatitude &&
+		float64(coordinate.Longitude) >= box.MinLongitude && float64(coordinate.Longitude) <= box.MaxLongitude
+}
+
+// Paths are matched by bounding box for bounding box areas, and by the
+// distance from the center to the closest point on the polyline for circles.
+func (area *GEOArea) IntersectsPolyline(coordinates GEOCoordinates) bool {
+	if area.Center == nil {
+		return true
+	}
+
+	return coordinates.DistanceFrom(*area.Center) <= area.Radius
+}
+
+// Approximate distance in meters from point to the closest point on the
+// polyline, using an equirectangular projection centered on point. Accurate
+// enough for the short distances used in searches.
+func (coordinates GEOCoordinates) DistanceFrom(point GEOCoordinate) float64 {
+	if len(coordinates) == 0 {
+		return math.Inf(1)
+	}
+
+	cosLatitude := math.Cos(degreesToRadians(point.Latitude))
+	project := func(coordinate GEOCoordinate) (float64, float64) {
+		x := float64(coordinate.Longitude-point.Longitude) * cosLatitude * METERS_PER_DEGREE_LATITUDE
+		y := float64(coordinate.Latitude-point.Latitude) * METERS_PER_DEGREE_LATITUDE
+		return x, y
+	}
+
+	x, y := project(coordinates[0])
+	closest := math.Hypot(x, y)
+
+	for i := 1; i < len(coordinates); i++ {
+		x1, y1 := project(coordinates[i-1])
+		x2, y2 := project(coordinates[i])
+		closest = math.Min(closest, distanceFromOriginToSegment(x1, y1, x2, y2))
+	}
+
+	return closest
+}
+
+func distanceFromOriginToSegment(x1 float64, y1 float64, x2 float64, y2 float64) float64 {
+	dx, dy := x2-x1, y2-y1
+	lengthSquared := dx*dx + dy*dy
+
+	t := 0.0
+	if lengthSquared > 0 {
+		t = math.Max(0, math.Min(1, -(x1*dx+y1*dy)/lengthSquared))
+	}
+
+	return math.Hypot(x1+t*dx, y1+t*dy)
+}
+
+// Whether none of the values is NaN or infinite.
+func isFinite(values ...float64) bool {
+	for _, value := range values {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"fmt"
+)
+
+// Values computed from polylines and positions, filled in for rows stored
+// before their columns existed. Used by the schema migrations that add the
+// columns. Rows are loaded in batches, since PostgreSQL can not run updates
+// while the rows of a query on the same connection are open.
+
+const (
+	BACKFILL_BATCH_SIZE = 100
+)
+
+func BackfillPathLengthsAndDurations(handle DatabaseHandle) error {
+	return forEachStoredCoordinates(handle, "paths", "polyline", func(id int64, polyline GEOCoordinates) error {
+		path := &Path{Polyline: polyline}
+		path.ComputeLengthAndDuration()
+
+		_, err := handle.Exec("UPDATE paths SET length_meters=?, duration_seconds=? WHERE id=?",
+			path.LengthMeters, path.DurationSeconds, id)
+		return err
+	})
+}
+
+// On SQLite the updates also fill in the R*Tree indexes through their triggers.
+func BackfillBoundingBoxes(handle DatabaseHandle) error {
+	err := forEachStoredCoordinates(handle, "paths", "polyline", func(id int64, polyline GEOCoordinates) error {
+		row := &rowValues{}
+		row.addBoundingBox(polyline.BoundingBox())
+		row.addGeometry(polyline)
+
+		_, err := handle.Exec(row.updateStatement("paths", "id=?"), append(row.arguments, id)...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return forEachStoredCoordinates(handle, "places", "position", func(id int64, position GEOCoordinates) error {
+		// Places without a position stay out of the spatial indexes.
+		if len(position) == 0 {
+			return nil
+		}
+
+		row := &rowValues{}
+		row.add("latitude", position[0].Latitude)
+		row.add("longitude", position[0].Longitude)
+		row.addGeometry(position[:1])
+
+		_, err := handle.Exec(row.updateStatement("places", "id=?"), append(row.arguments, id)...)
+		return err
+	})
+}
+
+func BackfillGeneralizedPolylines(handle DatabaseHandle) error {
+	return forEachStoredCoordinates(handle, "paths", "polyline", func(id int64, polyline GEOCoordinates) error {
+		path := &Path{Id: id, Polyline: polyline}
+		return path.saveGeneralizedPolylines(handle)
+	})
+}
+
+// Calls update with the decoded coordinates in column of every row of table,
+// in order of id.
+func forEachStoredCoordinates(handle DatabaseHandle, table string, column string,
+	update func(id int64, coordinates GEOCoordinates) error) error {
+
+	lastId := int64(0)
+	for {
+		ids, coordinates, err := loadStoredCoordinates(handle, table, column, lastId)
+		if err != nil {
+			return err
+		}
+
+		if len(ids) == 0 {
+			return nil
+		}
+
+		for i, id := range ids {
+			err = update(id, coordinates[i])
+			if err != nil {
+				return fmt.Errorf("Failed to backfill %s with id %d: %s", table, id, err)
+			}
+		}
+
+		lastId = ids[len(ids)-1]
+	}
+}
+
+func loadStoredCoordinates(queryer SQLQueryer, table string, column string, afterId int64) ([]int64, []GEOCoordinates, error) {
+	statement := selectStatement("id, "+column, table, "id>?") + " ORDER BY id LIMIT ?"
+
+	rows, err := queryer.Query(statement, afterId, BACKFILL_BATCH_SIZE)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to load %s: %s", table, err)
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, BACKFILL_BATCH_SIZE)
+	coordinates := make([]GEOCoordinates, 0, BACKFILL_BATCH_SIZE)
+
+	for rows.Next() {
+		var id int64
+		data := make([]byte, 0)
+
+		err = rows.Scan(&id, &data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to load %s from row: %s", table, err)
+		}
+
+		decoded, err := GEOCoordinatesFromBytes(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to decode %s of %s with id %d: %s", column, table, id, err)
+		}
+
+		ids = append(ids, id)
+		coordinates = append(coordinates, decoded)
+	}
+
+	return ids, coordinates, rows.Err()
+}
@@ -19,6 +19,9 @@ type Bundle struct {
 	Info     string  `json:"info"`
 	ImageURL string  `json:"image"`
 	Paths    []*Path `json:"paths"`
+
+	// User made owner of the bundle when it is saved, if any.
+	OwnerId int64 `json:"-"`
 }
 
 func NewBundle() *Bundle {
@@ -52,19 +55,23 @@ func (bundle *Bundle) RequireTransaction() bool {
 }
 
 func (bundle *Bundle) Save(execer SQLExecer) error {
-	result, err := execer.Exec("INSERT INTO bundles(name, info, image_url) VALUES(?,?,?)",
+	id, err := Dialect.insert(execer, "INSERT INTO bundles(name, info, image_url) VALUES(?,?,?)",
 		bundle.Name, bundle.Info, bundle.ImageURL)
 
 	if err != nil {
 		return NewAPIError(500, "Failed to create bundle", err)
 	}
 
-	lastInsertedId, err := result.LastInsertId()
-	if err != nil {
-		return NewAPIError(500, "Failed to retrieve last inserted id when saving bundle", err)
-	}
+	bundle.Id = id
 
-	bundle.Id = lastInsertedId
+	if bundle.OwnerId != 0 {
+		owner := &BundlePermission{BundleId: bundle.Id, UserId: bundle.OwnerId, Role: BUNDLE_ROLE_OWNER}
+
+		err = owner.Grant(execer)
+		if err != nil {
+			return err
+		}
+	}
 
 	for _, path := range bundle.Paths {
 		path.BundleId = bundle.Id
@@ -139,13 +146,20 @@ func (bundle *Bundle) Delete(execer SQLExecer) error {
 	return nil
 }
 
-func LoadBundles(transaction *sql.Tx) ([]*Bundle, error) {
+// Loads a page of the bundles and returns it together with the total number
+// of bundles.
+func LoadBundles(transaction SQLQueryer, options *ListOptions) ([]*Bundle, int64, error) {
 	bundles := make([]*Bundle, 0)
 
-	// TODO: Implement support for limit and offset.
-	rows, err := transaction.Query("SELECT id, name, info, image_url FROM bundles")
+	total, err := countRows(transaction, "bundles", "")
 	if err != nil {
-		return nil, NewAPIError(500, "Failed to load bundles", err)
+		return nil, 0, err
+	}
+
+	limit, arguments := options.limit()
+	rows, err := transaction.Query("SELECT id, name, info, image_url FROM bundles"+options.orderBy()+limit, arguments...)
+	if err != nil {
+		return nil, 0, NewAPIError(500, "Failed to load bundles", err)
 	}
 	defer rows.Close()
 
@@ -154,22 +168,25 @@ func LoadBundles(transaction *sql.Tx) ([]*Bundle, error) {
 
 		err := rows.Scan(&bundle.Id, &bundle.Name, &bundle.Info, &bundle.ImageURL)
 		if err != nil {
-			return nil, NewAPIError(500, "Failed to load bundle from row", err)
+			return nil, 0, NewAPIError(500, "Failed to load bundle from row", err)
 		}
 
-		paths, err := LoadPathsFromDatabase(transaction, bundle.Id)
-		if err != nil {
-			return nil, NewAPIError(500, "Failed to load bundle from row", err)
-		}
-
-		bundle.Paths = paths
 		bundles = append(bundles, bundle)
 	}
 
 	err = rows.Err()
 	if err != nil {
-		return nil, NewAPIError(500, "Failed to load bundles", err)
+		return nil, 0, NewAPIError(500, "Failed to load bundles", err)
+	}
+
+	if !options.loadChildren() {
+		return bundles, total, nil
+	}
+
+	err = loadPathsOfBundles(transaction, bundles)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return bundles, nil
+	return bundles, total, nil
 }
@@ -0,0 +1,82 @@
+package models_test
+
+import (
+	"database/sql"
+	"fmt"
+	"hiking_trails/src/models"
+	"testing"
+)
+
+// Counts the queries run through the wrapped queryer.
+type countingQueryer struct {
+	models.SQLQueryer
+	queries int
+}
+
+func (queryer *countingQueryer) Query(query string, arguments ...interface{}) (*sql.Rows, error) {
+	queryer.queries++
+	return queryer.SQLQueryer.Query(query, arguments...)
+}
+
+func (queryer *countingQueryer) QueryRow(query string, arguments ...interface{}) *sql.Row {
+	queryer.queries++
+	return queryer.SQLQueryer.QueryRow(query, arguments...)
+}
+
+func seedBundles(t testing.TB, db *sql.DB, count int) {
+	for i := 0; i < count; i++ {
+		saveTestBundle(t, db, fmt.Sprintf("Bundle %d", i), "")
+	}
+}
+
+// Paths and places are loaded with one query per table, regardless of the
+// number of bundles.
+func TestLoadsChildrenOfBundlesWithConstantNumberOfQueries(t *testing.T) {
+	db, cleanup := openSQLiteTestDatabase(t)
+	defer cleanup()
+
+	for _, count := range []int{1, 10, 50} {
+		seedBundles(t, db, count-countRows(t, db, "bundles"))
+
+		queryer := &countingQueryer{SQLQueryer: db}
+		bundles, _, err := models.LoadBundles(queryer, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(bundles) != count || len(bundles[count-1].Paths) != 1 || len(bundles[count-1].Paths[0].Places) != 1 {
+			t.Fatalf("Loaded %d bundles, expected %d with a path and a place each", len(bundles), count)
+		}
+
+		// Count of bundles, bundles, paths and places.
+		if queryer.queries != 4 {
+			t.Errorf("Loaded %d bundles with %d queries, expected 4", count, queryer.queries)
+		}
+	}
+}
+
+func countRows(t testing.TB, db *sql.DB, table string) int {
+	var count int
+
+	err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
+
+func BenchmarkLoadBundles(b *testing.B) {
+	db, cleanup := openSQLiteTestDatabase(b)
+	defer cleanup()
+
+	seedBundles(b, db, 200)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, _, err := models.LoadBundles(db, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
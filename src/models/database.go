@@ -5,6 +5,10 @@ import (
 	"fmt"
 )
 
+const (
+	MAX_IDS_PER_QUERY = 500
+)
+
 type DatabaseHandle interface {
 	Query(query string, args ...interface{}) (*sql.Rows, error)
 	QueryRow(query string, args ...interface{}) *sql.Row
@@ -110,3 +114,21 @@ func Delete(model Model, id int64, execer SQLExecer) error {
 
 	return nil
 }
+
+// Calls query for chunks of the ids, to stay below the SQLite limit of 999
+// query parameters when the ids are used in an IN condition.
+func queryInChunks(ids []int64, query func(chunk []int64) error) error {
+	for start := 0; start < len(ids); start += MAX_IDS_PER_QUERY {
+		end := start + MAX_IDS_PER_QUERY
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		err := query(ids[start:end])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package models_test
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"hiking_trails/src/migrations"
+	"hiking_trails/src/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Database tests run against SQLite, and against PostgreSQL when the
+// HIKING_TRAILS_TEST_POSTGRES environment variable holds the connection string
+// of a database with PostGIS. The PostgreSQL database is cleared by reverting
+// all migrations.
+const (
+	TEST_POSTGRES_ENVIRONMENT_VARIABLE = "HIKING_TRAILS_TEST_POSTGRES"
+)
+
+// Runs test with a migrated, empty database of each dialect.
+func forEachDatabase(t *testing.T, test func(t *testing.T, db *sql.DB)) {
+	t.Run("sqlite3", func(t *testing.T) {
+		db, cleanup := openSQLiteTestDatabase(t)
+		defer cleanup()
+
+		test(t, db)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		db, cleanup := openPostgresTestDatabase(t)
+		defer cleanup()
+
+		test(t, db)
+	})
+}
+
+func openSQLiteTestDatabase(t testing.TB) (*sql.DB, func()) {
+	directory, err := ioutil.TempDir("", "hiking_trails")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(directory, "hiking_trails.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(directory)
+	}
+
+	_, err = db.Exec("PRAGMA foreign_keys = ON; CREATE VIRTUAL TABLE fts5_check USING fts5(text); DROP TABLE fts5_check;")
+	if err != nil {
+		cleanup()
+		t.Fatalf("SQLite is built without FTS5, run the tests with -tags sqlite_fts5: %s", err)
+	}
+
+	_, err = migrations.Up(db)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return db, cleanup
+}
+
+func openPostgresTestDatabase(t testing.TB) (*sql.DB, func()) {
+	source := os.Getenv(TEST_POSTGRES_ENVIRONMENT_VARIABLE)
+	if source == "" {
+		t.Skipf("Set %s to test with PostgreSQL", TEST_POSTGRES_ENVIRONMENT_VARIABLE)
+	}
+
+	db, err := sql.Open(models.POSTGRES_DRIVER, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dialect := models.Dialect
+	models.Dialect = models.PostgresDialect{}
+
+	cleanup := func() {
+		err := revertAllMigrations(db)
+		if err != nil {
+			t.Error(err)
+		}
+
+		models.Dialect = dialect
+		db.Close()
+	}
+
+	err = revertAllMigrations(db)
+	if err == nil {
+		_, err = migrations.Up(db)
+	}
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return db, cleanup
+}
+
+func revertAllMigrations(db *sql.DB) error {
+	for {
+		migration, err := migrations.Down(db)
+		if err != nil || migration == nil {
+			return err
+		}
+	}
+}
+
+// Bundle with a path from (62, 24) to (63, 25) in Finland, and a place at its
+// end, or with the coordinates given.
+func saveTestBundle(t testing.TB, db *sql.DB, name string, info string, coordinates ...models.GEOCoordinate) *models.Bundle {
+	if len(coordinates) == 0 {
+		coordinates = []models.GEOCoordinate{models.NewGEOCoordinate(62, 24), models.NewGEOCoordinate(63, 25)}
+	}
+
+	place := models.NewPlace()
+	place.Name = name + " campfire site"
+	place.Info = "Firewood available"
+	place.Position = coordinates[len(coordinates)-1]
+
+	path := models.NewPath()
+	path.Name = name + " shore trail"
+	path.Info = "Along the waterfront"
+	path.Polyline = models.GEOCoordinates(coordinates)
+	path.Places = append(path.Places, place)
+
+	bundle := models.NewBundle()
+	bundle.Name = name
+	bundle.Info = info
+	bundle.Paths = append(bundle.Paths, path)
+
+	err := models.Save(bundle, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bundle
+}
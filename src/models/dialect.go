@@ -0,0 +1,105 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The models are written with ? placeholders and SQL common to the supported
+// databases. The remaining differences are handled by the dialect of the
+// database in use.
+type SQLDialect interface {
+	// Name used to select the schema migrations of the database.
+	Name() string
+
+	// Runs an INSERT statement and returns the id of the inserted row.
+	insert(execer SQLExecer, statement string, arguments ...interface{}) (int64, error)
+
+	// Condition selecting the ids of rows in table whose geometry intersects
+	// the bounding box.
+	boundingBoxCondition(table string, box GEOBoundingBox) (string, []interface{})
+
+	// Column, placeholder and value storing the coordinates as a native
+	// geometry, or an empty column if the database has no geometry types.
+	geometry(coordinates GEOCoordinates) (string, string, interface{})
+
+	// Value for LIMIT that does not limit the number of rows.
+	noLimit() interface{}
+
+	// Converts search words to the query argument of searchStatement.
+	searchQuery(words []string) string
+
+	// Statement and arguments selecting at most limit search hits for the
+	// query of searchQuery, with the columns scanned by Search.
+	searchStatement(query string, limit int) (string, []interface{})
+}
+
+var Dialect SQLDialect = SQLiteDialect{}
+
+// SQLite, where geometries are indexed by the R*Tree tables paths_rtree and
+// places_rtree and search uses the FTS5 table search_index.
+type SQLiteDialect struct{}
+
+func (dialect SQLiteDialect) Name() string {
+	return "sqlite3"
+}
+
+func (dialect SQLiteDialect) insert(execer SQLExecer, statement string, arguments ...interface{}) (int64, error) {
+	result, err := execer.Exec(statement, arguments...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (dialect SQLiteDialect) boundingBoxCondition(table string, box GEOBoundingBox) (string, []interface{}) {
+	condition := fmt.Sprintf("id IN (SELECT id FROM %s_rtree WHERE max_latitude>=? AND min_latitude<=? AND max_longitude>=? AND min_longitude<=?)", table)
+	arguments := []interface{}{box.MinLatitude, box.MaxLatitude, box.MinLongitude, box.MaxLongitude}
+
+	return condition, arguments
+}
+
+func (dialect SQLiteDialect) geometry(coordinates GEOCoordinates) (string, string, interface{}) {
+	return "", "", nil
+}
+
+func (dialect SQLiteDialect) noLimit() interface{} {
+	return -1
+}
+
+// Matches items that contain all words, where the last word may be a prefix so
+// that search works while typing.
+func (dialect SQLiteDialect) searchQuery(words []string) string {
+	terms := make([]string, 0, len(words))
+	for i, word := range words {
+		term := `"` + word + `"`
+		if i == len(words)-1 {
+			term += "*"
+		}
+
+		terms = append(terms, term)
+	}
+
+	return strings.Join(terms, " ")
+}
+
+func (dialect SQLiteDialect) searchStatement(query string, limit int) (string, []interface{}) {
+	statement := fmt.Sprintf(`
+	SELECT search_index.kind, search_index.item_id, search_index.name,
+	       snippet(search_index, -1, '%s', '%s', '…', %d),
+	       -bm25(search_index, 0, 0, %f, %f) AS score,
+	       COALESCE(places.path_id, 0),
+	       COALESCE(paths.bundle_id, place_paths.bundle_id, 0),
+	       places.latitude, places.longitude,
+	       paths.min_latitude, paths.min_longitude, paths.max_latitude, paths.max_longitude
+	FROM search_index
+	LEFT JOIN places ON search_index.kind='place' AND places.id=search_index.item_id
+	LEFT JOIN paths AS place_paths ON place_paths.id=places.path_id
+	LEFT JOIN paths ON search_index.kind='path' AND paths.id=search_index.item_id
+	WHERE search_index MATCH ?
+	ORDER BY score DESC
+	LIMIT ?`, SEARCH_MATCH_START, SEARCH_MATCH_END, SNIPPET_TOKENS, SEARCH_NAME_WEIGHT, SEARCH_INFO_WEIGHT)
+
+	return statement, []interface{}{query, limit}
+}
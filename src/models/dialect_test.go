@@ -0,0 +1,120 @@
+package models_test
+
+import (
+	"database/sql"
+	"hiking_trails/src/models"
+	"strings"
+	"testing"
+)
+
+func TestSavesRowsWithIds(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		first := saveTestBundle(t, db, "Lakes", "")
+		second := saveTestBundle(t, db, "Fells", "")
+
+		ids := []int64{first.Id, second.Id, first.Paths[0].Id, second.Paths[0].Id,
+			first.Paths[0].Places[0].Id, second.Paths[0].Places[0].Id}
+		for i := 0; i < len(ids); i += 2 {
+			if ids[i] <= 0 || ids[i+1] <= ids[i] {
+				t.Errorf("Saved rows got ids %d and %d", ids[i], ids[i+1])
+			}
+		}
+
+		loaded := &models.Place{Id: second.Paths[0].Places[0].Id}
+		err := models.Load(loaded, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if loaded.Name != "Fells campfire site" || loaded.PathId != second.Paths[0].Id {
+			t.Errorf("Loaded place %+v", loaded)
+		}
+	})
+}
+
+func TestListsRowsInBoundingBox(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		finland := saveTestBundle(t, db, "Lakes", "")
+		saveTestBundle(t, db, "Alps", "", models.NewGEOCoordinate(46, 7), models.NewGEOCoordinate(46.5, 7.5))
+
+		// Crosses the path between its points, but does not contain any of them.
+		area, err := models.NewGEOAreaFromBoundingBox(models.GEOBoundingBox{
+			MinLatitude: 62.4, MinLongitude: 24.4, MaxLatitude: 62.6, MaxLongitude: 24.6})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		paths, total, err := models.ListPaths(db, &models.PathFilter{Area: area}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if total != 1 || len(paths) != 1 || paths[0].Id != finland.Paths[0].Id {
+			t.Errorf("Listed %d of %d paths in bounding box", len(paths), total)
+		}
+
+		places, _, err := models.ListPlaces(db, &models.PlaceFilter{Area: area}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(places) != 0 {
+			t.Errorf("Listed %d places outside of bounding box", len(places))
+		}
+
+		area.BoundingBox.MaxLatitude = 63.5
+		area.BoundingBox.MaxLongitude = 25.5
+
+		places, total, err = models.ListPlaces(db, &models.PlaceFilter{Area: area}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if total != 1 || len(places) != 1 || places[0].Id != finland.Paths[0].Places[0].Id {
+			t.Errorf("Listed %d of %d places in bounding box", len(places), total)
+		}
+	})
+}
+
+func TestSearchesBundlesPathsAndPlaces(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		bundle := saveTestBundle(t, db, "Lakes", "Trails around the shore of lakes")
+		saveTestBundle(t, db, "Fells", "")
+		path := bundle.Paths[0]
+		place := path.Places[0]
+
+		hits, err := models.Search(db, "lakes shore", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Matches in names rank above matches in descriptions.
+		if len(hits) != 2 || hits[0].Kind != "path" || hits[1].Kind != "bundle" || hits[1].Id != bundle.Id {
+			t.Fatalf("Search returned %v", hits)
+		}
+
+		if hits[0].Id != path.Id || hits[0].BundleId != bundle.Id || hits[0].BoundingBox == nil ||
+			hits[0].BoundingBox.MaxLatitude != 63 {
+			t.Errorf("Path hit %+v", hits[0])
+		}
+
+		if !strings.Contains(hits[1].Snippet, "<mark>lakes</mark>") {
+			t.Errorf("Bundle hit has snippet '%s'", hits[1].Snippet)
+		}
+
+		// The last word matches as a prefix.
+		hits, err = models.Search(db, "lakes camp", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(hits) != 1 || hits[0].Kind != "place" || hits[0].Id != place.Id {
+			t.Fatalf("Search returned %v", hits)
+		}
+
+		if hits[0].PathId != path.Id || hits[0].BundleId != bundle.Id || hits[0].Position == nil ||
+			hits[0].Position.Latitude != 63 {
+			t.Errorf("Place hit %+v", hits[0])
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package models
+
+// Elevation profile of a polyline. Only coordinates with an altitude are
+// sampled, but distance is measured along the whole polyline.
+
+type ElevationSample struct {
+	// Distance in meters from the start of the polyline.
+	Distance float64 `json:"distance"`
+
+	// Elevation in meters above sea level.
+	Elevation float64 `json:"elevation"`
+}
+
+type ElevationProfile struct {
+	Samples []ElevationSample `json:"samples"`
+	Ascent  float64           `json:"ascent"`
+	Descent float64           `json:"descent"`
+	Min     float64           `json:"min"`
+	Max     float64           `json:"max"`
+}
+
+func (coordinates GEOCoordinates) ElevationProfile() *ElevationProfile {
+	profile := &ElevationProfile{Samples: make([]ElevationSample, 0)}
+	distance := 0.0
+
+	for i, coordinate := range coordinates {
+		if i > 0 {
+			distance += coordinates[i-1].DistanceTo(coordinate)
+		}
+
+		if !coordinate.HasAltitude() {
+			continue
+		}
+
+		elevation := float32AsFloat64(*coordinate.Altitude)
+
+		if len(profile.Samples) == 0 {
+			profile.Min, profile.Max = elevation, elevation
+		} else {
+			delta := elevation - profile.Samples[len(profile.Samples)-1].Elevation
+			if delta > 0 {
+				profile.Ascent += delta
+			} else {
+				profile.Descent -= delta
+			}
+
+			if elevation < profile.Min {
+				profile.Min = elevation
+			}
+			if elevation > profile.Max {
+				profile.Max = elevation
+			}
+		}
+
+		profile.Samples = append(profile.Samples, ElevationSample{distance, elevation})
+	}
+
+	return profile
+}
@@ -0,0 +1,247 @@
+package models
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type ElevationProvider interface {
+	// Returns the elevation in meters above sea level at the coordinate, or
+	// false if the elevation is unknown there.
+	Elevation(latitude float64, longitude float64) (float64, bool, error)
+}
+
+// Provider used to fill in missing altitudes when paths and places are saved.
+// Nil disables elevation lookups.
+var Elevations ElevationProvider
+
+// Looks up the altitude of the coordinate if it is missing. Returns true if
+// the altitude was filled in.
+func (coordinate *GEOCoordinate) FillMissingAltitude() (bool, error) {
+	if Elevations == nil || coordinate.HasAltitude() {
+		return false, nil
+	}
+
+	elevation, found, err := Elevations.Elevation(float64(coordinate.Latitude), float64(coordinate.Longitude))
+	if err != nil || !found {
+		return false, err
+	}
+
+	altitude := float32(elevation)
+	coordinate.Altitude = &altitude
+	return true, nil
+}
+
+// Returns the number of altitudes that were filled in.
+func (coordinates GEOCoordinates) FillMissingAltitudes() (int, error) {
+	filled := 0
+
+	for i := range coordinates {
+		wasFilled, err := coordinates[i].FillMissingAltitude()
+		if err != nil {
+			return filled, err
+		}
+
+		if wasFilled {
+			filled++
+		}
+	}
+
+	return filled, nil
+}
+
+const (
+	HGT_VOID = -32768
+)
+
+// Reads elevations from SRTM .hgt tiles in a local directory, so no network
+// access is needed. A tile covers one degree of latitude and longitude and is
+// named after its south west corner, e.g. N63E020.hgt. Both SRTM1(3601x3601)
+// and SRTM3(1201x1201) tiles are supported. Tiles are loaded on first use and
+// the most recently used ones are kept in memory.
+type HGTElevationProvider struct {
+	directory      string
+	maxCachedTiles int
+
+	lock  *sync.Mutex
+	tiles map[string]*list.Element
+	usage *list.List
+}
+
+type hgtTile struct {
+	name string
+
+	// Big endian int16 samples ordered row by row from north to south. Nil if
+	// there is no tile file, which is cached to avoid looking for it again.
+	samples []byte
+	size    int
+}
+
+func NewHGTElevationProvider(directory string, maxCachedTiles int) (*HGTElevationProvider, error) {
+	info, err := os.Stat(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", directory)
+	}
+
+	if maxCachedTiles < 1 {
+		maxCachedTiles = 1
+	}
+
+	provider := &HGTElevationProvider{
+		directory:      directory,
+		maxCachedTiles: maxCachedTiles,
+		lock:           &sync.Mutex{},
+		tiles:          make(map[string]*list.Element),
+		usage:          list.New(),
+	}
+
+	return provider, nil
+}
+
+func (provider *HGTElevationProvider) Elevation(latitude float64, longitude float64) (float64, bool, error) {
+	tileLatitude := math.Floor(latitude)
+	tileLongitude := math.Floor(longitude)
+
+	tile, err := provider.tile(hgtTileName(int(tileLatitude), int(tileLongitude)))
+	if err != nil || tile.samples == nil {
+		return 0, false, err
+	}
+
+	// Row 0 is the northern edge of the tile.
+	y := (tileLatitude + 1 - latitude) * float64(tile.size-1)
+	x := (longitude - tileLongitude) * float64(tile.size-1)
+
+	elevation, found := tile.interpolate(x, y)
+	return elevation, found, nil
+}
+
+func hgtTileName(latitude int, longitude int) string {
+	latitudeHemisphere, longitudeHemisphere := 'N', 'E'
+
+	if latitude < 0 {
+		latitudeHemisphere, latitude = 'S', -latitude
+	}
+	if longitude < 0 {
+		longitudeHemisphere, longitude = 'W', -longitude
+	}
+
+	return fmt.Sprintf("%c%02d%c%03d.hgt", latitudeHemisphere, latitude, longitudeHemisphere, longitude)
+}
+
+// Tiles are loaded without holding the lock, so that lookups in cached tiles
+// do not wait for the file. When the same tile is loaded at the same time, the
+// tile cached first is used.
+func (provider *HGTElevationProvider) tile(name string) (*hgtTile, error) {
+	tile := provider.cachedTile(name)
+	if tile != nil {
+		return tile, nil
+	}
+
+	tile, err := loadHGTTile(filepath.Join(provider.directory, name))
+	if err != nil {
+		return nil, err
+	}
+
+	tile.name = name
+
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+
+	element, exist := provider.tiles[name]
+	if exist {
+		provider.usage.MoveToFront(element)
+		return element.Value.(*hgtTile), nil
+	}
+
+	provider.tiles[name] = provider.usage.PushFront(tile)
+
+	for provider.usage.Len() > provider.maxCachedTiles {
+		oldest := provider.usage.Back()
+		provider.usage.Remove(oldest)
+		delete(provider.tiles, oldest.Value.(*hgtTile).name)
+	}
+
+	return tile, nil
+}
+
+// Returns nil if the tile is not cached.
+func (provider *HGTElevationProvider) cachedTile(name string) *hgtTile {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+
+	element, exist := provider.tiles[name]
+	if !exist {
+		return nil
+	}
+
+	provider.usage.MoveToFront(element)
+	return element.Value.(*hgtTile)
+}
+
+func loadHGTTile(filename string) (*hgtTile, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return &hgtTile{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	size := int(math.Sqrt(float64(len(data) / 2)))
+	if size < 2 || size*size*2 != len(data) {
+		return nil, fmt.Errorf("%s is not a valid HGT tile", filename)
+	}
+
+	return &hgtTile{samples: data, size: size}, nil
+}
+
+func (tile *hgtTile) sample(column int, row int) (float64, bool) {
+	offset := (row*tile.size + column) * 2
+	value := int16(binary.BigEndian.Uint16(tile.samples[offset : offset+2]))
+
+	return float64(value), value != HGT_VOID
+}
+
+// Bilinear interpolation between the four samples surrounding x, y. Void
+// samples are left out and the remaining weights are normalized.
+func (tile *hgtTile) interpolate(x float64, y float64) (float64, bool) {
+	column := int(math.Min(math.Floor(x), float64(tile.size-2)))
+	row := int(math.Min(math.Floor(y), float64(tile.size-2)))
+	dx := x - float64(column)
+	dy := y - float64(row)
+
+	corners := []struct {
+		column int
+		row    int
+		weight float64
+	}{
+		{column, row, (1 - dx) * (1 - dy)},
+		{column + 1, row, dx * (1 - dy)},
+		{column, row + 1, (1 - dx) * dy},
+		{column + 1, row + 1, dx * dy},
+	}
+
+	elevation, totalWeight := 0.0, 0.0
+	for _, corner := range corners {
+		value, valid := tile.sample(corner.column, corner.row)
+		if valid {
+			elevation += value * corner.weight
+			totalWeight += corner.weight
+		}
+	}
+
+	if totalWeight == 0 {
+		return 0, false
+	}
+
+	return elevation / totalWeight, true
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Samples of a 3x3 tile, row by row from north to south.
+var TEST_HGT_SAMPLES = []int16{
+	100, 200, 300,
+	400, 500, 600,
+	700, 800, HGT_VOID,
+}
+
+func hgtTileData(samples []int16) []byte {
+	buffer := &bytes.Buffer{}
+	binary.Write(buffer, binary.BigEndian, samples)
+	return buffer.Bytes()
+}
+
+func newTestHGTTile(samples []int16) *hgtTile {
+	return &hgtTile{samples: hgtTileData(samples), size: int(math.Sqrt(float64(len(samples))))}
+}
+
+func TestInterpolatesElevation(t *testing.T) {
+	tile := newTestHGTTile(TEST_HGT_SAMPLES)
+
+	tests := []struct {
+		x, y      float64
+		elevation float64
+		found     bool
+	}{
+		{0, 0, 100, true},
+		{1, 1, 500, true},
+		{0.5, 0.5, 300, true},
+		{0.25, 0, 125, true},
+
+		// The last row and column are interpolated in the cells before them.
+		{2, 0, 300, true},
+		{0, 2, 700, true},
+		{1.5, 0, 250, true},
+		{2, 0.5, 450, true},
+
+		// Void samples are left out and the other weights normalized.
+		{1.5, 1.5, (500 + 600 + 800) / 3.0, true},
+		{2, 1.5, 600, true},
+		{2, 2, 0, false},
+	}
+
+	for _, test := range tests {
+		elevation, found := tile.interpolate(test.x, test.y)
+		if found != test.found || math.Abs(elevation-test.elevation) > 1e-9 {
+			t.Errorf("Interpolated %f, %t at %f, %f, expected %f, %t",
+				elevation, found, test.x, test.y, test.elevation, test.found)
+		}
+	}
+}
+
+func TestInterpolatesOnlyVoidSamples(t *testing.T) {
+	tile := newTestHGTTile([]int16{HGT_VOID, HGT_VOID, HGT_VOID, HGT_VOID})
+
+	_, found := tile.interpolate(0.5, 0.5)
+	if found {
+		t.Error("Found elevation between void samples")
+	}
+}
+
+func TestLooksUpElevationsInTiles(t *testing.T) {
+	directory, err := ioutil.TempDir("", "hiking_trails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	for _, name := range []string{"N63E020.hgt", "S01W001.hgt"} {
+		err = ioutil.WriteFile(filepath.Join(directory, name), hgtTileData(TEST_HGT_SAMPLES), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	provider, err := NewHGTElevationProvider(directory, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		latitude, longitude float64
+		elevation           float64
+		found               bool
+	}{
+		{63.5, 20.5, 500, true},
+
+		// South west and north east corners of the tile.
+		{63, 20, 700, true},
+		{63.999999, 20.999999, 300, true},
+
+		{-0.5, -0.5, 500, true},
+		{-1, -1, 700, true},
+
+		// No tile file.
+		{62.5, 20.5, 0, false},
+	}
+
+	for _, test := range tests {
+		elevation, found, err := provider.Elevation(test.latitude, test.longitude)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found != test.found || math.Abs(elevation-test.elevation) > 0.01 {
+			t.Errorf("Elevation %f, %t at %f, %f, expected %f, %t",
+				elevation, found, test.latitude, test.longitude, test.elevation, test.found)
+		}
+	}
+
+	if provider.usage.Len() != 1 || len(provider.tiles) != 1 {
+		t.Errorf("Cached %d tiles, expected 1", provider.usage.Len())
+	}
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+// Encoded polyline algorithm format used by the Google Maps API
+// (https://developers.google.com/maps/documentation/utilities/polylinealgorithm).
+// Coordinates are rounded to five decimals and altitudes are not encoded.
+
+const (
+	ENCODED_POLYLINE_PRECISION = 1e5
+)
+
+func EncodePolyline(coordinates GEOCoordinates) string {
+	var buffer bytes.Buffer
+	previousLatitude, previousLongitude := int64(0), int64(0)
+
+	for _, coordinate := range coordinates {
+		latitude := roundToEncodedPolylinePrecision(coordinate.Latitude)
+		longitude := roundToEncodedPolylinePrecision(coordinate.Longitude)
+
+		writeEncodedPolylineValue(&buffer, latitude-previousLatitude)
+		writeEncodedPolylineValue(&buffer, longitude-previousLongitude)
+
+		previousLatitude, previousLongitude = latitude, longitude
+	}
+
+	return buffer.String()
+}
+
+func roundToEncodedPolylinePrecision(value float32) int64 {
+	return int64(math.Floor(float32AsFloat64(value)*ENCODED_POLYLINE_PRECISION + 0.5))
+}
+
+func writeEncodedPolylineValue(buffer *bytes.Buffer, value int64) {
+	shifted := value << 1
+	if value < 0 {
+		shifted = ^shifted
+	}
+
+	for shifted >= 0x20 {
+		buffer.WriteByte(byte((0x20 | (shifted & 0x1f)) + 63))
+		shifted >>= 5
+	}
+
+	buffer.WriteByte(byte(shifted + 63))
+}
+
+func DecodePolyline(encoded string) (GEOCoordinates, error) {
+	coordinates := NewGEOCoordinates()
+	latitude, longitude := int64(0), int64(0)
+
+	for position := 0; position < len(encoded); {
+		deltaLatitude, next, err := readEncodedPolylineValue(encoded, position)
+		if err != nil {
+			return nil, err
+		}
+
+		deltaLongitude, next, err := readEncodedPolylineValue(encoded, next)
+		if err != nil {
+			return nil, err
+		}
+
+		latitude += deltaLatitude
+		longitude += deltaLongitude
+		position = next
+
+		coordinates = append(coordinates, NewGEOCoordinate(
+			float32(float64(latitude)/ENCODED_POLYLINE_PRECISION),
+			float32(float64(longitude)/ENCODED_POLYLINE_PRECISION)))
+	}
+
+	return coordinates, nil
+}
+
+// Returns the value starting at position and the position after it.
+func readEncodedPolylineValue(encoded string, position int) (int64, int, error) {
+	result, shift := int64(0), uint(0)
+
+	for {
+		if position >= len(encoded) {
+			return 0, 0, fmt.Errorf("Encoded polyline ends in the middle of a value")
+		}
+
+		chunk := int64(encoded[position]) - 63
+		position++
+
+		if chunk < 0 || chunk > 0x3f || shift > 60 {
+			return 0, 0, fmt.Errorf("Invalid character in encoded polyline at position %d", position-1)
+		}
+
+		result |= (chunk & 0x1f) << shift
+		shift += 5
+
+		if chunk < 0x20 {
+			break
+		}
+	}
+
+	if result&1 != 0 {
+		return ^(result >> 1), position, nil
+	}
+
+	return result >> 1, position, nil
+}
+
+func EncodePolylines(paths []*Path) {
+	for _, path := range paths {
+		path.EncodedPolyline = EncodePolyline(path.Polyline)
+	}
+}
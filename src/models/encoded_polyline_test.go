@@ -0,0 +1,120 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+var encodedPolylineTests = []struct {
+	name        string
+	coordinates GEOCoordinates
+	encoded     string
+}{
+	{"empty", GEOCoordinates{}, ""},
+	// Example of the algorithm description.
+	{"reference", GEOCoordinates{NewGEOCoordinate(38.5, -120.2), NewGEOCoordinate(40.7, -120.95),
+		NewGEOCoordinate(43.252, -126.453)}, "_p~iF~ps|U_ulLnnqC_mqNvxq`@"},
+	{"negative", GEOCoordinates{NewGEOCoordinate(-33.86882, 151.20929), NewGEOCoordinate(-33.8688, 151.2093)},
+		"b_vmEaa|y[CA"},
+	{"smallest steps", GEOCoordinates{NewGEOCoordinate(-0.00001, -0.00002), NewGEOCoordinate(0.00001, -0.00001)}, "@BCA"},
+	{"altitude", GEOCoordinates{NewGEOCoordinateWithAltitude(38.5, -120.2, 1500)}, "_p~iF~ps|U"},
+}
+
+func TestEncodesPolyline(t *testing.T) {
+	for _, test := range encodedPolylineTests {
+		if encoded := EncodePolyline(test.coordinates); encoded != test.encoded {
+			t.Errorf("Encoded %s polyline as '%s', expected '%s'", test.name, encoded, test.encoded)
+		}
+	}
+}
+
+func TestDecodesPolyline(t *testing.T) {
+	for _, test := range encodedPolylineTests {
+		decoded, err := DecodePolyline(test.encoded)
+		if err != nil {
+			t.Errorf("Failed to decode %s polyline: %s", test.name, err)
+			continue
+		}
+
+		if !coordinatesEqualWithoutAltitude(decoded, test.coordinates) {
+			t.Errorf("Decoded %s polyline as %v, expected %v", test.name, decoded, test.coordinates)
+		}
+	}
+}
+
+// Values are rounded to the nearest 1e-5 degrees, halves upwards.
+func TestRoundsEncodedPolyline(t *testing.T) {
+	tests := []struct {
+		latitude float32
+		encoded  string
+	}{
+		{0.000004, "??"},
+		{0.000005, "A?"},
+		{-0.000005, "??"},
+		{-0.000006, "@?"},
+		{62.123456, "sndzJ?"},
+	}
+
+	for _, test := range tests {
+		coordinates := GEOCoordinates{NewGEOCoordinate(test.latitude, 0)}
+		if encoded := EncodePolyline(coordinates); encoded != test.encoded {
+			t.Errorf("Encoded latitude %v as '%s', expected '%s'", test.latitude, encoded, test.encoded)
+		}
+	}
+}
+
+func TestRejectsInvalidEncodedPolyline(t *testing.T) {
+	for _, encoded := range []string{"_p~iF", "_p~iF~ps|", "_p~iF ps|U", "\x7f?"} {
+		_, err := DecodePolyline(encoded)
+		if err == nil {
+			t.Errorf("Decoded invalid polyline '%s'", encoded)
+		}
+	}
+}
+
+func TestPathWithEncodedPolyline(t *testing.T) {
+	path := &Path{}
+
+	err := json.Unmarshal([]byte(`{"name": "Reference", "encodedPolyline": "_p~iF~ps|U_ulLnnqC_mqNvxq`+"`"+`@"}`), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path.EncodedPolyline != "" || !coordinatesEqualWithoutAltitude(path.Polyline, encodedPolylineTests[1].coordinates) {
+		t.Errorf("Decoded path with polyline %v and encoded polyline '%s'", path.Polyline, path.EncodedPolyline)
+	}
+
+	EncodePolylines([]*Path{path})
+
+	data, err := json.Marshal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), `"polyline"`) || !strings.Contains(string(data), `"encodedPolyline":"_p~iF~ps|U_ulLnnqC_mqNvxq`) {
+		t.Errorf("Encoded path as %s", data)
+	}
+
+	err = json.Unmarshal([]byte(`{"name": "Both", "polyline": [{"lat": 1, "lng": 2}], "encodedPolyline": "??"}`), &Path{})
+	if err == nil {
+		t.Errorf("Decoded path with both polyline and encoded polyline")
+	}
+}
+
+func coordinatesEqualWithoutAltitude(coordinates GEOCoordinates, expected GEOCoordinates) bool {
+	if len(coordinates) != len(expected) {
+		return false
+	}
+
+	for i := range coordinates {
+		if math.Abs(float64(coordinates[i].Latitude-expected[i].Latitude)) > 1e-6 ||
+			math.Abs(float64(coordinates[i].Longitude-expected[i].Longitude)) > 1e-6 ||
+			coordinates[i].Altitude != nil {
+			return false
+		}
+	}
+
+	return true
+}
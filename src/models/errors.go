@@ -5,6 +5,7 @@ import (
 	"github.com/martini-contrib/binding"
 	"github.com/martini-contrib/render"
 	"log"
+	"strings"
 )
 
 type APIError struct {
@@ -61,3 +62,11 @@ func NewBindingRangeError(field string, min int, max int) binding.Error {
 		Message:        fmt.Sprintf("Name should be between %d and %d characters", min, max),
 	}
 }
+
+func NewBindingValueError(field string, allowed []string) binding.Error {
+	return binding.Error{
+		FieldNames:     []string{field},
+		Classification: "ComplaintError",
+		Message:        fmt.Sprintf("Value must be one of %s", strings.Join(allowed, ", ")),
+	}
+}
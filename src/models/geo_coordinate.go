@@ -4,33 +4,59 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
+)
+
+// Coordinates are stored as little endian float32 values. The legacy format is
+// latitude and longitude(8 bytes) per coordinate without any header. Versioned
+// formats start with a 4 byte header, which read as a legacy latitude would be
+// NaN and can therefore never be mistaken for a legacy coordinate.
+//
+// Version 2: header followed by latitude, longitude and altitude(12 bytes) per
+// coordinate. A missing altitude is stored as NaN.
+
+const (
+	GEO_COORDINATES_VERSION     = 2
+	GEO_COORDINATES_HEADER_SIZE = 4
+	LEGACY_GEO_COORDINATE_SIZE  = 4 * 2
+	GEO_COORDINATE_SIZE         = 4 * 3
 )
 
 type GEOCoordinate struct {
 	Latitude  float32 `json:"lat"`
 	Longitude float32 `json:"lng"`
+
+	// Altitude in meters above sea level, nil when unknown.
+	Altitude *float32 `json:"alt,omitempty"`
+}
+
+func NewGEOCoordinate(latitude float32, longitude float32) GEOCoordinate {
+	return GEOCoordinate{Latitude: latitude, Longitude: longitude}
+}
+
+func NewGEOCoordinateWithAltitude(latitude float32, longitude float32, altitude float32) GEOCoordinate {
+	return GEOCoordinate{latitude, longitude, &altitude}
+}
+
+func (coordinate GEOCoordinate) HasAltitude() bool {
+	return coordinate.Altitude != nil
 }
 
 func GEOCoordinateFromBytes(data []byte) (*GEOCoordinate, error) {
-	coordinate := &GEOCoordinate{}
-	buffer := bytes.NewReader(data)
-	err := binary.Read(buffer, binary.LittleEndian, coordinate)
+	coordinates, err := GEOCoordinatesFromBytes(data)
 	if err != nil {
 		return nil, err
 	}
 
-	return coordinate, nil
+	if len(coordinates) != 1 {
+		return nil, fmt.Errorf("Expected 1 GEOCoordinate, got %d", len(coordinates))
+	}
+
+	return &coordinates[0], nil
 }
 
 func (coordinate GEOCoordinate) AsBytes() []byte {
-	buffer := bytes.NewBuffer(make([]byte, 0, 4*2))
-
-	err := binary.Write(buffer, binary.LittleEndian, coordinate)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to convert GEOCoordinate to bytes: %s", err))
-	}
-
-	return buffer.Bytes()
+	return GEOCoordinates{coordinate}.AsBytes()
 }
 
 type GEOCoordinates []GEOCoordinate
@@ -40,25 +66,112 @@ func NewGEOCoordinates() []GEOCoordinate {
 }
 
 func GEOCoordinatesFromBytes(data []byte) (GEOCoordinates, error) {
-	coordinates := make([]GEOCoordinate, len(data)/(4*2))
-	buffer := bytes.NewReader(data)
+	if !hasGEOCoordinatesHeader(data) {
+		return legacyGEOCoordinatesFromBytes(data)
+	}
 
-	err := binary.Read(buffer, binary.LittleEndian, &coordinates)
+	version := data[0]
+	if version != GEO_COORDINATES_VERSION {
+		return nil, fmt.Errorf("Unsupported GEOCoordinates format version %d", version)
+	}
+
+	data = data[GEO_COORDINATES_HEADER_SIZE:]
+	if len(data)%GEO_COORDINATE_SIZE != 0 {
+		return nil, fmt.Errorf("Invalid GEOCoordinates data length %d", len(data))
+	}
+
+	values := make([]float32, len(data)/4)
+	err := binary.Read(bytes.NewReader(data), binary.LittleEndian, values)
 	if err != nil {
 		return nil, err
 	}
 
+	coordinates := make([]GEOCoordinate, 0, len(values)/3)
+	for i := 0; i < len(values); i += 3 {
+		coordinate := NewGEOCoordinate(values[i], values[i+1])
+		if !math.IsNaN(float64(values[i+2])) {
+			altitude := values[i+2]
+			coordinate.Altitude = &altitude
+		}
+
+		coordinates = append(coordinates, coordinate)
+	}
+
 	return coordinates, nil
 }
 
+func legacyGEOCoordinatesFromBytes(data []byte) (GEOCoordinates, error) {
+	if len(data)%LEGACY_GEO_COORDINATE_SIZE != 0 {
+		return nil, fmt.Errorf("Invalid legacy GEOCoordinates data length %d", len(data))
+	}
+
+	values := make([]float32, len(data)/4)
+	err := binary.Read(bytes.NewReader(data), binary.LittleEndian, values)
+	if err != nil {
+		return nil, err
+	}
+
+	coordinates := make([]GEOCoordinate, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		coordinates = append(coordinates, NewGEOCoordinate(values[i], values[i+1]))
+	}
+
+	return coordinates, nil
+}
+
+func hasGEOCoordinatesHeader(data []byte) bool {
+	return len(data) >= GEO_COORDINATES_HEADER_SIZE && data[1] == 'G' && data[2] == 0xFF && data[3] == 0xFF
+}
+
 func (coordinates GEOCoordinates) AsBytes() []byte {
-	buffer := bytes.NewBuffer(make([]byte, 0, len(coordinates)*4*2))
+	buffer := bytes.NewBuffer(make([]byte, 0, GEO_COORDINATES_HEADER_SIZE+len(coordinates)*GEO_COORDINATE_SIZE))
+	buffer.Write([]byte{GEO_COORDINATES_VERSION, 'G', 0xFF, 0xFF})
+
+	values := make([]float32, 0, len(coordinates)*3)
+	for _, coordinate := range coordinates {
+		altitude := float32(math.NaN())
+		if coordinate.HasAltitude() {
+			altitude = *coordinate.Altitude
+		}
 
-	err := binary.Write(buffer, binary.LittleEndian, coordinates)
+		values = append(values, coordinate.Latitude, coordinate.Longitude, altitude)
+	}
+
+	err := binary.Write(buffer, binary.LittleEndian, values)
 	if err != nil {
 		panic(fmt.Sprintf("Failed to convert []GEOCoordinate to bytes: %s", err))
 	}
 
 	return buffer.Bytes()
+}
+
+const (
+	EARTH_RADIUS_METERS = 6371008.8
+)
+
+// Great-circle distance in meters to other, using the haversine formula.
+func (coordinate GEOCoordinate) DistanceTo(other GEOCoordinate) float64 {
+	latitude1 := degreesToRadians(coordinate.Latitude)
+	latitude2 := degreesToRadians(other.Latitude)
+	deltaLatitude := latitude2 - latitude1
+	deltaLongitude := degreesToRadians(other.Longitude) - degreesToRadians(coordinate.Longitude)
+
+	a := math.Sin(deltaLatitude/2)*math.Sin(deltaLatitude/2) +
+		math.Cos(latitude1)*math.Cos(latitude2)*math.Sin(deltaLongitude/2)*math.Sin(deltaLongitude/2)
+
+	return 2 * EARTH_RADIUS_METERS * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Length in meters of the polyline through all coordinates.
+func (coordinates GEOCoordinates) Length() float64 {
+	length := 0.0
+	for i := 1; i < len(coordinates); i++ {
+		length += coordinates[i-1].DistanceTo(coordinates[i])
+	}
+
+	return length
+}
 
+func degreesToRadians(degrees float32) float64 {
+	return float64(degrees) * math.Pi / 180
 }
@@ -0,0 +1,342 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// GeoJSON(RFC 7946) representations of paths and places. Paths are
+// LineString features and places are Point features. The properties of a
+// feature use the same names as the regular JSON representation, plus a
+// 'kind' property telling if the feature is a path or a place.
+
+const (
+	GEOJSON_CONTENT_TYPE = "application/geo+json"
+)
+
+type GeoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Id         int64                  `json:"id,omitempty"`
+	Geometry   *GeoJSONGeometry       `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string            `json:"type"`
+	Features []*GeoJSONFeature `json:"features"`
+
+	// Foreign member used to describe the bundle when rendering a single bundle.
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// Used when decoding, since properties then must be kept raw to be decoded
+// into the model.
+type geoJSONObject struct {
+	Type       string           `json:"type"`
+	Id         int64            `json:"id"`
+	Geometry   *GeoJSONGeometry `json:"geometry"`
+	Properties json.RawMessage  `json:"properties"`
+	Features   []*geoJSONObject `json:"features"`
+}
+
+func NewGeoJSONFeatureCollection() *GeoJSONFeatureCollection {
+	return &GeoJSONFeatureCollection{"FeatureCollection", make([]*GeoJSONFeature, 0), nil}
+}
+
+func (collection *GeoJSONFeatureCollection) AddPath(path *Path) {
+	collection.Features = append(collection.Features, path.AsGeoJSONFeature())
+	collection.AddPlaces(path.Places)
+}
+
+func (collection *GeoJSONFeatureCollection) AddPlaces(places []*Place) {
+	for _, place := range places {
+		collection.Features = append(collection.Features, place.AsGeoJSONFeature())
+	}
+}
+
+func GeoJSONFromBundle(bundle *Bundle) *GeoJSONFeatureCollection {
+	collection := GeoJSONFromPaths(bundle.Paths)
+	collection.Properties = map[string]interface{}{
+		"id":    bundle.Id,
+		"name":  bundle.Name,
+		"info":  bundle.Info,
+		"image": bundle.ImageURL,
+	}
+
+	return collection
+}
+
+func GeoJSONFromBundles(bundles []*Bundle) *GeoJSONFeatureCollection {
+	collection := NewGeoJSONFeatureCollection()
+	for _, bundle := range bundles {
+		for _, path := range bundle.Paths {
+			collection.AddPath(path)
+		}
+	}
+
+	return collection
+}
+
+func GeoJSONFromPaths(paths []*Path) *GeoJSONFeatureCollection {
+	collection := NewGeoJSONFeatureCollection()
+	for _, path := range paths {
+		collection.AddPath(path)
+	}
+
+	return collection
+}
+
+func GeoJSONFromPlaces(places []*Place) *GeoJSONFeatureCollection {
+	collection := NewGeoJSONFeatureCollection()
+	collection.AddPlaces(places)
+
+	return collection
+}
+
+func (path *Path) AsGeoJSONFeature() *GeoJSONFeature {
+	positions := make([][]float64, 0, len(path.Polyline))
+	for _, coordinate := range path.Polyline {
+		positions = append(positions, coordinate.AsGeoJSONPosition())
+	}
+
+	return &GeoJSONFeature{
+		Type:     "Feature",
+		Id:       path.Id,
+		Geometry: newGeoJSONGeometry("LineString", positions),
+		Properties: map[string]interface{}{
+			"kind":            path.Type(),
+			"name":            path.Name,
+			"info":            path.Info,
+			"length":          path.Length,
+			"lengthMeters":    path.LengthMeters,
+			"duration":        path.Duration,
+			"durationSeconds": path.DurationSeconds,
+			"difficulty":      path.Difficulty,
+			"image":           path.ImageURL,
+			"bundleId":        path.BundleId,
+		},
+	}
+}
+
+func (place *Place) AsGeoJSONFeature() *GeoJSONFeature {
+	return &GeoJSONFeature{
+		Type:     "Feature",
+		Id:       place.Id,
+		Geometry: newGeoJSONGeometry("Point", place.Position.AsGeoJSONPosition()),
+		Properties: map[string]interface{}{
+			"kind":   place.Type(),
+			"name":   place.Name,
+			"info":   place.Info,
+			"radius": place.Radius,
+			"pathId": place.PathId,
+		},
+	}
+}
+
+func newGeoJSONGeometry(geometryType string, coordinates interface{}) *GeoJSONGeometry {
+	data, err := json.Marshal(coordinates)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to convert coordinates to GeoJSON: %s", err))
+	}
+
+	return &GeoJSONGeometry{geometryType, data}
+}
+
+// GeoJSON positions are ordered longitude, latitude and optionally altitude.
+func (coordinate GEOCoordinate) AsGeoJSONPosition() []float64 {
+	position := []float64{float32AsFloat64(coordinate.Longitude), float32AsFloat64(coordinate.Latitude)}
+	if coordinate.HasAltitude() {
+		position = append(position, float32AsFloat64(*coordinate.Altitude))
+	}
+
+	return position
+}
+
+func GEOCoordinateFromGeoJSONPosition(position []float64) (GEOCoordinate, error) {
+	if len(position) < 2 {
+		return GEOCoordinate{}, fmt.Errorf("GeoJSON position must have at least two elements")
+	}
+
+	if len(position) > 2 {
+		return NewGEOCoordinateWithAltitude(float32(position[1]), float32(position[0]), float32(position[2])), nil
+	}
+
+	return NewGEOCoordinate(float32(position[1]), float32(position[0])), nil
+}
+
+// Returns the GeoJSON object type of data, or an empty string if data is not
+// a GeoJSON object.
+func geoJSONType(data []byte) string {
+	object := struct {
+		Type string `json:"type"`
+	}{}
+
+	err := json.Unmarshal(data, &object)
+	if err != nil {
+		return ""
+	}
+
+	switch object.Type {
+	case "Feature", "FeatureCollection":
+		return object.Type
+	}
+
+	return ""
+}
+
+func decodeGeoJSONObject(data []byte) (*geoJSONObject, error) {
+	object := &geoJSONObject{}
+
+	err := json.Unmarshal(data, object)
+	if err != nil {
+		return nil, err
+	}
+
+	return object, nil
+}
+
+func (object *geoJSONObject) geometryType() string {
+	if object.Geometry == nil {
+		return ""
+	}
+
+	return object.Geometry.Type
+}
+
+func (object *geoJSONObject) decodeProperties(target interface{}) error {
+	if len(object.Properties) == 0 || string(object.Properties) == "null" {
+		return nil
+	}
+
+	return json.Unmarshal(object.Properties, target)
+}
+
+func (object *geoJSONObject) lineString() (GEOCoordinates, error) {
+	positions := make([][]float64, 0)
+
+	err := json.Unmarshal(object.Geometry.Coordinates, &positions)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid LineString coordinates: %s", err)
+	}
+
+	coordinates := NewGEOCoordinates()
+	for _, position := range positions {
+		coordinate, err := GEOCoordinateFromGeoJSONPosition(position)
+		if err != nil {
+			return nil, err
+		}
+
+		coordinates = append(coordinates, coordinate)
+	}
+
+	return coordinates, nil
+}
+
+func (object *geoJSONObject) point() (GEOCoordinate, error) {
+	position := make([]float64, 0, 2)
+
+	err := json.Unmarshal(object.Geometry.Coordinates, &position)
+	if err != nil {
+		return GEOCoordinate{}, fmt.Errorf("Invalid Point coordinates: %s", err)
+	}
+
+	return GEOCoordinateFromGeoJSONPosition(position)
+}
+
+// Decodes a path from either a LineString feature, or a feature collection
+// with one LineString feature and a Point feature for each place.
+func (path *Path) decodeGeoJSON(data []byte) error {
+	object, err := decodeGeoJSONObject(data)
+	if err != nil {
+		return err
+	}
+
+	if object.Type == "Feature" {
+		return path.decodeGeoJSONFeature(object)
+	}
+
+	places := make([]*Place, 0)
+	pathFound := false
+
+	for _, feature := range object.Features {
+		switch feature.geometryType() {
+		case "LineString":
+			if pathFound {
+				return fmt.Errorf("GeoJSON path must contain exactly one LineString feature")
+			}
+
+			err = path.decodeGeoJSONFeature(feature)
+			pathFound = true
+
+		case "Point":
+			place := NewPlace()
+			err = place.decodeGeoJSONFeature(feature)
+			places = append(places, place)
+
+		default:
+			err = fmt.Errorf("Unsupported GeoJSON geometry '%s'", feature.geometryType())
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !pathFound {
+		return fmt.Errorf("GeoJSON path must contain exactly one LineString feature")
+	}
+
+	path.Places = places
+	return nil
+}
+
+func (path *Path) decodeGeoJSONFeature(feature *geoJSONObject) error {
+	if feature.geometryType() != "LineString" {
+		return fmt.Errorf("GeoJSON path must have a LineString geometry")
+	}
+
+	err := feature.decodeProperties((*jsonPath)(path))
+	if err != nil {
+		return err
+	}
+
+	polyline, err := feature.lineString()
+	if err != nil {
+		return err
+	}
+
+	path.Polyline = polyline
+	if feature.Id != 0 {
+		path.Id = feature.Id
+	}
+
+	return nil
+}
+
+func (place *Place) decodeGeoJSONFeature(feature *geoJSONObject) error {
+	if feature.geometryType() != "Point" {
+		return fmt.Errorf("GeoJSON place must have a Point geometry")
+	}
+
+	err := feature.decodeProperties((*jsonPlace)(place))
+	if err != nil {
+		return err
+	}
+
+	position, err := feature.point()
+	if err != nil {
+		return err
+	}
+
+	place.Position = position
+	if feature.Id != 0 {
+		place.Id = feature.Id
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const TEST_GEOJSON_PATH = `{
+  "type": "Feature",
+  "id": 7,
+  "geometry": {"type": "LineString", "coordinates": [[21.05, 63.1, 12.5], [21.075, 63.15]]},
+  "properties": {"kind": "path", "name": "Lakes", "info": "Around the lakes", "difficulty": "easy", "bundleId": 2}
+}`
+
+const TEST_GEOJSON_PLACE = `{
+  "type": "Feature",
+  "id": 3,
+  "geometry": {"type": "Point", "coordinates": [21.1, 63.2, 42]},
+  "properties": {"kind": "place", "name": "Shelter", "info": "Open all year", "radius": 50}
+}`
+
+func decodeGeoJSONPath(t *testing.T, data []byte) *Path {
+	path := NewPath()
+
+	err := json.Unmarshal(data, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func decodeGeoJSONPlace(t *testing.T, data []byte) *Place {
+	place := NewPlace()
+
+	err := json.Unmarshal(data, place)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return place
+}
+
+func encodeGeoJSON(t *testing.T, value interface{}) []byte {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestGeoJSONPathFeatureRoundTrip(t *testing.T) {
+	path := decodeGeoJSONPath(t, []byte(TEST_GEOJSON_PATH))
+
+	if path.Id != 7 || path.Name != "Lakes" || path.Info != "Around the lakes" || path.Difficulty != "easy" || path.BundleId != 2 {
+		t.Errorf("Decoded path %+v", path)
+	}
+
+	polyline := GEOCoordinates{NewGEOCoordinateWithAltitude(63.1, 21.05, 12.5), NewGEOCoordinate(63.15, 21.075)}
+	if !reflect.DeepEqual(path.Polyline, polyline) {
+		t.Errorf("Decoded polyline %v, expected %v", path.Polyline, polyline)
+	}
+
+	decoded := decodeGeoJSONPath(t, encodeGeoJSON(t, path.AsGeoJSONFeature()))
+	if !reflect.DeepEqual(decoded, path) {
+		t.Errorf("Round trip changed path %+v to %+v", path, decoded)
+	}
+}
+
+func TestGeoJSONPathFeatureCollectionRoundTrip(t *testing.T) {
+	path := decodeGeoJSONPath(t, []byte(TEST_GEOJSON_PATH))
+	path.Places = append(path.Places, decodeGeoJSONPlace(t, []byte(TEST_GEOJSON_PLACE)))
+
+	collection := GeoJSONFromPaths([]*Path{path})
+	if len(collection.Features) != 2 {
+		t.Fatalf("Encoded %d features, expected 2", len(collection.Features))
+	}
+
+	decoded := decodeGeoJSONPath(t, encodeGeoJSON(t, collection))
+	if !reflect.DeepEqual(decoded, path) {
+		t.Errorf("Round trip changed path %+v to %+v", path, decoded)
+	}
+}
+
+func TestGeoJSONPlaceFeatureRoundTrip(t *testing.T) {
+	place := decodeGeoJSONPlace(t, []byte(TEST_GEOJSON_PLACE))
+
+	if place.Id != 3 || place.Name != "Shelter" || place.Info != "Open all year" || place.Radius != 50 {
+		t.Errorf("Decoded place %+v", place)
+	}
+	if !reflect.DeepEqual(place.Position, NewGEOCoordinateWithAltitude(63.2, 21.1, 42)) {
+		t.Errorf("Decoded position %v", place.Position)
+	}
+
+	decoded := decodeGeoJSONPlace(t, encodeGeoJSON(t, place.AsGeoJSONFeature()))
+	if !reflect.DeepEqual(decoded, place) {
+		t.Errorf("Round trip changed place %+v to %+v", place, decoded)
+	}
+}
+
+func TestRejectsMalformedGeoJSONPaths(t *testing.T) {
+	documents := map[string]string{
+		"point geometry":     `{"type": "Feature", "geometry": {"type": "Point", "coordinates": [21.1, 63.2]}}`,
+		"no geometry":        `{"type": "Feature", "properties": {"name": "Lakes"}}`,
+		"short position":     `{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[21.05, 63.1], [21.075]]}}`,
+		"position type":      `{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [["21.05", "63.1"]]}}`,
+		"coordinates":        `{"type": "Feature", "geometry": {"type": "LineString", "coordinates": {"lat": 63.1}}}`,
+		"property type":      `{"type": "Feature", "geometry": {"type": "LineString", "coordinates": []}, "properties": {"name": 1}}`,
+		"no line string":     `{"type": "FeatureCollection", "features": []}`,
+		"two line strings":   `{"type": "FeatureCollection", "features": [` + TEST_GEOJSON_PATH + `, ` + TEST_GEOJSON_PATH + `]}`,
+		"polygon geometry":   `{"type": "FeatureCollection", "features": [{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": []}}]}`,
+		"place position":     `{"type": "FeatureCollection", "features": [` + TEST_GEOJSON_PATH + `, {"type": "Feature", "geometry": {"type": "Point", "coordinates": [21.1]}}]}`,
+		"invalid feature":    `{"type": "FeatureCollection", "features": {}}`,
+		"truncated document": `{"type": "Feature", "geometry": {"type": "LineString"`,
+	}
+
+	for name, document := range documents {
+		err := json.Unmarshal([]byte(document), NewPath())
+		if err == nil {
+			t.Errorf("Decoded GeoJSON path with %s", name)
+		}
+	}
+}
+
+func TestRejectsMalformedGeoJSONPlaces(t *testing.T) {
+	documents := map[string]string{
+		"line string geometry": TEST_GEOJSON_PATH,
+		"no geometry":          `{"type": "Feature", "properties": {"name": "Shelter"}}`,
+		"short position":       `{"type": "Feature", "geometry": {"type": "Point", "coordinates": [21.1]}}`,
+		"coordinates":          `{"type": "Feature", "geometry": {"type": "Point", "coordinates": "21.1, 63.2"}}`,
+		"feature collection":   `{"type": "FeatureCollection", "features": [` + TEST_GEOJSON_PLACE + `]}`,
+	}
+
+	for name, document := range documents {
+		err := json.Unmarshal([]byte(document), NewPlace())
+		if err == nil {
+			t.Errorf("Decoded GeoJSON place with %s", name)
+		}
+	}
+}
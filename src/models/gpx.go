@@ -0,0 +1,213 @@
+package models
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	GPX_NAMESPACE = "http://www.topografix.com/GPX/1/1"
+	GPX_CREATOR   = "hiking_trails"
+)
+
+// Subset of the GPX 1.0/1.1 schema(http://www.topografix.com/GPX/1/1/) needed
+// to import and export paths. Name and description are read from <metadata> in GPX 1.1
+// and from the root element in GPX 1.0.
+
+type GPX struct {
+	XMLName     xml.Name      `xml:"gpx"`
+	Namespace   string        `xml:"xmlns,attr,omitempty"`
+	Version     string        `xml:"version,attr,omitempty"`
+	Creator     string        `xml:"creator,attr,omitempty"`
+	Name        string        `xml:"name,omitempty"`
+	Description string        `xml:"desc,omitempty"`
+	Metadata    *GPXMetadata  `xml:"metadata,omitempty"`
+	Waypoints   []GPXWaypoint `xml:"wpt"`
+	Tracks      []GPXTrack    `xml:"trk"`
+	Routes      []GPXRoute    `xml:"rte"`
+}
+
+type GPXMetadata struct {
+	Name        string `xml:"name,omitempty"`
+	Description string `xml:"desc,omitempty"`
+}
+
+type GPXWaypoint struct {
+	Latitude    float64  `xml:"lat,attr"`
+	Longitude   float64  `xml:"lon,attr"`
+	Elevation   *float64 `xml:"ele,omitempty"`
+	Name        string   `xml:"name,omitempty"`
+	Description string   `xml:"desc,omitempty"`
+}
+
+type GPXTrack struct {
+	Name        string            `xml:"name,omitempty"`
+	Description string            `xml:"desc,omitempty"`
+	Segments    []GPXTrackSegment `xml:"trkseg"`
+}
+
+type GPXTrackSegment struct {
+	Points []GPXWaypoint `xml:"trkpt"`
+}
+
+type GPXRoute struct {
+	Name        string        `xml:"name,omitempty"`
+	Description string        `xml:"desc,omitempty"`
+	Points      []GPXWaypoint `xml:"rtept"`
+}
+
+// Creates a GPX 1.1 document with one track per path and a waypoint for each
+// place on the paths.
+func NewGPXFromPaths(name string, description string, paths []*Path) *GPX {
+	gpx := &GPX{
+		Namespace: GPX_NAMESPACE,
+		Version:   "1.1",
+		Creator:   GPX_CREATOR,
+		Metadata:  &GPXMetadata{name, description},
+	}
+
+	for _, path := range paths {
+		segment := GPXTrackSegment{make([]GPXWaypoint, 0, len(path.Polyline))}
+		for _, coordinate := range path.Polyline {
+			segment.Points = append(segment.Points, newGPXWaypoint(coordinate, "", ""))
+		}
+
+		track := GPXTrack{path.Name, path.Info, []GPXTrackSegment{segment}}
+		gpx.Tracks = append(gpx.Tracks, track)
+
+		for _, place := range path.Places {
+			gpx.Waypoints = append(gpx.Waypoints, newGPXWaypoint(place.Position, place.Name, place.Info))
+		}
+	}
+
+	return gpx
+}
+
+func newGPXWaypoint(coordinate GEOCoordinate, name string, description string) GPXWaypoint {
+	waypoint := GPXWaypoint{
+		Latitude:    float32AsFloat64(coordinate.Latitude),
+		Longitude:   float32AsFloat64(coordinate.Longitude),
+		Name:        name,
+		Description: description,
+	}
+
+	if coordinate.HasAltitude() {
+		elevation := float32AsFloat64(*coordinate.Altitude)
+		waypoint.Elevation = &elevation
+	}
+
+	return waypoint
+}
+
+// Widens a float32 without introducing trailing digits, so 63.1 stays 63.1
+// instead of becoming 63.099998474121094 when serialized.
+func float32AsFloat64(value float32) float64 {
+	widened, _ := strconv.ParseFloat(strconv.FormatFloat(float64(value), 'g', -1, 32), 64)
+	return widened
+}
+
+func (waypoint GPXWaypoint) GEOCoordinate() GEOCoordinate {
+	if waypoint.Elevation != nil {
+		return NewGEOCoordinateWithAltitude(float32(waypoint.Latitude), float32(waypoint.Longitude), float32(*waypoint.Elevation))
+	}
+
+	return NewGEOCoordinate(float32(waypoint.Latitude), float32(waypoint.Longitude))
+}
+
+func ParseGPX(reader io.Reader) (*GPX, error) {
+	gpx := &GPX{}
+
+	err := xml.NewDecoder(reader).Decode(gpx)
+	if err != nil {
+		return nil, NewAPIError(400, fmt.Sprintf("Invalid GPX document: %s", err), nil)
+	}
+
+	return gpx, nil
+}
+
+// Converts the GPX document to a path. All track segments and routes are
+// joined into a single polyline and all waypoints become places on the path.
+func (gpx *GPX) AsPath() (*Path, error) {
+	path := NewPath()
+	path.Name, path.Info = gpx.nameAndDescription()
+
+	for _, track := range gpx.Tracks {
+		for _, segment := range track.Segments {
+			for _, point := range segment.Points {
+				path.Polyline = append(path.Polyline, point.GEOCoordinate())
+			}
+		}
+	}
+
+	for _, route := range gpx.Routes {
+		for _, point := range route.Points {
+			path.Polyline = append(path.Polyline, point.GEOCoordinate())
+		}
+	}
+
+	if len(path.Polyline) == 0 {
+		return nil, NewAPIError(400, "GPX document does not contain any track or route points", nil)
+	}
+
+	for i, waypoint := range gpx.Waypoints {
+		place := NewPlace()
+		place.Name = waypoint.Name
+		place.Info = waypoint.Description
+		place.Position = waypoint.GEOCoordinate()
+
+		if place.Name == "" {
+			place.Name = fmt.Sprintf("Waypoint %d", i+1)
+		}
+
+		path.Places = append(path.Places, place)
+	}
+
+	return path, nil
+}
+
+func (gpx *GPX) nameAndDescription() (string, string) {
+	name, description := gpx.Name, gpx.Description
+
+	if gpx.Metadata != nil {
+		if gpx.Metadata.Name != "" {
+			name = gpx.Metadata.Name
+		}
+		if gpx.Metadata.Description != "" {
+			description = gpx.Metadata.Description
+		}
+	}
+
+	// Fall back to the first track or route, since many devices only name them.
+	if len(gpx.Tracks) > 0 {
+		if name == "" {
+			name = gpx.Tracks[0].Name
+		}
+		if description == "" {
+			description = gpx.Tracks[0].Description
+		}
+	}
+
+	if len(gpx.Routes) > 0 {
+		if name == "" {
+			name = gpx.Routes[0].Name
+		}
+		if description == "" {
+			description = gpx.Routes[0].Description
+		}
+	}
+
+	return name, description
+}
+
+func (gpx *GPX) Encode(writer io.Writer) error {
+	_, err := io.WriteString(writer, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+	return encoder.Encode(gpx)
+}
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const TEST_GPX_TRACK = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx xmlns="http://www.topografix.com/GPX/1/1" version="1.1" creator="test">
+  <metadata><name>Lakes</name><desc>Around the lakes</desc></metadata>
+  <wpt lat="63.1" lon="21.05"><name>Shelter</name><desc>Open all year</desc></wpt>
+  <wpt lat="63.2" lon="21.1"><ele>42</ele></wpt>
+  <trk>
+    <name>Track</name>
+    <trkseg>
+      <trkpt lat="63.1" lon="21.05"><ele>12.5</ele></trkpt>
+      <trkpt lat="63.15" lon="21.075"><ele>20</ele></trkpt>
+    </trkseg>
+    <trkseg>
+      <trkpt lat="63.2" lon="21.1"/>
+    </trkseg>
+  </trk>
+</gpx>`
+
+const TEST_GPX_ROUTE = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx xmlns="http://www.topografix.com/GPX/1/0" version="1.0" creator="test">
+  <rte>
+    <name>Ridge</name>
+    <desc>Along the ridge</desc>
+    <rtept lat="-1.5" lon="-20.25"><ele>300</ele></rtept>
+    <rtept lat="-1.75" lon="-20.5"><ele>310.5</ele></rtept>
+  </rte>
+</gpx>`
+
+func parseGPXPath(t *testing.T, document string) *Path {
+	gpx, err := ParseGPX(strings.NewReader(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := gpx.AsPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// Exports the path as GPX and imports it again.
+func exportAndImportGPX(t *testing.T, path *Path) (*GPX, *Path) {
+	buffer := &bytes.Buffer{}
+
+	err := NewGPXFromPaths(path.Name, path.Info, []*Path{path}).Encode(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gpx, err := ParseGPX(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := gpx.AsPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return gpx, imported
+}
+
+func TestImportsGPXTrack(t *testing.T) {
+	path := parseGPXPath(t, TEST_GPX_TRACK)
+
+	if path.Name != "Lakes" || path.Info != "Around the lakes" {
+		t.Errorf("Imported path '%s', '%s'", path.Name, path.Info)
+	}
+
+	polyline := GEOCoordinates{
+		NewGEOCoordinateWithAltitude(63.1, 21.05, 12.5),
+		NewGEOCoordinateWithAltitude(63.15, 21.075, 20),
+		NewGEOCoordinate(63.2, 21.1),
+	}
+	if !reflect.DeepEqual(path.Polyline, polyline) {
+		t.Errorf("Imported polyline %v, expected %v", path.Polyline, polyline)
+	}
+
+	if len(path.Places) != 2 {
+		t.Fatalf("Imported %d places, expected 2", len(path.Places))
+	}
+
+	shelter, unnamed := path.Places[0], path.Places[1]
+	if shelter.Name != "Shelter" || shelter.Info != "Open all year" || shelter.Position != NewGEOCoordinate(63.1, 21.05) {
+		t.Errorf("Imported place %+v", shelter)
+	}
+	if unnamed.Name != "Waypoint 2" || !reflect.DeepEqual(unnamed.Position, NewGEOCoordinateWithAltitude(63.2, 21.1, 42)) {
+		t.Errorf("Imported place %+v", unnamed)
+	}
+}
+
+func TestGPXTrackRoundTrip(t *testing.T) {
+	path := parseGPXPath(t, TEST_GPX_TRACK)
+
+	gpx, imported := exportAndImportGPX(t, path)
+
+	if len(gpx.Tracks) != 1 || len(gpx.Tracks[0].Segments) != 1 || len(gpx.Waypoints) != 2 {
+		t.Errorf("Exported %d tracks and %d waypoints", len(gpx.Tracks), len(gpx.Waypoints))
+	}
+
+	if !reflect.DeepEqual(imported, path) {
+		t.Errorf("Round trip changed path %+v to %+v", path, imported)
+	}
+}
+
+func TestGPXRouteRoundTrip(t *testing.T) {
+	path := parseGPXPath(t, TEST_GPX_ROUTE)
+
+	if path.Name != "Ridge" || path.Info != "Along the ridge" {
+		t.Errorf("Imported path '%s', '%s'", path.Name, path.Info)
+	}
+
+	polyline := GEOCoordinates{
+		NewGEOCoordinateWithAltitude(-1.5, -20.25, 300),
+		NewGEOCoordinateWithAltitude(-1.75, -20.5, 310.5),
+	}
+	if !reflect.DeepEqual(path.Polyline, polyline) {
+		t.Errorf("Imported polyline %v, expected %v", path.Polyline, polyline)
+	}
+
+	// Routes are exported as tracks.
+	gpx, imported := exportAndImportGPX(t, path)
+
+	if len(gpx.Routes) != 0 || len(gpx.Tracks) != 1 {
+		t.Errorf("Exported %d routes and %d tracks", len(gpx.Routes), len(gpx.Tracks))
+	}
+
+	if !reflect.DeepEqual(imported, path) {
+		t.Errorf("Round trip changed path %+v to %+v", path, imported)
+	}
+}
+
+func TestRejectsMalformedGPX(t *testing.T) {
+	documents := map[string]string{
+		"empty":       "",
+		"invalid XML": `<gpx version="1.1"><trk><trkseg><trkpt lat="63.1" lon="21.05"></trkseg></trk></gpx>`,
+		"coordinate":  `<gpx version="1.1"><trk><trkseg><trkpt lat="north" lon="21.05"/></trkseg></trk></gpx>`,
+		"no points":   `<gpx version="1.1"><wpt lat="63.1" lon="21.05"><name>Shelter</name></wpt><trk><trkseg/></trk></gpx>`,
+		"no tracks":   `<gpx version="1.1"/>`,
+	}
+
+	for name, document := range documents {
+		gpx, err := ParseGPX(strings.NewReader(document))
+		if err == nil {
+			_, err = gpx.AsPath()
+		}
+
+		if apiError, isAPIError := err.(*APIError); !isAPIError || apiError.Status != 400 {
+			t.Errorf("Imported GPX with %s with error %v, expected 400", name, err)
+		}
+	}
+}
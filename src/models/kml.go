@@ -0,0 +1,395 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Subset of KML 2.2(https://developers.google.com/kml/documentation/kmlreference)
+// needed to import and export bundles. A path is a Placemark with a
+// LineString and a place is a Placemark with a Point. KMZ files are zip
+// archives containing a KML document, named doc.kml by convention.
+
+const (
+	KML_NAMESPACE    = "http://www.opengis.net/kml/2.2"
+	KMZ_DOCUMENT     = "doc.kml"
+	KML_CONTENT_TYPE = "application/vnd.google-earth.kml+xml"
+	KMZ_CONTENT_TYPE = "application/vnd.google-earth.kmz"
+
+	// Largest accepted KML and GPX import, also after decompressing a KMZ
+	// archive.
+	MAX_IMPORT_BYTES = 32 * 1024 * 1024
+)
+
+type KML struct {
+	XMLName   xml.Name      `xml:"kml"`
+	Namespace string        `xml:"xmlns,attr,omitempty"`
+	Document  *KMLContainer `xml:"Document,omitempty"`
+	Folder    *KMLContainer `xml:"Folder,omitempty"`
+	Placemark *KMLPlacemark `xml:"Placemark,omitempty"`
+}
+
+// Document and Folder elements.
+type KMLContainer struct {
+	Name        string         `xml:"name,omitempty"`
+	Description string         `xml:"description,omitempty"`
+	Placemarks  []KMLPlacemark `xml:"Placemark"`
+	Folders     []KMLContainer `xml:"Folder"`
+	Documents   []KMLContainer `xml:"Document"`
+}
+
+type KMLPlacemark struct {
+	Name          string            `xml:"name,omitempty"`
+	Description   string            `xml:"description,omitempty"`
+	Point         *KMLGeometry      `xml:"Point,omitempty"`
+	LineString    *KMLGeometry      `xml:"LineString,omitempty"`
+	MultiGeometry *KMLMultiGeometry `xml:"MultiGeometry,omitempty"`
+}
+
+type KMLGeometry struct {
+	Tessellate   int    `xml:"tessellate,omitempty"`
+	AltitudeMode string `xml:"altitudeMode,omitempty"`
+	Coordinates  string `xml:"coordinates"`
+}
+
+type KMLMultiGeometry struct {
+	Points      []KMLGeometry `xml:"Point"`
+	LineStrings []KMLGeometry `xml:"LineString"`
+}
+
+func NewKMLFromBundle(bundle *Bundle) *KML {
+	document := &KMLContainer{Name: bundle.Name, Description: bundle.Info}
+	for _, path := range bundle.Paths {
+		document.Folders = append(document.Folders, *newKMLFolderFromPath(path))
+	}
+
+	return &KML{Namespace: KML_NAMESPACE, Document: document}
+}
+
+func NewKMLFromPath(path *Path) *KML {
+	return &KML{Namespace: KML_NAMESPACE, Document: newKMLFolderFromPath(path)}
+}
+
+func newKMLFolderFromPath(path *Path) *KMLContainer {
+	folder := &KMLContainer{Name: path.Name, Description: path.Info}
+
+	folder.Placemarks = append(folder.Placemarks, KMLPlacemark{
+		Name:        path.Name,
+		Description: path.Info,
+		LineString:  newKMLGeometry(1, path.Polyline),
+	})
+
+	for _, place := range path.Places {
+		folder.Placemarks = append(folder.Placemarks, KMLPlacemark{
+			Name:        place.Name,
+			Description: place.Info,
+			Point:       newKMLGeometry(0, GEOCoordinates{place.Position}),
+		})
+	}
+
+	return folder
+}
+
+// Altitudes are only used by KML clients when the altitude mode is absolute,
+// which requires every coordinate to have an altitude.
+func newKMLGeometry(tessellate int, coordinates GEOCoordinates) *KMLGeometry {
+	geometry := &KMLGeometry{Tessellate: tessellate, Coordinates: kmlCoordinates(coordinates)}
+
+	allHaveAltitude := len(coordinates) > 0
+	for _, coordinate := range coordinates {
+		allHaveAltitude = allHaveAltitude && coordinate.HasAltitude()
+	}
+
+	if allHaveAltitude {
+		geometry.AltitudeMode = "absolute"
+	}
+
+	return geometry
+}
+
+// KML coordinates are whitespace separated tuples of longitude,latitude[,altitude].
+func kmlCoordinates(coordinates GEOCoordinates) string {
+	tuples := make([]string, 0, len(coordinates))
+	for _, coordinate := range coordinates {
+		tuple := strconv.FormatFloat(float64(coordinate.Longitude), 'f', -1, 32) + "," +
+			strconv.FormatFloat(float64(coordinate.Latitude), 'f', -1, 32)
+
+		if coordinate.HasAltitude() {
+			tuple += "," + strconv.FormatFloat(float64(*coordinate.Altitude), 'f', -1, 32)
+		}
+
+		tuples = append(tuples, tuple)
+	}
+
+	return strings.Join(tuples, " ")
+}
+
+func parseKMLCoordinates(text string) (GEOCoordinates, error) {
+	coordinates := NewGEOCoordinates()
+
+	for _, tuple := range strings.Fields(text) {
+		values := strings.Split(tuple, ",")
+		if len(values) < 2 {
+			return nil, fmt.Errorf("Invalid KML coordinate '%s'", tuple)
+		}
+
+		longitude, err := strconv.ParseFloat(values[0], 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid KML coordinate '%s'", tuple)
+		}
+
+		latitude, err := strconv.ParseFloat(values[1], 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid KML coordinate '%s'", tuple)
+		}
+
+		if len(values) < 3 {
+			coordinates = append(coordinates, NewGEOCoordinate(float32(latitude), float32(longitude)))
+			continue
+		}
+
+		altitude, err := strconv.ParseFloat(values[2], 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid KML coordinate '%s'", tuple)
+		}
+
+		coordinates = append(coordinates, NewGEOCoordinateWithAltitude(float32(latitude), float32(longitude), float32(altitude)))
+	}
+
+	return coordinates, nil
+}
+
+// Parses either a KML document or a KMZ archive.
+func ParseKML(reader io.Reader) (*KML, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, NewAPIError(400, "Failed to read KML document", err)
+	}
+
+	if bytes.HasPrefix(data, []byte("PK")) {
+		data, err = readKMLFromKMZ(data)
+		if err != nil {
+			return nil, NewAPIError(400, fmt.Sprintf("Invalid KMZ archive: %s", err), nil)
+		}
+	}
+
+	kml := &KML{}
+
+	err = xml.Unmarshal(data, kml)
+	if err != nil {
+		return nil, NewAPIError(400, fmt.Sprintf("Invalid KML document: %s", err), nil)
+	}
+
+	return kml, nil
+}
+
+func readKMLFromKMZ(data []byte) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var document *zip.File
+	for _, file := range archive.File {
+		if file.Name == KMZ_DOCUMENT {
+			document = file
+			break
+		}
+
+		if document == nil && strings.EqualFold(filepath.Ext(file.Name), ".kml") {
+			document = file
+		}
+	}
+
+	if document == nil {
+		return nil, fmt.Errorf("archive does not contain a KML document")
+	}
+
+	documentReader, err := document.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer documentReader.Close()
+
+	data, err = ioutil.ReadAll(io.LimitReader(documentReader, MAX_IMPORT_BYTES+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > MAX_IMPORT_BYTES {
+		return nil, fmt.Errorf("KML document is larger than %d bytes", MAX_IMPORT_BYTES)
+	}
+
+	return data, nil
+}
+
+// Converts the KML document to a bundle. Each LineString placemark becomes a
+// path. Point placemarks become places on the first path in the same Document
+// or Folder, or on the first path in the whole document if their container
+// has no path.
+func (kml *KML) AsBundle() (*Bundle, error) {
+	root := kml.Document
+	if root == nil {
+		root = kml.Folder
+	}
+	if root == nil {
+		root = &KMLContainer{}
+	}
+	if kml.Placemark != nil {
+		root.Placemarks = append(root.Placemarks, *kml.Placemark)
+	}
+
+	bundle := NewBundle()
+	bundle.Name = root.Name
+	bundle.Info = root.Description
+
+	orphans := make([]*Place, 0)
+	err := root.addPathsToBundle(bundle, &orphans)
+	if err != nil {
+		return nil, NewAPIError(400, fmt.Sprintf("Invalid KML document: %s", err), nil)
+	}
+
+	if len(bundle.Paths) == 0 {
+		return nil, NewAPIError(400, "KML document does not contain any LineString placemarks", nil)
+	}
+
+	bundle.Paths[0].Places = append(bundle.Paths[0].Places, orphans...)
+
+	return bundle, nil
+}
+
+func (container *KMLContainer) addPathsToBundle(bundle *Bundle, orphans *[]*Place) error {
+	paths := make([]*Path, 0)
+	places := make([]*Place, 0)
+
+	for _, placemark := range container.Placemarks {
+		polyline, positions, err := placemark.geometries()
+		if err != nil {
+			return err
+		}
+
+		if len(polyline) > 0 {
+			path := NewPath()
+			path.Name = placemark.Name
+			path.Info = placemark.Description
+			path.Polyline = polyline
+
+			if path.Name == "" {
+				path.Name = fmt.Sprintf("Path %d", len(bundle.Paths)+len(paths)+1)
+			}
+
+			paths = append(paths, path)
+		}
+
+		for _, position := range positions {
+			place := NewPlace()
+			place.Name = placemark.Name
+			place.Info = placemark.Description
+			place.Position = position
+
+			if place.Name == "" {
+				place.Name = fmt.Sprintf("Place %d", len(places)+1)
+			}
+
+			places = append(places, place)
+		}
+	}
+
+	if len(paths) > 0 {
+		paths[0].Places = append(paths[0].Places, places...)
+	} else {
+		*orphans = append(*orphans, places...)
+	}
+
+	bundle.Paths = append(bundle.Paths, paths...)
+
+	for _, children := range [][]KMLContainer{container.Folders, container.Documents} {
+		for i := range children {
+			err := children[i].addPathsToBundle(bundle, orphans)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the joined LineString geometries and all Point geometries of the
+// placemark.
+func (placemark *KMLPlacemark) geometries() (GEOCoordinates, []GEOCoordinate, error) {
+	lineStrings := make([]KMLGeometry, 0)
+	points := make([]KMLGeometry, 0)
+
+	if placemark.LineString != nil {
+		lineStrings = append(lineStrings, *placemark.LineString)
+	}
+	if placemark.Point != nil {
+		points = append(points, *placemark.Point)
+	}
+	if placemark.MultiGeometry != nil {
+		lineStrings = append(lineStrings, placemark.MultiGeometry.LineStrings...)
+		points = append(points, placemark.MultiGeometry.Points...)
+	}
+
+	polyline := NewGEOCoordinates()
+	for _, lineString := range lineStrings {
+		coordinates, err := parseKMLCoordinates(lineString.Coordinates)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		polyline = append(polyline, coordinates...)
+	}
+
+	positions := make([]GEOCoordinate, 0)
+	for _, point := range points {
+		coordinates, err := parseKMLCoordinates(point.Coordinates)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(coordinates) != 1 {
+			return nil, nil, fmt.Errorf("Point must have exactly one coordinate")
+		}
+
+		positions = append(positions, coordinates[0])
+	}
+
+	return polyline, positions, nil
+}
+
+func (kml *KML) Encode(writer io.Writer) error {
+	_, err := io.WriteString(writer, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+	return encoder.Encode(kml)
+}
+
+// Writes the KML document as a KMZ archive.
+func (kml *KML) EncodeAsKMZ(writer io.Writer) error {
+	archive := zip.NewWriter(writer)
+
+	document, err := archive.Create(KMZ_DOCUMENT)
+	if err != nil {
+		return err
+	}
+
+	err = kml.Encode(document)
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
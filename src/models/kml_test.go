@@ -0,0 +1,49 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func kmzArchive(t *testing.T, document []byte) []byte {
+	buffer := &bytes.Buffer{}
+	archive := zip.NewWriter(buffer)
+
+	writer, err := archive.Create(KMZ_DOCUMENT)
+	if err == nil {
+		_, err = writer.Write(document)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func TestParsesKMZArchive(t *testing.T) {
+	document := `<kml xmlns="http://www.opengis.net/kml/2.2"><Document><name>Lakes</name></Document></kml>`
+
+	kml, err := ParseKML(bytes.NewReader(kmzArchive(t, []byte(document))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kml.Document == nil || kml.Document.Name != "Lakes" {
+		t.Errorf("Parsed %+v", kml)
+	}
+}
+
+func TestRejectsKMZArchiveWithTooLargeDocument(t *testing.T) {
+	// Compresses to a small fraction of the limit.
+	document := bytes.Repeat([]byte(" "), MAX_IMPORT_BYTES+1)
+
+	_, err := ParseKML(bytes.NewReader(kmzArchive(t, document)))
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("Parsed too large document with error %v", err)
+	}
+}
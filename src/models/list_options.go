@@ -0,0 +1,134 @@
+package models
+
+import (
+	"strings"
+)
+
+// Paging and sorting of the bundle, path, place, user and login attempt lists.
+// Nil options load the whole list in database order, as before paging existed.
+
+// Sortable fields of the JSON representations and their columns.
+var (
+	BUNDLE_SORT_COLUMNS = map[string]string{
+		"id":   "id",
+		"name": "name",
+	}
+
+	PATH_SORT_COLUMNS = map[string]string{
+		"id":              "id",
+		"name":            "name",
+		"lengthMeters":    "length_meters",
+		"durationSeconds": "duration_seconds",
+		"difficulty":      "difficulty",
+		"bundleId":        "bundle_id",
+	}
+
+	PLACE_SORT_COLUMNS = map[string]string{
+		"id":     "id",
+		"name":   "name",
+		"radius": "radius",
+		"pathId": "path_id",
+	}
+
+	USER_SORT_COLUMNS = map[string]string{
+		"id":       "id",
+		"username": "username",
+		"role":     "role",
+	}
+
+	LOGIN_ATTEMPT_SORT_COLUMNS = map[string]string{
+		"id":          "id",
+		"username":    "username",
+		"ipAddress":   "ip_address",
+		"attemptedAt": "attempted_at",
+	}
+)
+
+type SortOrder struct {
+	Column     string
+	Descending bool
+}
+
+type ListOptions struct {
+	// Zero means no limit.
+	Limit  int64
+	Offset int64
+	Sort   []SortOrder
+
+	// Skips loading the paths of bundles and the places of paths.
+	WithoutChildren bool
+	// Skips loading the polylines of paths.
+	WithoutPolylines bool
+}
+
+// ORDER BY clause for the sort orders. Rows are finally sorted by id, so
+// pages are stable.
+func (options *ListOptions) orderBy() string {
+	if options == nil {
+		return ""
+	}
+
+	terms := make([]string, 0, len(options.Sort)+1)
+	for _, order := range options.Sort {
+		if order.Descending {
+			terms = append(terms, order.Column+" DESC")
+		} else {
+			terms = append(terms, order.Column+" ASC")
+		}
+	}
+
+	terms = append(terms, "id ASC")
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
+
+func (options *ListOptions) limit() (string, []interface{}) {
+	if options == nil || (options.Limit == 0 && options.Offset == 0) {
+		return "", nil
+	}
+
+	var limit interface{} = options.Limit
+	if options.Limit == 0 {
+		limit = Dialect.noLimit()
+	}
+
+	return " LIMIT ? OFFSET ?", []interface{}{limit, options.Offset}
+}
+
+// Same options without limit and offset, used for lists that are refined
+// after the query and therefore paged in memory.
+func (options *ListOptions) withoutPaging() *ListOptions {
+	if options == nil {
+		return nil
+	}
+
+	unpaged := *options
+	unpaged.Limit, unpaged.Offset = 0, 0
+	return &unpaged
+}
+
+// Start and end index of the page in a list with count items.
+func (options *ListOptions) page(count int) (int, int) {
+	if options == nil {
+		return 0, count
+	}
+
+	start := count
+	if options.Offset < int64(count) {
+		start = int(options.Offset)
+	}
+
+	end := count
+	if options.Limit > 0 && options.Limit < int64(count-start) {
+		end = start + int(options.Limit)
+	}
+
+	return start, end
+}
+
+func (options *ListOptions) loadChildren() bool {
+	return options == nil || !options.WithoutChildren
+}
+
+func (options *ListOptions) loadPolylines() bool {
+	return options == nil || !options.WithoutPolylines
+}
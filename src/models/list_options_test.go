@@ -0,0 +1,28 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPageOfList(t *testing.T) {
+	tests := []struct {
+		options    *ListOptions
+		start, end int
+	}{
+		{nil, 0, 10},
+		{&ListOptions{}, 0, 10},
+		{&ListOptions{Limit: 3}, 0, 3},
+		{&ListOptions{Limit: 3, Offset: 8}, 8, 10},
+		{&ListOptions{Offset: 12}, 10, 10},
+		{&ListOptions{Limit: math.MaxInt64, Offset: 2}, 2, 10},
+		{&ListOptions{Limit: math.MaxInt64, Offset: math.MaxInt64}, 10, 10},
+	}
+
+	for _, test := range tests {
+		start, end := test.options.page(10)
+		if start != test.start || end != test.end {
+			t.Errorf("Page of %+v is %d-%d, expected %d-%d", test.options, start, end, test.start, test.end)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package models
+
+import (
+	"time"
+	"unicode/utf8"
+)
+
+// Logins are recorded, and repeated failed logins for a username or from an IP
+// address are throttled. After half the maximum number of failures, each
+// failure makes the next login wait exponentially longer, starting at a
+// second. At the maximum number of failures logins are locked out for the
+// lockout duration. Failures older than the lockout duration are forgotten,
+// and a successful login forgets the failures of its username, but not of its
+// IP address. Logins are recorded as failed before the password is verified,
+// so that concurrent logins are throttled by each other.
+
+const (
+	LOGIN_BACKOFF_BASE = time.Second
+)
+
+// id (int) Attempt id.
+// username (string) Username the login was attempted for.
+// ipAddress (string) IP address the login was attempted from.
+// attemptedAt (string) When the login was attempted.
+// succeeded (bool) Whether the login succeeded.
+
+type LoginAttempt struct {
+	Id          int64     `json:"id"`
+	Username    string    `json:"username"`
+	IPAddress   string    `json:"ipAddress"`
+	AttemptedAt time.Time `json:"attemptedAt"`
+	Succeeded   bool      `json:"succeeded"`
+}
+
+func NewLoginAttempt(username string, ipAddress string, succeeded bool) *LoginAttempt {
+	return &LoginAttempt{Username: username, IPAddress: ipAddress, AttemptedAt: time.Now(), Succeeded: succeeded}
+}
+
+func (attempt *LoginAttempt) Save(execer SQLExecer) error {
+	// Usernames of failed logins are not validated.
+	username := attempt.Username
+	for len(username) > 255 {
+		_, size := utf8.DecodeLastRuneInString(username)
+		username = username[:len(username)-size]
+	}
+
+	id, err := Dialect.insert(execer, "INSERT INTO login_attempts(username, ip_address, attempted_at, succeeded) VALUES(?,?,?,?)",
+		username, attempt.IPAddress, attempt.AttemptedAt.Unix(), attempt.Succeeded)
+
+	if err != nil {
+		return NewAPIError(500, "Failed to record login attempt", err)
+	}
+
+	attempt.Id = id
+	return nil
+}
+
+// Marks a reserved attempt as succeeded.
+func (attempt *LoginAttempt) Succeed(execer SQLExecer) error {
+	_, err := execer.Exec("UPDATE login_attempts SET succeeded=? WHERE id=?", true, attempt.Id)
+	if err != nil {
+		return NewAPIError(500, "Failed to record successful login", err)
+	}
+
+	attempt.Succeeded = true
+	return nil
+}
+
+type LoginThrottle struct {
+	MaxFailures      int64
+	MaxFailuresPerIP int64
+	LockoutDuration  time.Duration
+}
+
+// Records the failed attempt before its password is verified, and returns how
+// long to wait before logins for its username from its IP address are allowed
+// again, or zero if they are allowed now. Only earlier attempts are counted.
+// Throttled attempts are not kept, the attempt is marked as succeeded if the
+// password is correct.
+func (throttle LoginThrottle) Reserve(handle DatabaseHandle, attempt *LoginAttempt) (time.Duration, error) {
+	err := attempt.Save(handle)
+	if err != nil {
+		return 0, err
+	}
+
+	wait, err := throttle.retryAfter(handle, attempt.Username, attempt.IPAddress, attempt.Id)
+	if err != nil || wait > 0 {
+		_, deleteErr := handle.Exec("DELETE FROM login_attempts WHERE id=?", attempt.Id)
+		if err == nil && deleteErr != nil {
+			err = NewAPIError(500, "Failed to delete throttled login attempt", deleteErr)
+		}
+	}
+
+	return wait, err
+}
+
+// How long to wait after the attempts before beforeId.
+func (throttle LoginThrottle) retryAfter(queryer SQLQueryer, username string, ipAddress string,
+	beforeId int64) (time.Duration, error) {
+
+	now := time.Now()
+	since := now.Add(-throttle.LockoutDuration).Unix()
+
+	usernameFailures, usernameLastFailure, err := countLoginFailures(queryer,
+		"id<? AND username=? AND succeeded=? AND attempted_at>? AND attempted_at>"+
+			"(SELECT COALESCE(MAX(attempted_at), 0) FROM login_attempts WHERE id<? AND username=? AND succeeded=?)",
+		beforeId, username, false, since, beforeId, username, true)
+	if err != nil {
+		return 0, err
+	}
+
+	ipFailures, ipLastFailure, err := countLoginFailures(queryer, "id<? AND ip_address=? AND succeeded=? AND attempted_at>?",
+		beforeId, ipAddress, false, since)
+	if err != nil {
+		return 0, err
+	}
+
+	wait := usernameLastFailure.Add(throttle.delay(usernameFailures, throttle.MaxFailures)).Sub(now)
+
+	ipWait := ipLastFailure.Add(throttle.delay(ipFailures, throttle.MaxFailuresPerIP)).Sub(now)
+	if ipWait > wait {
+		wait = ipWait
+	}
+
+	if wait < 0 {
+		return 0, nil
+	}
+
+	return wait, nil
+}
+
+// Delay after the last of failures.
+func (throttle LoginThrottle) delay(failures int64, maxFailures int64) time.Duration {
+	if failures >= maxFailures {
+		return throttle.LockoutDuration
+	}
+
+	backoffFailures := failures - maxFailures/2
+	if backoffFailures < 0 {
+		return 0
+	}
+
+	// Shifting further would overflow, and the lockout is shorter anyway.
+	if backoffFailures >= 32 {
+		return throttle.LockoutDuration
+	}
+
+	delay := LOGIN_BACKOFF_BASE << uint(backoffFailures)
+	if delay > throttle.LockoutDuration {
+		return throttle.LockoutDuration
+	}
+
+	return delay
+}
+
+// Number of failed logins matching the condition and when the last happened.
+func countLoginFailures(queryer SQLQueryer, condition string, arguments ...interface{}) (int64, time.Time, error) {
+	var count, lastAttemptedAt int64
+
+	err := queryer.QueryRow(selectStatement("COUNT(*), COALESCE(MAX(attempted_at), 0)", "login_attempts", condition), arguments...).
+		Scan(&count, &lastAttemptedAt)
+	if err != nil {
+		return 0, time.Time{}, NewAPIError(500, "Failed to count failed logins", err)
+	}
+
+	return count, time.Unix(lastAttemptedAt, 0), nil
+}
+
+// Filter of login attempts. Zero values do not restrict the attempts.
+type LoginAttemptFilter struct {
+	Username  string
+	IPAddress string
+	Succeeded *bool
+}
+
+func (filter *LoginAttemptFilter) conditions() *queryConditions {
+	query := &queryConditions{}
+
+	if filter.Username != "" {
+		query.add("username=?", filter.Username)
+	}
+	if filter.IPAddress != "" {
+		query.add("ip_address=?", filter.IPAddress)
+	}
+	if filter.Succeeded != nil {
+		query.add("succeeded=?", *filter.Succeeded)
+	}
+
+	return query
+}
+
+func ListLoginAttempts(queryer SQLQueryer, filter *LoginAttemptFilter, options *ListOptions) ([]*LoginAttempt, int64, error) {
+	query := filter.conditions()
+
+	total, err := countRows(queryer, "login_attempts", query.condition(), query.arguments...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	limit, limitArguments := options.limit()
+	statement := selectStatement("id, username, ip_address, attempted_at, succeeded", "login_attempts", query.condition())
+
+	rows, err := queryer.Query(statement+options.orderBy()+limit, append(query.arguments, limitArguments...)...)
+	if err != nil {
+		return nil, 0, NewAPIError(500, "Failed to load login attempts", err)
+	}
+	defer rows.Close()
+
+	attempts := make([]*LoginAttempt, 0)
+	for rows.Next() {
+		attempt := &LoginAttempt{}
+		var attemptedAt int64
+
+		err = rows.Scan(&attempt.Id, &attempt.Username, &attempt.IPAddress, &attemptedAt, &attempt.Succeeded)
+		if err != nil {
+			return nil, 0, NewAPIError(500, "Failed to load login attempt from row", err)
+		}
+
+		attempt.AttemptedAt = time.Unix(attemptedAt, 0)
+		attempts = append(attempts, attempt)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, 0, NewAPIError(500, "Failed to load login attempts", err)
+	}
+
+	return attempts, total, nil
+}
@@ -0,0 +1,83 @@
+package models_test
+
+import (
+	"hiking_trails/src/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThrottlesConcurrentLogins(t *testing.T) {
+	db, cleanup := openSQLiteTestDatabase(t)
+	defer cleanup()
+
+	// SQLite allows one writer at a time.
+	db.SetMaxOpenConns(1)
+
+	// Any earlier failure locks the username out.
+	throttle := models.LoginThrottle{MaxFailures: 1, MaxFailuresPerIP: 100, LockoutDuration: time.Hour}
+
+	waits := make(chan time.Duration, 10)
+	group := &sync.WaitGroup{}
+
+	for i := 0; i < cap(waits); i++ {
+		group.Add(1)
+
+		go func() {
+			defer group.Done()
+
+			wait, err := throttle.Reserve(db, models.NewLoginAttempt("admin", "192.0.2.1", false))
+			if err != nil {
+				t.Error(err)
+			}
+
+			waits <- wait
+		}()
+	}
+
+	group.Wait()
+	close(waits)
+
+	allowed := 0
+	for wait := range waits {
+		if wait == 0 {
+			allowed++
+		}
+	}
+
+	if allowed != 1 {
+		t.Errorf("Allowed %d of %d concurrent logins, expected 1", allowed, cap(waits))
+	}
+
+	// Throttled attempts are not kept.
+	if failures := countRows(t, db, "login_attempts"); failures != 1 {
+		t.Errorf("Recorded %d login attempts, expected 1", failures)
+	}
+}
+
+func TestSuccessfulLoginForgetsFailures(t *testing.T) {
+	db, cleanup := openSQLiteTestDatabase(t)
+	defer cleanup()
+
+	throttle := models.LoginThrottle{MaxFailures: 1, MaxFailuresPerIP: 100, LockoutDuration: time.Hour}
+
+	attempt := models.NewLoginAttempt("admin", "192.0.2.1", false)
+	attempt.AttemptedAt = attempt.AttemptedAt.Add(-time.Minute)
+
+	wait, err := throttle.Reserve(db, attempt)
+	if err == nil && wait == 0 {
+		err = attempt.Succeed(db)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait, err = throttle.Reserve(db, models.NewLoginAttempt("admin", "192.0.2.1", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wait != 0 {
+		t.Errorf("Login after successful login must wait %s", wait)
+	}
+}
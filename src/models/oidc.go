@@ -0,0 +1,473 @@
+package models
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Login with an OpenID Connect identity provider using the authorization code
+// flow with PKCE. The endpoints of the provider are discovered from its issuer
+// URL on first use. ID tokens are taken from the token endpoint of the
+// provider, and are still verified against its RS256 signing keys, which are
+// fetched again when a token is signed with an unknown key.
+
+const (
+	OIDC_REQUEST_TIMEOUT = 10 * time.Second
+
+	// Signing keys are fetched at most this often, so tokens with unknown keys
+	// can not make every login fetch them.
+	OIDC_KEYS_REFRESH_INTERVAL = time.Minute
+
+	// Tolerated difference between the clocks of the server and the provider.
+	OIDC_CLOCK_SKEW = time.Minute
+
+	// Responses of the provider are never expected to be larger.
+	OIDC_MAX_RESPONSE_SIZE = 1 << 20
+
+	OIDC_SCOPES = "openid profile email"
+)
+
+type OIDCProvider struct {
+	issuer       string
+	clientId     string
+	clientSecret string
+	redirectURL  string
+	client       *http.Client
+
+	lock          *sync.Mutex
+	endpoints     *oidcEndpoints
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+type oidcEndpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewOIDCProvider(issuer string, clientId string, clientSecret string, redirectURL string) *OIDCProvider {
+	return &OIDCProvider{
+		issuer:       issuer,
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		client:       &http.Client{Timeout: OIDC_REQUEST_TIMEOUT},
+		lock:         &sync.Mutex{},
+		keys:         make(map[string]*rsa.PublicKey),
+	}
+}
+
+// Random value for the state, nonce and code verifier of a login.
+func MustGenerateOIDCSecret() string {
+	secret := make([]byte, 32)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(secret)
+}
+
+// URL of the provider the browser is sent to for logging in.
+func (provider *OIDCProvider) AuthorizationURL(state string, nonce string, codeVerifier string) (string, error) {
+	endpoints, err := provider.discover()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+
+	parameters := url.Values{}
+	parameters.Set("response_type", "code")
+	parameters.Set("client_id", provider.clientId)
+	parameters.Set("redirect_uri", provider.redirectURL)
+	parameters.Set("scope", OIDC_SCOPES)
+	parameters.Set("state", state)
+	parameters.Set("nonce", nonce)
+	parameters.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	parameters.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(endpoints.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return endpoints.AuthorizationEndpoint + separator + parameters.Encode(), nil
+}
+
+// Exchanges the authorization code for the verified claims of the ID token.
+func (provider *OIDCProvider) Exchange(code string, codeVerifier string, nonce string) (OIDCClaims, error) {
+	endpoints, err := provider.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.redirectURL)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", provider.clientId)
+
+	request, err := http.NewRequest("POST", endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, NewAPIError(500, "Failed to create token request", err)
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if provider.clientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(provider.clientId), url.QueryEscape(provider.clientSecret))
+	}
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	status, err := provider.doJSON(request, &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokens.Error != "" {
+		return nil, NewAPIError(401, strings.TrimSpace("Identity provider rejected the login: "+tokens.Error+" "+tokens.ErrorDescription), nil)
+	}
+
+	if status != 200 || tokens.IDToken == "" {
+		return nil, NewAPIError(502, fmt.Sprintf("Identity provider returned no ID token (status %d)", status), nil)
+	}
+
+	return provider.verifyIDToken(tokens.IDToken, nonce)
+}
+
+// Checks the signature, issuer, audience, expiry and nonce of the token.
+func (provider *OIDCProvider) verifyIDToken(token string, nonce string) (OIDCClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, NewAPIError(401, "Malformed ID token", nil)
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyId     string `json:"kid"`
+	}
+
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return nil, NewAPIError(401, "Malformed ID token header", err)
+	}
+
+	if header.Algorithm != "RS256" {
+		return nil, NewAPIError(401, fmt.Sprintf("ID token signature algorithm '%s' is not supported", header.Algorithm), nil)
+	}
+
+	key, err := provider.signingKey(header.KeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, NewAPIError(401, "Malformed ID token signature", err)
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	if err != nil {
+		return nil, NewAPIError(401, "Invalid ID token signature", err)
+	}
+
+	claims := OIDCClaims{}
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, NewAPIError(401, "Malformed ID token claims", err)
+	}
+
+	err = claims.validate(provider.issuer, provider.clientId, nonce, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func decodeJWTPart(part string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
+
+// Discovers the endpoints of the provider. Failed discoveries are retried on
+// the next login. The lock is not held while waiting for the provider, so
+// concurrent first logins may each discover the endpoints.
+func (provider *OIDCProvider) discover() (*oidcEndpoints, error) {
+	provider.lock.Lock()
+	endpoints := provider.endpoints
+	provider.lock.Unlock()
+
+	if endpoints != nil {
+		return endpoints, nil
+	}
+
+	endpoints, err := provider.fetchEndpoints()
+	if err != nil {
+		return nil, err
+	}
+
+	provider.lock.Lock()
+	provider.endpoints = endpoints
+	provider.lock.Unlock()
+
+	return endpoints, nil
+}
+
+func (provider *OIDCProvider) fetchEndpoints() (*oidcEndpoints, error) {
+	request, err := http.NewRequest("GET", strings.TrimSuffix(provider.issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, NewAPIError(500, "Failed to create discovery request", err)
+	}
+
+	endpoints := &oidcEndpoints{}
+	status, err := provider.doJSON(request, endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != 200 {
+		return nil, NewAPIError(502, fmt.Sprintf("Identity provider discovery failed with status %d", status), nil)
+	}
+
+	if endpoints.Issuer != provider.issuer {
+		return nil, NewAPIError(502, fmt.Sprintf("Identity provider issuer '%s' does not match '%s'", endpoints.Issuer, provider.issuer), nil)
+	}
+
+	if endpoints.AuthorizationEndpoint == "" || endpoints.TokenEndpoint == "" || endpoints.JWKSURI == "" {
+		return nil, NewAPIError(502, "Identity provider discovery is missing endpoints", nil)
+	}
+
+	return endpoints, nil
+}
+
+// The RS256 key with the key id, fetched again if unknown. The fetch time is
+// set before fetching without the lock, so concurrent logins fetch only once.
+func (provider *OIDCProvider) signingKey(keyId string) (*rsa.PublicKey, error) {
+	endpoints, err := provider.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	provider.lock.Lock()
+	key, exist := provider.keys[keyId]
+	fetchedAt := provider.keysFetchedAt
+	refresh := !exist && time.Since(fetchedAt) >= OIDC_KEYS_REFRESH_INTERVAL
+	if refresh {
+		provider.keysFetchedAt = time.Now()
+	}
+	provider.lock.Unlock()
+
+	if exist {
+		return key, nil
+	}
+
+	if !refresh {
+		return nil, NewAPIError(401, fmt.Sprintf("Unknown ID token signing key '%s'", keyId), nil)
+	}
+
+	keys, err := provider.fetchKeys(endpoints)
+
+	provider.lock.Lock()
+	if err == nil {
+		provider.keys = keys
+	} else if provider.keysFetchedAt.After(fetchedAt) {
+		// Failed fetches are retried on the next login.
+		provider.keysFetchedAt = fetchedAt
+	}
+	provider.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, exist = keys[keyId]
+	if !exist {
+		return nil, NewAPIError(401, fmt.Sprintf("Unknown ID token signing key '%s'", keyId), nil)
+	}
+
+	return key, nil
+}
+
+func (provider *OIDCProvider) fetchKeys(endpoints *oidcEndpoints) (map[string]*rsa.PublicKey, error) {
+	request, err := http.NewRequest("GET", endpoints.JWKSURI, nil)
+	if err != nil {
+		return nil, NewAPIError(500, "Failed to create signing keys request", err)
+	}
+
+	var keySet struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyId   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+
+	status, err := provider.doJSON(request, &keySet)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != 200 {
+		return nil, NewAPIError(502, fmt.Sprintf("Fetching identity provider signing keys failed with status %d", status), nil)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range keySet.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+
+		exponent := new(big.Int).SetBytes(e).Int64()
+		keys[jwk.KeyId] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent)}
+	}
+
+	return keys, nil
+}
+
+// Sends the request and decodes the JSON response into value. Returns the
+// status of the response.
+func (provider *OIDCProvider) doJSON(request *http.Request, value interface{}) (int, error) {
+	response, err := provider.client.Do(request)
+	if err != nil {
+		return 0, NewAPIError(502, "Failed to reach identity provider", err)
+	}
+	defer response.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, OIDC_MAX_RESPONSE_SIZE))
+	if err != nil {
+		return 0, NewAPIError(502, "Failed to read identity provider response", err)
+	}
+
+	err = json.Unmarshal(data, value)
+	if err != nil && response.StatusCode == 200 {
+		return 0, NewAPIError(502, "Identity provider returned invalid JSON", err)
+	}
+
+	return response.StatusCode, nil
+}
+
+// Claims of a verified ID token.
+type OIDCClaims map[string]interface{}
+
+func (claims OIDCClaims) validate(issuer string, clientId string, nonce string, now time.Time) error {
+	if claims.String("iss") != issuer {
+		return NewAPIError(401, "ID token has the wrong issuer", nil)
+	}
+
+	if !claims.hasAudience(clientId) {
+		return NewAPIError(401, "ID token has the wrong audience", nil)
+	}
+
+	// Tokens for several audiences must have been issued to this client.
+	_, hasAuthorizedParty := claims["azp"]
+	if (hasAuthorizedParty || len(claims.Strings("aud")) > 1) && claims.String("azp") != clientId {
+		return NewAPIError(401, "ID token has the wrong authorized party", nil)
+	}
+
+	expiresAt, err := claims.time("exp")
+	if err != nil || !now.Before(expiresAt.Add(OIDC_CLOCK_SKEW)) {
+		return NewAPIError(401, "ID token has expired", err)
+	}
+
+	if claims.String("nonce") != nonce {
+		return NewAPIError(401, "ID token has the wrong nonce", nil)
+	}
+
+	if claims.Subject() == "" {
+		return NewAPIError(401, "ID token has no subject", nil)
+	}
+
+	return nil
+}
+
+func (claims OIDCClaims) Subject() string {
+	return claims.String("sub")
+}
+
+// The claim if it is a string, otherwise empty.
+func (claims OIDCClaims) String(name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// The claim if it is a string or an array of strings, otherwise nil.
+func (claims OIDCClaims) Strings(name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if itemString, isString := item.(string); isString {
+				values = append(values, itemString)
+			}
+		}
+		return values
+	}
+
+	return nil
+}
+
+func (claims OIDCClaims) hasAudience(clientId string) bool {
+	for _, audience := range claims.Strings("aud") {
+		if audience == clientId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (claims OIDCClaims) time(name string) (time.Time, error) {
+	number, isNumber := claims[name].(json.Number)
+	if !isNumber {
+		return time.Time{}, fmt.Errorf("Claim '%s' is not a number", name)
+	}
+
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(int64(seconds), 0), nil
+}
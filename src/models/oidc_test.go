@@ -0,0 +1,251 @@
+package models
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	TEST_OIDC_CLIENT_ID     = "hiking-trails"
+	TEST_OIDC_CLIENT_SECRET = "sec ret"
+	TEST_OIDC_CODE          = "authorization-code"
+	TEST_OIDC_KEY_ID        = "key-1"
+)
+
+// Identity provider serving discovery, signing keys and a token endpoint that
+// checks the client credentials and the PKCE code verifier.
+type stubOIDCIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	// Code challenge of the login, from the authorization URL.
+	challenge string
+	// Signs the ID token with another key than the published one.
+	signingKey *rsa.PrivateKey
+	// Changes the claims of the next ID token.
+	changeClaims func(claims map[string]interface{})
+
+	nonce string
+}
+
+func newStubOIDCIssuer(t *testing.T) *stubOIDCIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &stubOIDCIssuer{key: key}
+	issuer.server = httptest.NewServer(http.HandlerFunc(issuer.serveHTTP))
+	return issuer
+}
+
+func (issuer *stubOIDCIssuer) serveHTTP(response http.ResponseWriter, request *http.Request) {
+	switch request.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeStubJSON(response, 200, map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+
+	case "/jwks":
+		exponent := big.NewInt(int64(issuer.key.E)).Bytes()
+		writeStubJSON(response, 200, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": TEST_OIDC_KEY_ID,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(issuer.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(exponent),
+		}}})
+
+	case "/token":
+		issuer.serveToken(response, request)
+
+	default:
+		http.NotFound(response, request)
+	}
+}
+
+func (issuer *stubOIDCIssuer) serveToken(response http.ResponseWriter, request *http.Request) {
+	clientId, clientSecret, _ := request.BasicAuth()
+	clientId, _ = url.QueryUnescape(clientId)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+
+	if clientId != TEST_OIDC_CLIENT_ID || clientSecret != TEST_OIDC_CLIENT_SECRET {
+		writeStubJSON(response, 401, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(request.PostFormValue("code_verifier")))
+	if request.PostFormValue("grant_type") != "authorization_code" || request.PostFormValue("code") != TEST_OIDC_CODE ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != issuer.challenge {
+
+		writeStubJSON(response, 400, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":   issuer.server.URL,
+		"aud":   TEST_OIDC_CLIENT_ID,
+		"sub":   "subject-1",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": issuer.nonce,
+	}
+	if issuer.changeClaims != nil {
+		issuer.changeClaims(claims)
+	}
+
+	writeStubJSON(response, 200, map[string]string{"id_token": issuer.signedToken(claims)})
+}
+
+func (issuer *stubOIDCIssuer) signedToken(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": TEST_OIDC_KEY_ID})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	key := issuer.key
+	if issuer.signingKey != nil {
+		key = issuer.signingKey
+	}
+
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeStubJSON(response http.ResponseWriter, status int, value interface{}) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	json.NewEncoder(response).Encode(value)
+}
+
+// Starts a login at the stub issuer and returns the provider and the code
+// verifier of the login.
+func startStubOIDCLogin(t *testing.T, issuer *stubOIDCIssuer) (*OIDCProvider, string) {
+	provider := NewOIDCProvider(issuer.server.URL, TEST_OIDC_CLIENT_ID, TEST_OIDC_CLIENT_SECRET,
+		"http://localhost/api/v1/oidc/callback")
+
+	codeVerifier := MustGenerateOIDCSecret()
+	issuer.nonce = MustGenerateOIDCSecret()
+
+	authorizationURL, err := provider.AuthorizationURL("state", issuer.nonce, codeVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := parsed.Query()
+	if parsed.Path != "/authorize" || query.Get("client_id") != TEST_OIDC_CLIENT_ID || query.Get("state") != "state" ||
+		query.Get("nonce") != issuer.nonce || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("Authorization URL %s", authorizationURL)
+	}
+
+	issuer.challenge = query.Get("code_challenge")
+	return provider, codeVerifier
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newStubOIDCIssuer(t)
+	defer issuer.server.Close()
+
+	provider, codeVerifier := startStubOIDCLogin(t, issuer)
+
+	claims, err := provider.Exchange(TEST_OIDC_CODE, codeVerifier, issuer.nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject() != "subject-1" {
+		t.Errorf("Logged in with claims %v", claims)
+	}
+}
+
+func TestOIDCLoginRequiresCodeVerifierAndCode(t *testing.T) {
+	issuer := newStubOIDCIssuer(t)
+	defer issuer.server.Close()
+
+	provider, codeVerifier := startStubOIDCLogin(t, issuer)
+
+	for _, exchange := range [][]string{{TEST_OIDC_CODE, MustGenerateOIDCSecret()}, {"other-code", codeVerifier}} {
+		_, err := provider.Exchange(exchange[0], exchange[1], issuer.nonce)
+
+		apiError, isApiError := err.(*APIError)
+		if !isApiError || apiError.Status != 401 || !strings.Contains(apiError.Message, "invalid_grant") {
+			t.Errorf("Exchanged code '%s' and verifier '%s' with error %v", exchange[0], exchange[1], err)
+		}
+	}
+}
+
+func TestOIDCLoginRejectsInvalidIDTokens(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		changeClaims func(claims map[string]interface{})
+		signingKey   *rsa.PrivateKey
+		valid        bool
+	}{
+		{"bad signature", nil, otherKey, false},
+		{"wrong audience", func(claims map[string]interface{}) { claims["aud"] = "other-client" }, nil, false},
+		{"wrong issuer", func(claims map[string]interface{}) { claims["iss"] = "https://other.example.com" }, nil, false},
+		{"wrong nonce", func(claims map[string]interface{}) { claims["nonce"] = "other-nonce" }, nil, false},
+		{"expired", func(claims map[string]interface{}) {
+			claims["exp"] = time.Now().Add(-OIDC_CLOCK_SKEW - time.Minute).Unix()
+		}, nil, false},
+		{"no subject", func(claims map[string]interface{}) { delete(claims, "sub") }, nil, false},
+		{"several audiences", func(claims map[string]interface{}) {
+			claims["aud"] = []string{TEST_OIDC_CLIENT_ID, "other-client"}
+		}, nil, false},
+		{"several audiences for other party", func(claims map[string]interface{}) {
+			claims["aud"] = []string{TEST_OIDC_CLIENT_ID, "other-client"}
+			claims["azp"] = "other-client"
+		}, nil, false},
+		{"other authorized party", func(claims map[string]interface{}) { claims["azp"] = "other-client" }, nil, false},
+		{"several audiences for this party", func(claims map[string]interface{}) {
+			claims["aud"] = []string{TEST_OIDC_CLIENT_ID, "other-client"}
+			claims["azp"] = TEST_OIDC_CLIENT_ID
+		}, nil, true},
+	}
+
+	issuer := newStubOIDCIssuer(t)
+	defer issuer.server.Close()
+
+	for _, test := range tests {
+		provider, codeVerifier := startStubOIDCLogin(t, issuer)
+		issuer.changeClaims = test.changeClaims
+		issuer.signingKey = test.signingKey
+
+		_, err := provider.Exchange(TEST_OIDC_CODE, codeVerifier, issuer.nonce)
+
+		if test.valid && err != nil {
+			t.Errorf("Rejected ID token with %s: %s", test.name, err)
+		}
+
+		if apiError, isApiError := err.(*APIError); !test.valid && (!isApiError || apiError.Status != 401) {
+			t.Errorf("Accepted ID token with %s, error %v", test.name, err)
+		}
+	}
+}
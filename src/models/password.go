@@ -0,0 +1,145 @@
+package models
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Passwords are hashed with Argon2id and stored in the PHC string format,
+// like $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>, so the parameters of
+// each hash are known when verifying it. Hashes from before Argon2id are MD5
+// of the password and a separate salt, and are replaced by Argon2id hashes on
+// the next successful login.
+
+const (
+	PASSWORD_HASH_MD5      = "md5"
+	PASSWORD_HASH_ARGON2ID = "argon2id"
+
+	// Parameters of new hashes, the second recommended option of RFC 9106.
+	ARGON2_TIME       = 3
+	ARGON2_MEMORY_KIB = 64 * 1024
+	ARGON2_THREADS    = 4
+	ARGON2_SALT_BYTES = 16
+	ARGON2_KEY_BYTES  = 32
+)
+
+type argon2Parameters struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+var currentArgon2Parameters = argon2Parameters{ARGON2_MEMORY_KIB, ARGON2_TIME, ARGON2_THREADS}
+
+// Each Argon2id hash takes ARGON2_MEMORY_KIB of memory, so requests hashing
+// passwords reserve one of a limited number of slots first.
+var passwordHashingSlots = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// Reserves a slot for hashing a password and returns the function releasing
+// it. Fails with 503 when all slots are in use.
+func ReservePasswordHashing() (func(), error) {
+	select {
+	case passwordHashingSlots <- struct{}{}:
+		return func() { <-passwordHashingSlots }, nil
+	default:
+		return nil, NewAPIError(503, "Too many passwords are being hashed, try again later", nil)
+	}
+}
+
+// Hash of a random password with the current parameters, verified when a login
+// has no user to verify against.
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+)
+
+// Takes as long as verifying the password of a user, so that failed logins do
+// not reveal whether the username exists.
+func VerifyDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash = hashPasswordArgon2id(base64.RawStdEncoding.EncodeToString(mustGenerateSalt(ARGON2_KEY_BYTES)))
+	})
+
+	isCorrectArgon2idPassword(password, dummyPasswordHash)
+}
+
+func hashPasswordArgon2id(password string) string {
+	salt := mustGenerateSalt(ARGON2_SALT_BYTES)
+	parameters := currentArgon2Parameters
+	key := argon2.IDKey([]byte(password), salt, parameters.time, parameters.memory, parameters.threads, ARGON2_KEY_BYTES)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", PASSWORD_HASH_ARGON2ID, argon2.Version,
+		parameters.memory, parameters.time, parameters.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// Parses a PHC string of an Argon2id hash into its parameters, salt and key.
+func parseArgon2idHash(encoded string) (argon2Parameters, []byte, []byte, error) {
+	var parameters argon2Parameters
+	var version int
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != PASSWORD_HASH_ARGON2ID {
+		return parameters, nil, nil, fmt.Errorf("Invalid Argon2id hash")
+	}
+
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return parameters, nil, nil, fmt.Errorf("Unsupported Argon2 version '%s'", parts[2])
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &parameters.memory, &parameters.time, &parameters.threads)
+	if err != nil {
+		return parameters, nil, nil, fmt.Errorf("Invalid Argon2id parameters '%s': %s", parts[3], err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return parameters, nil, nil, fmt.Errorf("Invalid Argon2id salt: %s", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return parameters, nil, nil, fmt.Errorf("Invalid Argon2id hash: %s", err)
+	}
+
+	return parameters, salt, key, nil
+}
+
+func isCorrectArgon2idPassword(password string, encoded string) bool {
+	parameters, salt, key, err := parseArgon2idHash(encoded)
+	if err != nil {
+		return false
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, parameters.time, parameters.memory, parameters.threads,
+		uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(computed, key) == 1
+}
+
+func hashPasswordMD5(password string, salt []byte) []byte {
+	md5Hash := md5.New()
+	io.WriteString(md5Hash, password)
+	io.WriteString(md5Hash, string(salt))
+	return md5Hash.Sum(nil)
+}
+
+func mustGenerateSalt(size int) []byte {
+	salt := make([]byte, size)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		panic(err)
+	}
+
+	return salt
+}
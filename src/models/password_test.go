@@ -0,0 +1,119 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsesArgon2idHash(t *testing.T) {
+	encoded := hashPasswordArgon2id("correct horse battery staple")
+
+	parameters, salt, key, err := parseArgon2idHash(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parameters != currentArgon2Parameters {
+		t.Errorf("Parsed parameters %+v, expected %+v", parameters, currentArgon2Parameters)
+	}
+	if len(salt) != ARGON2_SALT_BYTES || len(key) != ARGON2_KEY_BYTES {
+		t.Errorf("Parsed %d bytes of salt and %d bytes of key from '%s'", len(salt), len(key), encoded)
+	}
+
+	if !isCorrectArgon2idPassword("correct horse battery staple", encoded) {
+		t.Error("Password does not match its hash")
+	}
+	if isCorrectArgon2idPassword("correct horse battery", encoded) {
+		t.Error("Wrong password matches hash")
+	}
+}
+
+func TestRejectsInvalidArgon2idHashes(t *testing.T) {
+	parts := strings.Split(hashPasswordArgon2id("correct horse battery staple"), "$")
+	withPart := func(index int, part string) string {
+		changed := append([]string{}, parts...)
+		changed[index] = part
+		return strings.Join(changed, "$")
+	}
+
+	hashes := map[string]string{
+		"empty":      "",
+		"parts":      strings.Join(parts[:5], "$"),
+		"algorithm":  withPart(1, "argon2i"),
+		"version":    withPart(2, "v=16"),
+		"parameters": withPart(3, "m=65536,t=3"),
+		"salt":       withPart(4, "not base64!"),
+		"key":        withPart(5, "not base64!"),
+	}
+
+	for name, encoded := range hashes {
+		_, _, _, err := parseArgon2idHash(encoded)
+		if err == nil {
+			t.Errorf("Parsed hash with invalid %s '%s'", name, encoded)
+		}
+
+		if isCorrectArgon2idPassword("correct horse battery staple", encoded) {
+			t.Errorf("Password matches hash with invalid %s", name)
+		}
+	}
+}
+
+func TestRehashesMD5Passwords(t *testing.T) {
+	salt := mustGenerateSalt(16)
+	user := &User{hashAlgorithm: PASSWORD_HASH_MD5, hashedPassword: hashPasswordMD5("hunter22", salt), salt: salt}
+
+	if !user.IsCorrectPassword("hunter22") || user.IsCorrectPassword("hunter23") {
+		t.Fatal("MD5 hash does not match only its password")
+	}
+	if !user.NeedsPasswordRehash() {
+		t.Error("MD5 hash does not need rehashing")
+	}
+
+	user.SetPassword("hunter22")
+
+	if user.hashAlgorithm != PASSWORD_HASH_ARGON2ID || user.salt != nil {
+		t.Errorf("Rehashed password has algorithm '%s' and salt %v", user.hashAlgorithm, user.salt)
+	}
+	if !user.IsCorrectPassword("hunter22") || user.IsCorrectPassword("hunter23") {
+		t.Error("Argon2id hash does not match only its password")
+	}
+	if user.NeedsPasswordRehash() {
+		t.Error("Argon2id hash with current parameters needs rehashing")
+	}
+
+	// Hashes with other parameters are rehashed with the current ones.
+	parts := strings.Split(string(user.hashedPassword), "$")
+	parts[3] = "m=19456,t=2,p=1"
+	user.hashedPassword = []byte(strings.Join(parts, "$"))
+
+	if !user.NeedsPasswordRehash() {
+		t.Error("Argon2id hash with old parameters does not need rehashing")
+	}
+}
+
+func TestLimitsConcurrentPasswordHashing(t *testing.T) {
+	releases := make([]func(), 0, cap(passwordHashingSlots))
+	for i := 0; i < cap(passwordHashingSlots); i++ {
+		release, err := ReservePasswordHashing()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		releases = append(releases, release)
+	}
+
+	_, err := ReservePasswordHashing()
+	if apiError, isAPIError := err.(*APIError); !isAPIError || apiError.Status != 503 {
+		t.Errorf("Reserving beyond the limit failed with %v, expected 503", err)
+	}
+
+	for _, release := range releases {
+		release()
+	}
+
+	release, err := ReservePasswordHashing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"github.com/martini-contrib/binding"
 	"net/http"
@@ -11,21 +12,29 @@ import (
 // id (int) Path id.
 // name (string) The name of the path.
 // info (string) Description of the path.
-// length (string) Path length in km.
-// polyline (array) Path as an array of geo coordinates objects with lat and lng.
-// duration (string) Path hiking time in hours.
+// length (string) Path length in km, as displayed. Optional override of lengthMeters.
+// lengthMeters (number) Path length in meters computed from the polyline.
+// polyline (array) Path as an array of geo coordinates objects with lat, lng and optional alt.
+// encodedPolyline (string) Path in the encoded polyline algorithm format. Alternative to polyline, without altitudes.
+// duration (string) Path hiking time in hours, as displayed. Optional override of durationSeconds.
+// durationSeconds (int) Estimated hiking time in seconds computed from the polyline.
+// difficulty (string) Either easy, moderate or hard. Empty if unknown.
 // image (string) URL to an image describing the trail.
 
 type Path struct {
-	Id       int64          `json:"id"`
-	Name     string         `json:"name"       binding:"required"`
-	Info     string         `json:"info"`
-	Length   string         `json:"length"`
-	Polyline GEOCoordinates `json:"polyline"`
-	Duration string         `json:"duration"`
-	Places   []*Place       `json:"places"`
-	ImageURL string         `json:"image"`
-	BundleId int64          `json:"bundleId,omitempty"`
+	Id              int64          `json:"id"`
+	Name            string         `json:"name"       binding:"required"`
+	Info            string         `json:"info"`
+	Length          string         `json:"length"`
+	LengthMeters    float64        `json:"lengthMeters"`
+	Polyline        GEOCoordinates `json:"polyline"`
+	EncodedPolyline string         `json:"encodedPolyline,omitempty"`
+	Duration        string         `json:"duration"`
+	DurationSeconds int64          `json:"durationSeconds"`
+	Difficulty      string         `json:"difficulty"`
+	Places          []*Place       `json:"places"`
+	ImageURL        string         `json:"image"`
+	BundleId        int64          `json:"bundleId,omitempty"`
 }
 
 func NewPath() *Path {
@@ -36,6 +45,43 @@ func NewPath() *Path {
 	return path
 }
 
+// Path without methods, used to decode the regular JSON representation
+// without recursing into UnmarshalJSON.
+type jsonPath Path
+
+// Accepts both the regular JSON representation and GeoJSON. The polyline may
+// be given either as polyline or as encodedPolyline.
+func (path *Path) UnmarshalJSON(data []byte) error {
+	if geoJSONType(data) != "" {
+		return path.decodeGeoJSON(data)
+	}
+
+	err := json.Unmarshal(data, (*jsonPath)(path))
+	if err != nil || path.EncodedPolyline == "" {
+		return err
+	}
+
+	if len(path.Polyline) > 0 {
+		return fmt.Errorf("Only one of polyline and encodedPolyline may be given")
+	}
+
+	path.Polyline, err = DecodePolyline(path.EncodedPolyline)
+	path.EncodedPolyline = ""
+	return err
+}
+
+// Paths with an encoded polyline are written without the polyline array.
+func (path Path) MarshalJSON() ([]byte, error) {
+	if path.EncodedPolyline == "" {
+		return json.Marshal(jsonPath(path))
+	}
+
+	return json.Marshal(struct {
+		jsonPath
+		Polyline GEOCoordinates `json:"polyline,omitempty"`
+	}{jsonPath: jsonPath(path)})
+}
+
 // The martini binding plugin does not use pointer targets. Therefore define
 // validate method on struct.
 func (path Path) Validate(errors binding.Errors, req *http.Request) binding.Errors {
@@ -46,9 +92,25 @@ func (path Path) Validate(errors binding.Errors, req *http.Request) binding.Erro
 	validateStringLength("image", path.ImageURL, 0, 255, &errors)
 	validateStringLength("duration", path.Duration, 0, 255, &errors)
 
+	if path.Difficulty != "" && !IsPathDifficulty(path.Difficulty) {
+		errors = append(errors, NewBindingValueError("difficulty", PATH_DIFFICULTIES))
+	}
+
 	return errors
 }
 
+var PATH_DIFFICULTIES = []string{"easy", "moderate", "hard"}
+
+func IsPathDifficulty(value string) bool {
+	for _, difficulty := range PATH_DIFFICULTIES {
+		if value == difficulty {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (path *Path) Type() string {
 	return "path"
 }
@@ -61,27 +123,54 @@ func (path *Path) RequireTransaction() bool {
 	return true
 }
 
+// Computes length and estimated duration from the polyline. Values sent by
+// clients are always replaced, so they never drift from the polyline.
+func (path *Path) ComputeLengthAndDuration() {
+	path.LengthMeters = path.Polyline.Length()
+	ascent := path.Polyline.ElevationProfile().Ascent
+	path.DurationSeconds = int64(WalkingSpeed.EstimateDuration(path.LengthMeters, ascent).Seconds())
+}
+
+// Column values of the path, with the bounding box of the polyline.
+func (path *Path) rowValues() *rowValues {
+	row := &rowValues{}
+	row.add("name", path.Name)
+	row.add("info", path.Info)
+	row.add("length", path.Length)
+	row.add("length_meters", path.LengthMeters)
+	row.add("polyline", path.Polyline.AsBytes())
+	row.add("duration", path.Duration)
+	row.add("duration_seconds", path.DurationSeconds)
+	row.add("difficulty", path.Difficulty)
+	row.add("image_url", path.ImageURL)
+	row.add("bundle_id", path.BundleId)
+	row.addBoundingBox(path.Polyline.BoundingBox())
+	row.addGeometry(path.Polyline)
+
+	return row
+}
+
 func (path *Path) Save(execer SQLExecer) error {
-	result, err := execer.Exec("INSERT INTO paths(name, info, length, polyline, duration, image_url, bundle_id) VALUES(?,?,?,?,?,?,?)",
-		path.Name,
-		path.Info,
-		path.Length,
-		path.Polyline.AsBytes(),
-		path.Duration,
-		path.ImageURL,
-		path.BundleId)
+	_, err := path.Polyline.FillMissingAltitudes()
+	if err != nil {
+		return NewAPIError(500, "Failed to look up elevation when saving path", err)
+	}
 
+	path.ComputeLengthAndDuration()
+
+	row := path.rowValues()
+	id, err := Dialect.insert(execer, row.insertStatement("paths"), row.arguments...)
 	if err != nil {
 		return NewAPIError(500, "Failed to create path", err)
 	}
 
-	lastInsertedId, err := result.LastInsertId()
+	path.Id = id
+
+	err = path.saveGeneralizedPolylines(execer)
 	if err != nil {
-		return NewAPIError(500, "Failed to retrieve last inserted id when saving path", err)
+		return err
 	}
 
-	path.Id = lastInsertedId
-
 	for _, place := range path.Places {
 		place.PathId = path.Id
 
@@ -98,8 +187,8 @@ func (path *Path) Save(execer SQLExecer) error {
 func (path *Path) Load(queryer SQLQueryer) error {
 	polylineData := make([]byte, 0)
 
-	err := queryer.QueryRow("SELECT id, name, info, length, polyline, duration, image_url, bundle_id FROM paths WHERE id=?", path.Id).
-		Scan(&path.Id, &path.Name, &path.Info, &path.Length, &polylineData, &path.Duration, &path.ImageURL, &path.BundleId)
+	err := queryer.QueryRow("SELECT id, name, info, length, length_meters, polyline, duration, duration_seconds, difficulty, image_url, bundle_id FROM paths WHERE id=?", path.Id).
+		Scan(&path.Id, &path.Name, &path.Info, &path.Length, &path.LengthMeters, &polylineData, &path.Duration, &path.DurationSeconds, &path.Difficulty, &path.ImageURL, &path.BundleId)
 
 	if err == sql.ErrNoRows {
 		return NewAPIError(404, fmt.Sprintf("No path with id %d exist", path.Id), nil)
@@ -114,8 +203,7 @@ func (path *Path) Load(queryer SQLQueryer) error {
 
 	path.Polyline = polyline
 
-	filter := map[string]interface{}{"path_id": path.Id}
-	places, err := LoadPlaces(queryer, filter)
+	places, err := LoadPlaces(queryer, &PlaceFilter{PathIds: []int64{path.Id}})
 	if err != nil {
 		return err
 	}
@@ -126,14 +214,15 @@ func (path *Path) Load(queryer SQLQueryer) error {
 }
 
 func (path *Path) Update(execer SQLExecer) error {
-	result, err := execer.Exec("UPDATE paths SET name=?, info=?, length=?, polyline=?, duration=?, image_url=?, bundle_id=? WHERE id=?",
-		path.Name,
-		path.Info,
-		path.Length,
-		path.Polyline.AsBytes(),
-		path.Duration, path.ImageURL,
-		path.BundleId,
-		path.Id)
+	_, err := path.Polyline.FillMissingAltitudes()
+	if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to look up elevation when updating path with id %d", path.Id), err)
+	}
+
+	path.ComputeLengthAndDuration()
+
+	row := path.rowValues()
+	result, err := execer.Exec(row.updateStatement("paths", "id=?"), append(row.arguments, path.Id)...)
 
 	if err != nil {
 		return NewAPIError(500, fmt.Sprintf("Failed to update path with id %d", path.Id), err)
@@ -148,7 +237,7 @@ func (path *Path) Update(execer SQLExecer) error {
 		return NewAPIError(404, fmt.Sprintf("No path with id %d exist", path.Id), nil)
 	}
 
-	return nil
+	return path.saveGeneralizedPolylines(execer)
 }
 
 func (path *Path) Delete(execer SQLExecer) error {
@@ -156,17 +245,114 @@ func (path *Path) Delete(execer SQLExecer) error {
 }
 
 func LoadPathsFromDatabase(transaction SQLQueryer, bundleId int64) ([]*Path, error) {
-	var rows *sql.Rows
-	var err error
-	paths := make([]*Path, 0)
+	return loadPaths(transaction, nil, (&PathFilter{BundleId: bundleId}).conditions())
+}
+
+// Filter of paths. Zero values do not restrict the paths.
+type PathFilter struct {
+	BundleId     int64
+	MinLength    float64 // Meters
+	MaxLength    float64 // Meters
+	MaxDuration  int64   // Seconds
+	Difficulties []string
+	// Paths with a place whose name contains PlaceName.
+	PlaceName string
+	// Paths that intersect the area.
+	Area *GEOArea
+}
+
+func (filter *PathFilter) conditions() *queryConditions {
+	query := &queryConditions{}
+
+	if filter.BundleId != 0 {
+		query.add("bundle_id=?", filter.BundleId)
+	}
+	if filter.MinLength > 0 {
+		query.add("length_meters>=?", filter.MinLength)
+	}
+	if filter.MaxLength > 0 {
+		query.add("length_meters<=?", filter.MaxLength)
+	}
+	if filter.MaxDuration > 0 {
+		query.add("duration_seconds<=?", filter.MaxDuration)
+	}
+	if len(filter.Difficulties) > 0 {
+		difficulties := make([]interface{}, 0, len(filter.Difficulties))
+		for _, difficulty := range filter.Difficulties {
+			difficulties = append(difficulties, difficulty)
+		}
+
+		query.addIn("difficulty", difficulties)
+	}
+	if filter.PlaceName != "" {
+		query.add(`id IN (SELECT path_id FROM places WHERE LOWER(name) LIKE LOWER(?) ESCAPE '\')`, containsPattern(filter.PlaceName))
+	}
+	if filter.Area != nil {
+		condition, arguments := Dialect.boundingBoxCondition("paths", filter.Area.BoundingBox)
+		query.add(condition, arguments...)
+	}
 
-	if bundleId == 0 {
-		rows, err = transaction.Query("SELECT id, name, info, length, polyline, duration, image_url, bundle_id FROM paths")
-	} else {
-		rows, err = transaction.Query("SELECT id, name, info, length, polyline, duration, image_url, bundle_id FROM paths WHERE bundle_id=?", bundleId)
+	return query
+}
+
+// Loads a page of the paths matching the filter and returns it together with
+// the total number of matching paths.
+func ListPaths(transaction SQLQueryer, filter *PathFilter, options *ListOptions) ([]*Path, int64, error) {
+	query := filter.conditions()
+
+	if filter.Area == nil || filter.Area.Center == nil {
+		total, err := countRows(transaction, "paths", query.condition(), query.arguments...)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		paths, err := loadPaths(transaction, options, query)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return paths, total, nil
+	}
 
+	// Circles are refined after the query, so the page is taken in memory.
+	// The refinement needs the polylines.
+	queryOptions := options.withoutPaging()
+	if queryOptions != nil {
+		queryOptions.WithoutPolylines = false
 	}
 
+	paths, err := loadPaths(transaction, queryOptions, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pathsInArea := make([]*Path, 0, len(paths))
+	for _, path := range paths {
+		if filter.Area.IntersectsPolyline(path.Polyline) {
+			pathsInArea = append(pathsInArea, path)
+		}
+	}
+
+	start, end := options.page(len(pathsInArea))
+	return pathsInArea[start:end], int64(len(pathsInArea)), nil
+}
+
+func loadPaths(transaction SQLQueryer, options *ListOptions, query *queryConditions) ([]*Path, error) {
+	paths := make([]*Path, 0)
+
+	polylineColumn := "polyline"
+	if !options.loadPolylines() {
+		polylineColumn = "NULL"
+	}
+
+	queryStatement := selectStatement("id, name, info, length, length_meters, "+polylineColumn+", duration, duration_seconds, difficulty, image_url, bundle_id",
+		"paths", query.condition())
+
+	limit, limitArguments := options.limit()
+	queryStatement += options.orderBy() + limit
+	arguments := append(query.arguments, limitArguments...)
+
+	rows, err := transaction.Query(queryStatement, arguments...)
 	if err != nil {
 		return nil, NewAPIError(500, "Failed to load paths from database", err)
 	}
@@ -176,7 +362,7 @@ func LoadPathsFromDatabase(transaction SQLQueryer, bundleId int64) ([]*Path, err
 		path := &Path{}
 		polylineData := make([]byte, 0)
 
-		err = rows.Scan(&path.Id, &path.Name, &path.Info, &path.Length, &polylineData, &path.Duration, &path.ImageURL, &path.BundleId)
+		err = rows.Scan(&path.Id, &path.Name, &path.Info, &path.Length, &path.LengthMeters, &polylineData, &path.Duration, &path.DurationSeconds, &path.Difficulty, &path.ImageURL, &path.BundleId)
 		if err != nil {
 			return nil, err
 		}
@@ -187,14 +373,7 @@ func LoadPathsFromDatabase(transaction SQLQueryer, bundleId int64) ([]*Path, err
 		}
 
 		path.Polyline = polyline
-
-		filter := map[string]interface{}{"path_id": path.Id}
-		places, err := LoadPlaces(transaction, filter)
-		if err != nil {
-			return nil, NewAPIError(500, "Failed to load path from row", err)
-		}
-
-		path.Places = places
+		path.Places = make([]*Place, 0)
 		paths = append(paths, path)
 	}
 
@@ -202,5 +381,42 @@ func LoadPathsFromDatabase(transaction SQLQueryer, bundleId int64) ([]*Path, err
 		return nil, NewAPIError(500, "Failed to load paths from database", err)
 	}
 
+	if options.loadChildren() {
+		err = loadPlacesOfPaths(transaction, paths)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return paths, nil
 }
+
+// Loads the paths of all bundles with one query per chunk of bundles instead
+// of one per bundle.
+func loadPathsOfBundles(transaction SQLQueryer, bundles []*Bundle) error {
+	bundlesById := make(map[int64]*Bundle, len(bundles))
+	ids := make([]int64, 0, len(bundles))
+
+	for _, bundle := range bundles {
+		bundle.Paths = make([]*Path, 0)
+		bundlesById[bundle.Id] = bundle
+		ids = append(ids, bundle.Id)
+	}
+
+	return queryInChunks(ids, func(chunk []int64) error {
+		query := &queryConditions{}
+		query.addIn("bundle_id", idValues(chunk))
+
+		paths, err := loadPaths(transaction, nil, query)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range paths {
+			bundle := bundlesById[path.BundleId]
+			bundle.Paths = append(bundle.Paths, path)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,241 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/martini-contrib/binding"
+	"net/http"
+	"strings"
+)
+
+// Users have a global role, and may additionally have a role for single
+// bundles. Viewers can read everything, editors can also create bundles, which
+// they become owner of, and administrators can do anything. Bundle editors can
+// change a bundle and its paths and places, and bundle owners can also delete
+// the bundle and grant others permissions for it.
+
+const (
+	ROLE_VIEWER        = "viewer"
+	ROLE_EDITOR        = "editor"
+	ROLE_ADMINISTRATOR = "administrator"
+
+	BUNDLE_ROLE_EDITOR = "editor"
+	BUNDLE_ROLE_OWNER  = "owner"
+)
+
+// Global roles from least to most privileged.
+var ROLES = []string{ROLE_VIEWER, ROLE_EDITOR, ROLE_ADMINISTRATOR}
+
+var BUNDLE_ROLES = []string{BUNDLE_ROLE_EDITOR, BUNDLE_ROLE_OWNER}
+
+type Permission string
+
+const (
+	PERMISSION_VIEW Permission = "view"
+	PERMISSION_EDIT Permission = "edit"
+	PERMISSION_OWN  Permission = "own"
+)
+
+func roleRank(role string) int {
+	for rank, candidate := range ROLES {
+		if candidate == role {
+			return rank
+		}
+	}
+
+	return -1
+}
+
+func IsRole(role string) bool {
+	return roleRank(role) >= 0
+}
+
+func IsBundleRole(role string) bool {
+	return role == BUNDLE_ROLE_EDITOR || role == BUNDLE_ROLE_OWNER
+}
+
+// Whether the bundle role gives the permission. Everybody may view bundles.
+func bundleRoleAllows(role string, permission Permission) bool {
+	switch permission {
+	case PERMISSION_VIEW:
+		return true
+	case PERMISSION_EDIT:
+		return role == BUNDLE_ROLE_EDITOR || role == BUNDLE_ROLE_OWNER
+	case PERMISSION_OWN:
+		return role == BUNDLE_ROLE_OWNER
+	}
+
+	return false
+}
+
+// Returns a 403 error unless the user has the permission for the bundle,
+// either through the administrator role or a bundle role.
+func AuthorizeBundle(queryer SQLQueryer, user *User, bundleId int64, permission Permission) error {
+	if user.HasRole(ROLE_ADMINISTRATOR) {
+		return nil
+	}
+
+	role, err := BundleRoleOf(queryer, bundleId, user.Id)
+	if err != nil {
+		return err
+	}
+
+	if !bundleRoleAllows(role, permission) {
+		return NewAPIError(403, fmt.Sprintf("Not allowed to %s bundle with id %d", permission, bundleId), nil)
+	}
+
+	return nil
+}
+
+// The role of the user for the bundle, or an empty string if the user has no
+// role for it.
+func BundleRoleOf(queryer SQLQueryer, bundleId int64, userId int64) (string, error) {
+	var role string
+
+	err := queryer.QueryRow("SELECT role FROM bundle_permissions WHERE bundle_id=? AND user_id=?", bundleId, userId).
+		Scan(&role)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", NewAPIError(500, fmt.Sprintf("Failed to load permission for bundle with id %d", bundleId), err)
+	}
+
+	return role, nil
+}
+
+// Returns the id, or a 404 error if no bundle with the id exist.
+func BundleIdOfBundle(queryer SQLQueryer, id int64) (int64, error) {
+	count, err := countRows(queryer, "bundles", "id=?", id)
+	if err != nil {
+		return 0, err
+	}
+
+	if count == 0 {
+		return 0, NewAPIError(404, fmt.Sprintf("No bundle with id %d exist", id), nil)
+	}
+
+	return id, nil
+}
+
+func BundleIdOfPath(queryer SQLQueryer, pathId int64) (int64, error) {
+	var bundleId int64
+
+	err := queryer.QueryRow("SELECT bundle_id FROM paths WHERE id=?", pathId).Scan(&bundleId)
+
+	if err == sql.ErrNoRows {
+		return 0, NewAPIError(404, fmt.Sprintf("No path with id %d exist", pathId), nil)
+	} else if err != nil {
+		return 0, NewAPIError(500, fmt.Sprintf("Failed to load path with id %d", pathId), err)
+	}
+
+	return bundleId, nil
+}
+
+func BundleIdOfPlace(queryer SQLQueryer, placeId int64) (int64, error) {
+	var bundleId int64
+
+	err := queryer.QueryRow("SELECT paths.bundle_id FROM places JOIN paths ON paths.id=places.path_id WHERE places.id=?", placeId).
+		Scan(&bundleId)
+
+	if err == sql.ErrNoRows {
+		return 0, NewAPIError(404, fmt.Sprintf("No place with id %d exist", placeId), nil)
+	} else if err != nil {
+		return 0, NewAPIError(500, fmt.Sprintf("Failed to load place with id %d", placeId), err)
+	}
+
+	return bundleId, nil
+}
+
+// userId (int) Id of the user with the role.
+// username (string) Username of the user, not used when granting.
+// role (string) Role of the user for the bundle, editor or owner.
+
+type BundlePermission struct {
+	BundleId int64  `json:"bundleId"`
+	UserId   int64  `json:"userId"`
+	Username string `json:"username"`
+	Role     string `json:"role"     binding:"required"`
+}
+
+func (permission BundlePermission) Validate(errors binding.Errors, req *http.Request) binding.Errors {
+	if permission.Role != "" && !IsBundleRole(permission.Role) {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"role"},
+			Classification: "ComplaintError",
+			Message:        fmt.Sprintf("Role must be one of %s", strings.Join(BUNDLE_ROLES, ", ")),
+		})
+	}
+
+	return errors
+}
+
+// Grants the role for the bundle to the user, replacing any role the user had
+// for it.
+func (permission *BundlePermission) Grant(execer SQLExecer) error {
+	result, err := execer.Exec("UPDATE bundle_permissions SET role=? WHERE bundle_id=? AND user_id=?",
+		permission.Role, permission.BundleId, permission.UserId)
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected == 0 {
+		_, err = execer.Exec("INSERT INTO bundle_permissions(bundle_id, user_id, role) VALUES(?,?,?)",
+			permission.BundleId, permission.UserId, permission.Role)
+	}
+
+	if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to grant permission for bundle with id %d", permission.BundleId), err)
+	}
+
+	return nil
+}
+
+func RevokeBundlePermission(execer SQLExecer, bundleId int64, userId int64) error {
+	result, err := execer.Exec("DELETE FROM bundle_permissions WHERE bundle_id=? AND user_id=?", bundleId, userId)
+	if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to revoke permission for bundle with id %d", bundleId), err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to revoke permission for bundle with id %d", bundleId), err)
+	}
+
+	if rowsAffected == 0 {
+		return NewAPIError(404, fmt.Sprintf("User with id %d has no permission for bundle with id %d", userId, bundleId), nil)
+	}
+
+	return nil
+}
+
+func ListBundlePermissions(queryer SQLQueryer, bundleId int64) ([]*BundlePermission, error) {
+	rows, err := queryer.Query(`SELECT bundle_permissions.bundle_id, bundle_permissions.user_id, users.username, bundle_permissions.role
+	                            FROM bundle_permissions JOIN users ON users.id=bundle_permissions.user_id
+	                            WHERE bundle_permissions.bundle_id=? ORDER BY users.username`, bundleId)
+	if err != nil {
+		return nil, NewAPIError(500, fmt.Sprintf("Failed to load permissions for bundle with id %d", bundleId), err)
+	}
+	defer rows.Close()
+
+	permissions := make([]*BundlePermission, 0)
+	for rows.Next() {
+		permission := &BundlePermission{}
+
+		err = rows.Scan(&permission.BundleId, &permission.UserId, &permission.Username, &permission.Role)
+		if err != nil {
+			return nil, NewAPIError(500, "Failed to load bundle permission from row", err)
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, NewAPIError(500, fmt.Sprintf("Failed to load permissions for bundle with id %d", bundleId), err)
+	}
+
+	return permissions, nil
+}
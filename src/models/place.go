@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"github.com/martini-contrib/binding"
 	"net/http"
@@ -11,7 +12,7 @@ import (
 // info (string) Place description.
 // image (string) URL to image asset of the place.
 // radius (int) Radius of place marker.
-// position (object) Geo coordinates object with lat and lng properties.
+// position (object) Geo coordinates object with lat, lng and optional alt properties.
 // media (array) Array of additional media objects.
 
 type Place struct {
@@ -31,6 +32,28 @@ func NewPlace() *Place {
 	return place
 }
 
+// Place without methods, used to decode the regular JSON representation
+// without recursing into UnmarshalJSON.
+type jsonPlace Place
+
+// Accepts both the regular JSON representation and a GeoJSON Point feature.
+func (place *Place) UnmarshalJSON(data []byte) error {
+	switch geoJSONType(data) {
+	case "Feature":
+		feature, err := decodeGeoJSONObject(data)
+		if err != nil {
+			return err
+		}
+
+		return place.decodeGeoJSONFeature(feature)
+
+	case "FeatureCollection":
+		return fmt.Errorf("GeoJSON place must be a single Point feature")
+	}
+
+	return json.Unmarshal(data, (*jsonPlace)(place))
+}
+
 // The martini binding plugin does not use pointer targets. Therefore define
 // validate method on struct.
 func (place Place) Validate(errors binding.Errors, req *http.Request) binding.Errors {
@@ -43,7 +66,7 @@ func (place Place) Validate(errors binding.Errors, req *http.Request) binding.Er
 
 func validateStringLength(field string, value string, min int, max int, errors *binding.Errors) {
 	if len(value) < min || len(value) > max {
-		*errors = append(*errors, NewBindingRangeError("name", min, max))
+		*errors = append(*errors, NewBindingRangeError(field, min, max))
 	}
 }
 
@@ -59,29 +82,38 @@ func (place *Place) RequireTransaction() bool {
 	return false
 }
 
-func (place *Place) Save(execer SQLExecer) error {
-	result, err := execer.Exec("INSERT INTO places(name, info, radius, position, path_id) VALUES(?,?,?,?,?)",
-		place.Name,
-		place.Info,
-		place.Radius,
-		place.Position.AsBytes(),
-		place.PathId)
+func (place *Place) rowValues() *rowValues {
+	row := &rowValues{}
+	row.add("name", place.Name)
+	row.add("info", place.Info)
+	row.add("radius", place.Radius)
+	row.add("position", place.Position.AsBytes())
+	row.add("path_id", place.PathId)
+	row.add("latitude", place.Position.Latitude)
+	row.add("longitude", place.Position.Longitude)
+	row.addGeometry(GEOCoordinates{place.Position})
+
+	return row
+}
 
+func (place *Place) Save(execer SQLExecer) error {
+	_, err := place.Position.FillMissingAltitude()
 	if err != nil {
-		return NewAPIError(500, "Failed to create place", err)
+		return NewAPIError(500, "Failed to look up elevation when saving place", err)
 	}
 
-	lastInsertedId, err := result.LastInsertId()
+	row := place.rowValues()
+	id, err := Dialect.insert(execer, row.insertStatement("places"), row.arguments...)
 	if err != nil {
-		return fmt.Errorf("Failed to retrieve last inserted id: %s", err)
+		return NewAPIError(500, "Failed to create place", err)
 	}
 
-	place.Id = lastInsertedId
+	place.Id = id
 	return nil
 }
 
 func (place *Place) Load(queryer SQLQueryer) error {
-	positionData := make([]byte, 0, 4*2)
+	positionData := make([]byte, 0, GEO_COORDINATES_HEADER_SIZE+GEO_COORDINATE_SIZE)
 
 	err := queryer.QueryRow("SELECT id, name, info, radius, position, path_id FROM places WHERE id=?", place.Id).
 		Scan(&place.Id, &place.Name, &place.Info, &place.Radius, &positionData, &place.PathId)
@@ -102,8 +134,13 @@ func (place *Place) Load(queryer SQLQueryer) error {
 }
 
 func (place *Place) Update(execer SQLExecer) error {
-	result, err := execer.Exec("UPDATE places SET name=?, info=?, radius=?, position=?, path_id=? WHERE id=?",
-		place.Name, place.Info, place.Radius, place.Position.AsBytes(), place.PathId, place.Id)
+	_, err := place.Position.FillMissingAltitude()
+	if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to look up elevation when updating place with id %d", place.Id), err)
+	}
+
+	row := place.rowValues()
+	result, err := execer.Exec(row.updateStatement("places", "id=?"), append(row.arguments, place.Id)...)
 
 	if err != nil {
 		return NewAPIError(500, fmt.Sprintf("Failed to update place with id %d", place.Id), err)
@@ -125,47 +162,131 @@ func (place *Place) Delete(execer SQLExecer) error {
 	return nil
 }
 
-func LoadPlaces(queryer SQLQueryer, filter map[string]interface{}) ([]*Place, error) {
+// Filter of places. Zero values do not restrict the places.
+type PlaceFilter struct {
+	PathIds []int64
+	Area    *GEOArea
+}
+
+func (filter *PlaceFilter) conditions() *queryConditions {
+	query := &queryConditions{}
+
+	if len(filter.PathIds) > 0 {
+		query.addIn("path_id", idValues(filter.PathIds))
+	}
+	if filter.Area != nil {
+		condition, arguments := Dialect.boundingBoxCondition("places", filter.Area.BoundingBox)
+		query.add(condition, arguments...)
+	}
+
+	return query
+}
+
+func LoadPlaces(queryer SQLQueryer, filter *PlaceFilter) ([]*Place, error) {
+	places, _, err := loadPlaces(queryer, filter, nil, false)
+	return places, err
+}
+
+// Loads the places of all paths with one query per chunk of paths instead of
+// one per path.
+func loadPlacesOfPaths(queryer SQLQueryer, paths []*Path) error {
+	pathsById := make(map[int64]*Path, len(paths))
+	ids := make([]int64, 0, len(paths))
+
+	for _, path := range paths {
+		path.Places = make([]*Place, 0)
+		pathsById[path.Id] = path
+		ids = append(ids, path.Id)
+	}
+
+	return queryInChunks(ids, func(chunk []int64) error {
+		places, err := LoadPlaces(queryer, &PlaceFilter{PathIds: chunk})
+		if err != nil {
+			return err
+		}
+
+		for _, place := range places {
+			path := pathsById[place.PathId]
+			path.Places = append(path.Places, place)
+		}
+
+		return nil
+	})
+}
+
+// Loads a page of the places matching the filter and returns it together with
+// the total number of matching places.
+func ListPlaces(queryer SQLQueryer, filter *PlaceFilter, options *ListOptions) ([]*Place, int64, error) {
+	return loadPlaces(queryer, filter, options, true)
+}
+
+func loadPlaces(queryer SQLQueryer, filter *PlaceFilter, options *ListOptions,
+	count bool) ([]*Place, int64, error) {
+
 	places := make([]*Place, 0)
-	arguments := make([]interface{}, 0)
-	queryStatement := "SELECT id, name, info, radius, position, path_id FROM places"
+	query := filter.conditions()
+	queryStatement := selectStatement("id, name, info, radius, position, path_id", "places", query.condition())
+
+	// Circles are refined after the query, so the page is taken in memory.
+	refined := filter.Area != nil && filter.Area.Center != nil
+	queryOptions := options
+	if refined {
+		queryOptions = options.withoutPaging()
+	}
 
-	path_id, path_id_in_filter := filter["path_id"]
-	if path_id_in_filter {
-		queryStatement += " WHERE path_id=?"
-		arguments = append(arguments, path_id.(int64))
+	var total int64
+	if count && !refined {
+		var err error
+		total, err = countRows(queryer, "places", query.condition(), query.arguments...)
+		if err != nil {
+			return nil, 0, err
+		}
 	}
 
+	limit, limitArguments := queryOptions.limit()
+	queryStatement += queryOptions.orderBy() + limit
+	arguments := append(query.arguments, limitArguments...)
+
 	rows, err := queryer.Query(queryStatement, arguments...)
 
 	if err != nil {
-		return nil, NewAPIError(500, "Failed to load places", err)
+		return nil, 0, NewAPIError(500, "Failed to load places", err)
 
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		place := NewPlace()
-		positionData := make([]byte, 0, 8)
+		positionData := make([]byte, 0, GEO_COORDINATES_HEADER_SIZE+GEO_COORDINATE_SIZE)
 
 		err := rows.Scan(&place.Id, &place.Name, &place.Info, &place.Radius, &positionData, &place.PathId)
 		if err != nil {
-			return nil, NewAPIError(500, "Failed to load place from row", err)
+			return nil, 0, NewAPIError(500, "Failed to load place from row", err)
 		}
 
 		position, err := GEOCoordinateFromBytes(positionData)
 		if err != nil {
-			return nil, NewAPIError(500, "Failed to load place from row", err)
+			return nil, 0, NewAPIError(500, "Failed to load place from row", err)
 		}
 
 		place.Position = *position
+
+		if filter.Area != nil && !filter.Area.ContainsCoordinate(place.Position) {
+			continue
+		}
+
 		places = append(places, place)
 	}
 
 	err = rows.Err()
 	if err != nil {
-		return nil, NewAPIError(500, "Failed to load places from database", err)
+		return nil, 0, NewAPIError(500, "Failed to load places from database", err)
+	}
+
+	if refined {
+		start, end := options.page(len(places))
+		return places[start:end], int64(len(places)), nil
 	}
 
-	return places, nil
+	return places, total, nil
 }
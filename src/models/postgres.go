@@ -0,0 +1,208 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/lib/pq"
+	"strconv"
+	"strings"
+)
+
+// PostgreSQL with the PostGIS extension. Paths and places have a geom column
+// with their geometry, which is used for spatial queries, while the polyline
+// and position columns keep the exact coordinates including altitudes.
+
+const (
+	// Name of the database/sql driver for PostgreSQL, which accepts the ?
+	// placeholders used by the models.
+	POSTGRES_DRIVER = "postgres-models"
+
+	// WGS 84, the coordinate system of GPS and web maps.
+	GEOMETRY_SRID = 4326
+
+	// Text search document of a bundle, path or place. Names are weighted A and
+	// descriptions D, which ts_rank weights 1.0 and 0.1. The tables have GIN
+	// indexes on this expression, so queries must use it as it is.
+	POSTGRES_SEARCH_DOCUMENT = "(setweight(to_tsvector('simple', COALESCE(name, '')), 'A') || " +
+		"setweight(to_tsvector('simple', COALESCE(info, '')), 'D'))"
+)
+
+func init() {
+	sql.Register(POSTGRES_DRIVER, rebindingDriver{&pq.Driver{}})
+}
+
+type PostgresDialect struct{}
+
+func (dialect PostgresDialect) Name() string {
+	return "postgres"
+}
+
+func (dialect PostgresDialect) insert(execer SQLExecer, statement string, arguments ...interface{}) (int64, error) {
+	queryer, isQueryer := execer.(SQLQueryer)
+	if !isQueryer {
+		return 0, fmt.Errorf("Inserting into PostgreSQL requires a database handle that can query")
+	}
+
+	var id int64
+	err := queryer.QueryRow(statement+" RETURNING id", arguments...).Scan(&id)
+	return id, err
+}
+
+func (dialect PostgresDialect) boundingBoxCondition(table string, box GEOBoundingBox) (string, []interface{}) {
+	condition := fmt.Sprintf("geom && ST_MakeEnvelope(?, ?, ?, ?, %d)", GEOMETRY_SRID)
+	arguments := []interface{}{box.MinLongitude, box.MinLatitude, box.MaxLongitude, box.MaxLatitude}
+
+	return condition, arguments
+}
+
+func (dialect PostgresDialect) geometry(coordinates GEOCoordinates) (string, string, interface{}) {
+	return "geom", fmt.Sprintf("ST_GeomFromText(?, %d)", GEOMETRY_SRID), wktGeometry(coordinates)
+}
+
+// A NULL limit does not limit the number of rows in PostgreSQL.
+func (dialect PostgresDialect) noLimit() interface{} {
+	return nil
+}
+
+func (dialect PostgresDialect) searchQuery(words []string) string {
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, "'"+word+"'")
+	}
+
+	return strings.Join(terms, " & ") + ":*"
+}
+
+// Each table is matched separately with POSTGRES_SEARCH_DOCUMENT, so that the
+// GIN indexes of the tables are used, and only the hits are ranked.
+func (dialect PostgresDialect) searchStatement(query string, limit int) (string, []interface{}) {
+	statement := fmt.Sprintf(`
+	WITH hits AS (
+		SELECT 'bundle' AS kind, id, name, info, 0 AS path_id, 0 AS bundle_id,
+		       CAST(NULL AS REAL) AS latitude, CAST(NULL AS REAL) AS longitude,
+		       CAST(NULL AS DOUBLE PRECISION) AS min_latitude, CAST(NULL AS DOUBLE PRECISION) AS min_longitude,
+		       CAST(NULL AS DOUBLE PRECISION) AS max_latitude, CAST(NULL AS DOUBLE PRECISION) AS max_longitude,
+		       %[1]s AS document
+		FROM bundles
+		WHERE %[1]s @@ to_tsquery('simple', ?)
+		UNION ALL
+		SELECT 'path', id, name, info, 0, bundle_id, NULL, NULL, min_latitude, min_longitude, max_latitude, max_longitude,
+		       %[1]s
+		FROM paths
+		WHERE %[1]s @@ to_tsquery('simple', ?)
+		UNION ALL
+		SELECT 'place', places.id, places.name, places.info, places.path_id, paths.bundle_id,
+		       places.latitude, places.longitude, NULL, NULL, NULL, NULL, places.document
+		FROM (SELECT places.*, %[1]s AS document
+		      FROM places
+		      WHERE %[1]s @@ to_tsquery('simple', ?)) AS places
+		JOIN paths ON paths.id=places.path_id
+	)
+	SELECT kind, id, name,
+	       ts_headline('simple', COALESCE(name, '') || ' ' || COALESCE(info, ''), query,
+	                   'StartSel=%[2]s, StopSel=%[3]s, MaxWords=%[4]d, MinWords=1'),
+	       ts_rank(document, query) AS score,
+	       path_id, bundle_id, latitude, longitude, min_latitude, min_longitude, max_latitude, max_longitude
+	FROM hits, to_tsquery('simple', ?) AS query
+	ORDER BY score DESC
+	LIMIT ?`, POSTGRES_SEARCH_DOCUMENT, SEARCH_MATCH_START, SEARCH_MATCH_END, SNIPPET_TOKENS)
+
+	return statement, []interface{}{query, query, query, query, limit}
+}
+
+// Well-known text of the coordinates as a LINESTRING, or a POINT for a single
+// coordinate. Nil if there are no coordinates.
+func wktGeometry(coordinates GEOCoordinates) interface{} {
+	points := make([]string, 0, len(coordinates))
+	for _, coordinate := range coordinates {
+		points = append(points, strconv.FormatFloat(float64(coordinate.Longitude), 'f', -1, 32)+" "+
+			strconv.FormatFloat(float64(coordinate.Latitude), 'f', -1, 32))
+	}
+
+	switch len(points) {
+	case 0:
+		return nil
+	case 1:
+		return "POINT(" + points[0] + ")"
+	}
+
+	return "LINESTRING(" + strings.Join(points, ", ") + ")"
+}
+
+// Rewrites the ? placeholders of query to the $1, $2, ... placeholders of
+// PostgreSQL. Question marks in string literals are left as they are.
+func rebind(query string) string {
+	rebound := make([]byte, 0, len(query)+16)
+	inLiteral := false
+	count := 0
+
+	for i := 0; i < len(query); i++ {
+		switch {
+		case query[i] == '\'':
+			inLiteral = !inLiteral
+		case query[i] == '?' && !inLiteral:
+			count++
+			rebound = append(rebound, '$')
+			rebound = strconv.AppendInt(rebound, int64(count), 10)
+			continue
+		}
+
+		rebound = append(rebound, query[i])
+	}
+
+	return string(rebound)
+}
+
+// Driver that rewrites placeholders before passing queries on to the wrapped
+// driver.
+type rebindingDriver struct {
+	driver driver.Driver
+}
+
+func (rebinding rebindingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := rebinding.driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return rebindingConn{conn}, nil
+}
+
+type rebindingConn struct {
+	driver.Conn
+}
+
+func (conn rebindingConn) Prepare(query string) (driver.Stmt, error) {
+	return conn.Conn.Prepare(rebind(query))
+}
+
+// Statements without arguments, such as migrations, may contain several
+// commands, which is only supported when they are not prepared.
+func (conn rebindingConn) ExecContext(ctx context.Context, query string, arguments []driver.NamedValue) (driver.Result, error) {
+	execer, isExecer := conn.Conn.(driver.ExecerContext)
+	if !isExecer {
+		return nil, driver.ErrSkip
+	}
+
+	return execer.ExecContext(ctx, rebind(query), arguments)
+}
+
+func (conn rebindingConn) QueryContext(ctx context.Context, query string, arguments []driver.NamedValue) (driver.Rows, error) {
+	queryer, isQueryer := conn.Conn.(driver.QueryerContext)
+	if !isQueryer {
+		return nil, driver.ErrSkip
+	}
+
+	return queryer.QueryContext(ctx, rebind(query), arguments)
+}
+
+func (conn rebindingConn) BeginTx(ctx context.Context, options driver.TxOptions) (driver.Tx, error) {
+	beginner, isBeginner := conn.Conn.(driver.ConnBeginTx)
+	if !isBeginner {
+		return conn.Conn.Begin()
+	}
+
+	return beginner.BeginTx(ctx, options)
+}
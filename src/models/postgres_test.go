@@ -0,0 +1,41 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestRebindsPlaceholders(t *testing.T) {
+	tests := []struct {
+		query, rebound string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT id FROM paths WHERE id=?", "SELECT id FROM paths WHERE id=$1"},
+		{"UPDATE paths SET name=?, info=? WHERE id=?", "UPDATE paths SET name=$1, info=$2 WHERE id=$3"},
+		{"SELECT '?' WHERE name=?", "SELECT '?' WHERE name=$1"},
+		{"SELECT 'it''s ?' WHERE name=? ESCAPE '\\'", "SELECT 'it''s ?' WHERE name=$1 ESCAPE '\\'"},
+	}
+
+	for _, test := range tests {
+		if rebound := rebind(test.query); rebound != test.rebound {
+			t.Errorf("Rebound '%s' to '%s', expected '%s'", test.query, rebound, test.rebound)
+		}
+	}
+}
+
+func TestWKTGeometry(t *testing.T) {
+	tests := []struct {
+		coordinates GEOCoordinates
+		wkt         interface{}
+	}{
+		{GEOCoordinates{}, nil},
+		{GEOCoordinates{NewGEOCoordinate(62.5, 24.25)}, "POINT(24.25 62.5)"},
+		{GEOCoordinates{NewGEOCoordinate(62, 24), NewGEOCoordinateWithAltitude(-33.1, -70.6, 500)},
+			"LINESTRING(24 62, -70.6 -33.1)"},
+	}
+
+	for _, test := range tests {
+		if wkt := wktGeometry(test.coordinates); wkt != test.wkt {
+			t.Errorf("Geometry of %v is %v, expected %v", test.coordinates, wkt, test.wkt)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Conditions of a WHERE clause, joined with AND, and their arguments.
+type queryConditions struct {
+	conditions []string
+	arguments  []interface{}
+}
+
+func (query *queryConditions) add(condition string, arguments ...interface{}) {
+	query.conditions = append(query.conditions, condition)
+	query.arguments = append(query.arguments, arguments...)
+}
+
+// Adds a condition that column is one of values.
+func (query *queryConditions) addIn(column string, values []interface{}) {
+	query.add(fmt.Sprintf("%s IN %s", column, placeholders(len(values))), values...)
+}
+
+func (query *queryConditions) condition() string {
+	return strings.Join(query.conditions, " AND ")
+}
+
+// Columns and values of a row to insert or update. Values are bound to
+// placeholders, which may be wrapped in an SQL expression.
+type rowValues struct {
+	columns      []string
+	placeholders []string
+	arguments    []interface{}
+}
+
+func (row *rowValues) add(column string, value interface{}) {
+	row.addExpression(column, "?", value)
+}
+
+func (row *rowValues) addExpression(column string, placeholder string, value interface{}) {
+	row.columns = append(row.columns, column)
+	row.placeholders = append(row.placeholders, placeholder)
+	row.arguments = append(row.arguments, value)
+}
+
+func (row *rowValues) addBoundingBox(box *GEOBoundingBox) {
+	values := box.columnValues()
+	for i, column := range []string{"min_latitude", "min_longitude", "max_latitude", "max_longitude"} {
+		row.add(column, values[i])
+	}
+}
+
+// Adds the native geometry of the coordinates, if the dialect has one.
+func (row *rowValues) addGeometry(coordinates GEOCoordinates) {
+	column, placeholder, value := Dialect.geometry(coordinates)
+	if column != "" {
+		row.addExpression(column, placeholder, value)
+	}
+}
+
+func (row *rowValues) insertStatement(table string) string {
+	return fmt.Sprintf("INSERT INTO %s(%s) VALUES(%s)", table, strings.Join(row.columns, ", "),
+		strings.Join(row.placeholders, ","))
+}
+
+// UPDATE statement for the row. The arguments of the condition follow the
+// values of the row.
+func (row *rowValues) updateStatement(table string, condition string) string {
+	assignments := make([]string, 0, len(row.columns))
+	for i, column := range row.columns {
+		assignments = append(assignments, column+"="+row.placeholders[i])
+	}
+
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(assignments, ", "), condition)
+}
+
+// Placeholders for count values, such as "(?,?,?)".
+func placeholders(count int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?,", count), ",") + ")"
+}
+
+// Escapes the LIKE wildcards in value and wraps it in wildcards, for use with
+// ESCAPE '\'.
+func containsPattern(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `%`, `\%`, -1)
+	value = strings.Replace(value, `_`, `\_`, -1)
+
+	return "%" + value + "%"
+}
+
+func idValues(ids []int64) []interface{} {
+	values := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, id)
+	}
+
+	return values
+}
+
+func selectStatement(columns string, table string, condition string) string {
+	statement := fmt.Sprintf("SELECT %s FROM %s", columns, table)
+
+	if condition != "" {
+		statement += " WHERE " + condition
+	}
+
+	return statement
+}
+
+func countRows(queryer SQLQueryer, table string, condition string, arguments ...interface{}) (int64, error) {
+	var count int64
+
+	err := queryer.QueryRow(selectStatement("COUNT(*)", table, condition), arguments...).Scan(&count)
+	if err != nil {
+		return 0, NewAPIError(500, fmt.Sprintf("Failed to count %s", table), err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Full text search over names and descriptions of bundles, paths and places.
+// On SQLite the search_index FTS5 table is kept in sync with the bundles,
+// paths and places tables by database triggers. FTS5 requires the application
+// to be built with the sqlite_fts5 build tag. On PostgreSQL the built in text
+// search is used with GIN indexes on the tables.
+
+const (
+	DEFAULT_SEARCH_HITS = 20
+	MAX_SEARCH_HITS     = 100
+
+	// Snippet markers that can not occur in HTML, replaced after escaping.
+	SEARCH_MATCH_START = "\x02"
+	SEARCH_MATCH_END   = "\x03"
+
+	SNIPPET_TOKENS = 12
+
+	// Relative weight of name and info matches when ranking hits.
+	SEARCH_NAME_WEIGHT = 10.0
+	SEARCH_INFO_WEIGHT = 1.0
+)
+
+// kind (string) Either bundle, path or place.
+// id (int) Id of the bundle, path or place.
+// name (string) Name of the bundle, path or place.
+// snippet (string) HTML escaped excerpt where matching terms are enclosed in <mark> elements.
+// score (number) Relevance of the hit. Hits are ordered by descending score.
+// pathId (int) Id of the path of a place.
+// bundleId (int) Id of the bundle of a path or place.
+// position (object) Position of a place.
+// bbox (object) Bounding box of a path.
+
+type SearchHit struct {
+	Kind        string          `json:"kind"`
+	Id          int64           `json:"id"`
+	Name        string          `json:"name"`
+	Snippet     string          `json:"snippet"`
+	Score       float64         `json:"score"`
+	PathId      int64           `json:"pathId,omitempty"`
+	BundleId    int64           `json:"bundleId,omitempty"`
+	Position    *GEOCoordinate  `json:"position,omitempty"`
+	BoundingBox *GEOBoundingBox `json:"bbox,omitempty"`
+}
+
+// Splits free text into the words to search for.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func Search(queryer SQLQueryer, text string, limit int) ([]*SearchHit, error) {
+	hits := make([]*SearchHit, 0)
+
+	words := searchWords(text)
+	if len(words) == 0 {
+		return hits, nil
+	}
+
+	statement, arguments := Dialect.searchStatement(Dialect.searchQuery(words), limit)
+	rows, err := queryer.Query(statement, arguments...)
+
+	if err != nil {
+		return nil, NewAPIError(500, "Failed to search", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hit := &SearchHit{}
+		var latitude, longitude *float32
+		box := [4]*float64{}
+
+		err = rows.Scan(&hit.Kind, &hit.Id, &hit.Name, &hit.Snippet, &hit.Score, &hit.PathId, &hit.BundleId,
+			&latitude, &longitude, &box[0], &box[1], &box[2], &box[3])
+
+		if err != nil {
+			return nil, NewAPIError(500, "Failed to load search hit from row", err)
+		}
+
+		hit.Snippet = highlightSnippet(hit.Snippet)
+
+		if latitude != nil && longitude != nil {
+			position := NewGEOCoordinate(*latitude, *longitude)
+			hit.Position = &position
+		}
+
+		if box[0] != nil && box[1] != nil && box[2] != nil && box[3] != nil {
+			hit.BoundingBox = &GEOBoundingBox{
+				MinLatitude:  *box[0],
+				MinLongitude: *box[1],
+				MaxLatitude:  *box[2],
+				MaxLongitude: *box[3],
+			}
+		}
+
+		hits = append(hits, hit)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, NewAPIError(500, "Failed to search", err)
+	}
+
+	return hits, nil
+}
+
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.Replace(snippet, SEARCH_MATCH_START, "<mark>", -1)
+	return strings.Replace(snippet, SEARCH_MATCH_END, "</mark>", -1)
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"fmt"
+	"math"
+)
+
+// Polylines are generalized with the Douglas-Peucker algorithm. Generalized
+// polylines for the zoom levels in GENERALIZED_ZOOM_LEVELS are precomputed
+// when a path is saved and stored in the path_polylines table, so that lists
+// of paths shown on a zoomed out map stay small.
+
+const (
+	// Meters per pixel at the equator at zoom level 0 of the web mercator
+	// projection used by Google Maps.
+	METERS_PER_PIXEL_AT_ZOOM_0 = 156543.03392
+	MAX_ZOOM_LEVEL             = 22
+)
+
+var GENERALIZED_ZOOM_LEVELS = []int{4, 6, 8, 10, 12, 14}
+
+// Tolerance in meters corresponding to one pixel at the zoom level.
+func ZoomLevelTolerance(zoom int) float64 {
+	return METERS_PER_PIXEL_AT_ZOOM_0 / math.Pow(2, float64(zoom))
+}
+
+// Returns the coarsest precomputed zoom level that is at least as detailed as
+// zoom, or false if the full polyline is needed.
+func generalizedZoomLevelFor(zoom int) (int, bool) {
+	for _, level := range GENERALIZED_ZOOM_LEVELS {
+		if level >= zoom {
+			return level, true
+		}
+	}
+
+	return 0, false
+}
+
+// Returns a polyline where no removed coordinate is further than tolerance
+// meters from the simplified polyline. The first and last coordinates are
+// always kept.
+func (coordinates GEOCoordinates) Simplify(toleranceMeters float64) GEOCoordinates {
+	if len(coordinates) < 3 || toleranceMeters <= 0 {
+		return coordinates
+	}
+
+	keep := make([]bool, len(coordinates))
+	keep[0], keep[len(coordinates)-1] = true, true
+
+	// Iterative to not overflow the stack on tracks with many points.
+	stack := [][2]int{{0, len(coordinates) - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+
+		farthest, maxDistance := -1, toleranceMeters
+		for i := first + 1; i < last; i++ {
+			distance := GEOCoordinates{coordinates[first], coordinates[last]}.DistanceFrom(coordinates[i])
+			if distance > maxDistance {
+				farthest, maxDistance = i, distance
+			}
+		}
+
+		if farthest != -1 {
+			keep[farthest] = true
+			stack = append(stack, [2]int{first, farthest}, [2]int{farthest, last})
+		}
+	}
+
+	simplified := make(GEOCoordinates, 0)
+	for i, coordinate := range coordinates {
+		if keep[i] {
+			simplified = append(simplified, coordinate)
+		}
+	}
+
+	return simplified
+}
+
+func (path *Path) saveGeneralizedPolylines(execer SQLExecer) error {
+	_, err := execer.Exec("DELETE FROM path_polylines WHERE path_id=?", path.Id)
+	if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to delete generalized polylines of path with id %d", path.Id), err)
+	}
+
+	for _, zoom := range GENERALIZED_ZOOM_LEVELS {
+		polyline := path.Polyline.Simplify(ZoomLevelTolerance(zoom))
+
+		_, err = execer.Exec("INSERT INTO path_polylines(path_id, zoom, polyline) VALUES(?,?,?)",
+			path.Id, zoom, polyline.AsBytes())
+
+		if err != nil {
+			return NewAPIError(500, fmt.Sprintf("Failed to save generalized polylines of path with id %d", path.Id), err)
+		}
+	}
+
+	return nil
+}
+
+// Replaces the polylines of the paths with the precomputed polylines for the
+// zoom level.
+func GeneralizePolylines(queryer SQLQueryer, paths []*Path, zoom int) error {
+	level, exist := generalizedZoomLevelFor(zoom)
+	if !exist {
+		return nil
+	}
+
+	pathsById := make(map[int64]*Path, len(paths))
+	ids := make([]int64, 0, len(paths))
+
+	for _, path := range paths {
+		pathsById[path.Id] = path
+		ids = append(ids, path.Id)
+	}
+
+	return queryInChunks(ids, func(chunk []int64) error {
+		query := &queryConditions{}
+		query.add("zoom=?", level)
+		query.addIn("path_id", idValues(chunk))
+
+		rows, err := queryer.Query(selectStatement("path_id, polyline", "path_polylines", query.condition()), query.arguments...)
+
+		if err != nil {
+			return NewAPIError(500, "Failed to load generalized polylines", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var pathId int64
+			polylineData := make([]byte, 0)
+
+			err = rows.Scan(&pathId, &polylineData)
+			if err != nil {
+				return NewAPIError(500, "Failed to load generalized polyline from row", err)
+			}
+
+			polyline, err := GEOCoordinatesFromBytes(polylineData)
+			if err != nil {
+				return NewAPIError(500, "Failed to load generalized polyline from row", err)
+			}
+
+			pathsById[pathId].Polyline = polyline
+		}
+
+		err = rows.Err()
+		if err != nil {
+			return NewAPIError(500, "Failed to load generalized polylines", err)
+		}
+
+		return nil
+	})
+}
+
+func SimplifyPolylines(paths []*Path, toleranceMeters float64) {
+	for _, path := range paths {
+		path.Polyline = path.Polyline.Simplify(toleranceMeters)
+	}
+}
@@ -1,38 +1,76 @@
 package models
 
 import (
-	"crypto/md5"
-	"crypto/rand"
+	"crypto/subtle"
 	"database/sql"
 	"fmt"
-	"io"
+	"github.com/martini-contrib/binding"
+	"net/http"
+	"strings"
 )
 
 const (
-	PASSWORD_SALT_BYTES = 32
-	PASSWORD_HASH_BYTES = 64
+	MIN_PASSWORD_LENGTH = 8
 )
 
 type User struct {
-	Id              int64  `json:"id"`
-	Username        string `json:"username"`
-	Password        string `json:"password,omitempty"`
-	IsAdministrator bool   `json:"isAdministrator"`
-	salt            []byte
-	hashedPassword  []byte
+	Id             int64  `json:"id"`
+	Username       string `json:"username"`
+	Password       string `json:"password,omitempty"`
+	Role           string `json:"role"     binding:"required"`
+	hashAlgorithm  string
+	hashedPassword []byte
+
+	// Only used by MD5 hashes, Argon2id hashes include their salt.
+	salt []byte
+
+	// Subject at the OpenID Connect identity provider, empty for users with a
+	// password.
+	oidcSubject string
 }
 
-func NewUser(username string, password string, isAdministrator bool) *User {
+func NewUser(username string, password string, role string) *User {
 	user := &User{}
 	user.Username = username
-
-	user.salt = mustGenerateSalt()
-	user.hashedPassword = hashPassword(password, user.salt)
-	user.IsAdministrator = isAdministrator
+	user.SetPassword(password)
+	user.Role = role
 
 	return user
 }
 
+// Users of the identity provider have no password, and can only log in
+// through it.
+func NewOIDCUser(username string, subject string, role string) *User {
+	return &User{Username: username, Role: role, oidcSubject: subject}
+}
+
+// The password is only required when creating users. Updates without a
+// password keep the current password.
+func (user User) Validate(errors binding.Errors, req *http.Request) binding.Errors {
+	validateStringLength("username", user.Username, 1, 255, &errors)
+	validatePassword("password", user.Password, &errors)
+
+	if user.Role != "" && !IsRole(user.Role) {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"role"},
+			Classification: "ComplaintError",
+			Message:        fmt.Sprintf("Role must be one of %s", strings.Join(ROLES, ", ")),
+		})
+	}
+
+	return errors
+}
+
+func validatePassword(field string, password string, errors *binding.Errors) {
+	if password != "" && len(password) < MIN_PASSWORD_LENGTH {
+		*errors = append(*errors, binding.Error{
+			FieldNames:     []string{field},
+			Classification: "ComplaintError",
+			Message:        fmt.Sprintf("Password must be at least %d characters", MIN_PASSWORD_LENGTH),
+		})
+	}
+}
+
 func (user *User) Type() string {
 	return "user"
 }
@@ -45,85 +83,235 @@ func (user *User) RequireTransaction() bool {
 	return false
 }
 
+// Whether the role of the user is the role or a more privileged one.
+func (user *User) HasRole(role string) bool {
+	return IsRole(role) && roleRank(user.Role) >= roleRank(role)
+}
+
 func (user *User) IsCorrectPassword(password string) bool {
-	hashedPassword := hashPassword(password, user.salt)
-	return string(user.hashedPassword) == string(hashedPassword)
+	switch user.hashAlgorithm {
+	case PASSWORD_HASH_ARGON2ID:
+		return isCorrectArgon2idPassword(password, string(user.hashedPassword))
+	case PASSWORD_HASH_MD5:
+		hashedPassword := hashPasswordMD5(password, user.salt)
+		return subtle.ConstantTimeCompare(user.hashedPassword, hashedPassword) == 1
+	}
+
+	return false
+}
+
+// Whether the password hash uses an old algorithm or old parameters, and
+// should be replaced while the password is known, after a successful login.
+func (user *User) NeedsPasswordRehash() bool {
+	if user.hashAlgorithm != PASSWORD_HASH_ARGON2ID {
+		return true
+	}
+
+	parameters, _, _, err := parseArgon2idHash(string(user.hashedPassword))
+	return err != nil || parameters != currentArgon2Parameters
+}
+
+// Hashes the password with a new salt. The plain text password is cleared so
+// that it is never rendered.
+func (user *User) SetPassword(password string) {
+	user.hashAlgorithm = PASSWORD_HASH_ARGON2ID
+	user.hashedPassword = []byte(hashPasswordArgon2id(password))
+	user.salt = nil
+	user.Password = ""
 }
 
 func (user *User) Save(execer SQLExecer) error {
-	result, err := execer.Exec("INSERT INTO users(username, salt, hashed_password, is_administrator) VALUES(?,?,?,?)",
+	if user.Password != "" {
+		user.SetPassword(user.Password)
+	}
+
+	if len(user.hashedPassword) == 0 && user.oidcSubject == "" {
+		return NewAPIError(400, "Password is required", nil)
+	}
+
+	var oidcSubject interface{}
+	if user.oidcSubject != "" {
+		oidcSubject = user.oidcSubject
+	}
+
+	id, err := Dialect.insert(execer, "INSERT INTO users(username, hash_algorithm, hashed_password, salt, role, oidc_subject) VALUES(?,?,?,?,?,?)",
 		user.Username,
-		user.salt,
+		user.hashAlgorithm,
 		user.hashedPassword,
-		user.IsAdministrator,
+		user.salt,
+		user.Role,
+		oidcSubject,
 	)
 
 	if err != nil {
 		return NewAPIError(500, "Failed to create user", err)
 	}
 
-	lastInsertedId, err := result.LastInsertId()
-	if err != nil {
-		return fmt.Errorf("Failed to retrieve last inserted id: %s", err)
-	}
-
-	user.Id = lastInsertedId
+	user.Id = id
 	return nil
 }
 
 func (user *User) Load(queryer SQLQueryer) error {
-	panic("Not Implemented")
+	err := queryer.QueryRow("SELECT id, username, hash_algorithm, hashed_password, salt, role FROM users WHERE id=?", user.Id).
+		Scan(&user.Id, &user.Username, &user.hashAlgorithm, &user.hashedPassword, &user.salt, &user.Role)
+
+	if err == sql.ErrNoRows {
+		return NewAPIError(404, fmt.Sprintf("No user with id %d exist", user.Id), nil)
+	} else if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to load user with id %d", user.Id), err)
+	}
+
 	return nil
 }
 
+// Updates username and role, and the password if one is given.
 func (user *User) Update(execer SQLExecer) error {
-	panic("Not Implemented")
+	var result sql.Result
+	var err error
+
+	if user.Password != "" {
+		user.SetPassword(user.Password)
+		result, err = execer.Exec("UPDATE users SET username=?, role=?, hash_algorithm=?, hashed_password=?, salt=? WHERE id=?",
+			user.Username, user.Role, user.hashAlgorithm, user.hashedPassword, user.salt, user.Id)
+	} else {
+		result, err = execer.Exec("UPDATE users SET username=?, role=? WHERE id=?",
+			user.Username, user.Role, user.Id)
+	}
+
+	return checkUserUpdated(user.Id, result, err)
+}
+
+func (user *User) UpdatePassword(execer SQLExecer) error {
+	result, err := execer.Exec("UPDATE users SET hash_algorithm=?, hashed_password=?, salt=? WHERE id=?",
+		user.hashAlgorithm, user.hashedPassword, user.salt, user.Id)
+
+	return checkUserUpdated(user.Id, result, err)
+}
+
+func checkUserUpdated(id int64, result sql.Result, err error) error {
+	if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to update user with id %d", id), err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to update user with id %d", id), err)
+	}
+
+	if rowsAffected == 0 {
+		return NewAPIError(404, fmt.Sprintf("No user with id %d exist", id), nil)
+	}
+
 	return nil
 }
 
+// Deletes the user with the API tokens and bundle permissions of the user, so
+// that none of them is left to a later user with the same id.
 func (user *User) Delete(execer SQLExecer) error {
-	return nil
+	_, err := execer.Exec("DELETE FROM api_tokens WHERE user_id=?", user.Id)
+	if err == nil {
+		_, err = execer.Exec("DELETE FROM bundle_permissions WHERE user_id=?", user.Id)
+	}
+
+	if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to delete API tokens and permissions of user with id %d", user.Id), err)
+	}
+
+	return Delete(user, user.Id, execer)
+}
+
+func DeleteUser(db *sql.DB, id int64) error {
+	transaction, err := db.Begin()
+	if err != nil {
+		return NewAPIError(500, "Failed to begin transaction when deleting user", err)
+	}
+
+	err = (&User{Id: id}).Delete(transaction)
+
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+
+	return transaction.Commit()
 }
 
 func (user *User) LoadFromUsername(username string, queryer SQLQueryer) error {
-	err := queryer.QueryRow("SELECT id, username, salt, hashed_password, is_administrator FROM users WHERE username=?", username).
-		Scan(&user.Id, &user.Username, &user.salt, &user.hashedPassword, &user.IsAdministrator)
+	err := queryer.QueryRow("SELECT id, username, hash_algorithm, hashed_password, salt, role FROM users WHERE username=?", username).
+		Scan(&user.Id, &user.Username, &user.hashAlgorithm, &user.hashedPassword, &user.salt, &user.Role)
 
 	if err == sql.ErrNoRows {
-		return NewAPIError(404, fmt.Sprintf("No user with id %d exist", user.Id), nil)
+		return NewAPIError(404, fmt.Sprintf("No user with username '%s' exist", username), nil)
 	} else if err != nil {
-		return NewAPIError(500, fmt.Sprintf("Failed to load user with id %d", user.Id), err)
+		return NewAPIError(500, fmt.Sprintf("Failed to load user with username '%s'", username), err)
 	}
 
 	return nil
 }
 
-func hashPassword(password string, salt []byte) []byte {
-	// FIXME Use a much more secure hashing algoritm then md5.
-	md5Hash := md5.New()
-	io.WriteString(md5Hash, password)
-	io.WriteString(md5Hash, string(salt))
-	return md5Hash.Sum(nil)
-}
+func (user *User) LoadFromOIDCSubject(subject string, queryer SQLQueryer) error {
+	err := queryer.QueryRow("SELECT id, username, hash_algorithm, hashed_password, salt, role FROM users WHERE oidc_subject=?", subject).
+		Scan(&user.Id, &user.Username, &user.hashAlgorithm, &user.hashedPassword, &user.salt, &user.Role)
 
-func mustGenerateSalt() []byte {
-	salt := make([]byte, PASSWORD_SALT_BYTES)
+	if err == sql.ErrNoRows {
+		return NewAPIError(404, fmt.Sprintf("No user with OpenID Connect subject '%s' exist", subject), nil)
+	} else if err != nil {
+		return NewAPIError(500, fmt.Sprintf("Failed to load user with OpenID Connect subject '%s'", subject), err)
+	}
 
-	_, err := rand.Read(salt)
+	user.oidcSubject = subject
+	return nil
+}
+
+// Returns a 409 error if another user than the one with id has the username.
+func CheckUsernameAvailable(queryer SQLQueryer, username string, id int64) error {
+	count, err := countRows(queryer, "users", "username=? AND id<>?", username, id)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	if count > 0 {
+		return NewAPIError(409, fmt.Sprintf("Username '%s' is already taken", username), nil)
 	}
 
-	return salt
+	return nil
 }
 
-// IMPORTANT Super ugly helper funtion to force user 1 to be default administrator when debugging.
-func MustCreateDefaultAdministratorIfMissing(execer SQLExecer) {
-	user := NewUser("admin", "admin", true)
-	_, err := execer.Exec("INSERT OR REPLACE INTO users(id, username, salt, hashed_password, is_administrator) values (?,?,?,?,?)", 1, user.Username, user.salt, user.hashedPassword, user.IsAdministrator)
+func ListUsers(queryer SQLQueryer, options *ListOptions) ([]*User, int64, error) {
+	total, err := countRows(queryer, "users", "")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	limit, arguments := options.limit()
+	rows, err := queryer.Query("SELECT id, username, role FROM users"+options.orderBy()+limit, arguments...)
 	if err != nil {
-		panic(err)
+		return nil, 0, NewAPIError(500, "Failed to load users", err)
 	}
+	defer rows.Close()
+
+	users := make([]*User, 0)
+	for rows.Next() {
+		user := &User{}
+
+		err = rows.Scan(&user.Id, &user.Username, &user.Role)
+		if err != nil {
+			return nil, 0, NewAPIError(500, "Failed to load user from row", err)
+		}
+
+		users = append(users, user)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, 0, NewAPIError(500, "Failed to load users", err)
+	}
+
+	return users, total, nil
+}
+
+func CountAdministrators(queryer SQLQueryer) (int64, error) {
+	return countRows(queryer, "users", "role=?", ROLE_ADMINISTRATOR)
 }
 
 type LoginForm struct {
@@ -132,3 +320,13 @@ type LoginForm struct {
 	// Email   string `form:"email"`
 	// Message string `form:"message" binding:"required"`
 }
+
+type PasswordChangeForm struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword"     binding:"required"`
+}
+
+func (form PasswordChangeForm) Validate(errors binding.Errors, req *http.Request) binding.Errors {
+	validatePassword("newPassword", form.NewPassword, &errors)
+	return errors
+}
@@ -0,0 +1,96 @@
+package models_test
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"hiking_trails/src/models"
+	"testing"
+)
+
+// Users from before Argon2id have MD5 hashes, which are replaced on login.
+func TestStoresRehashedMD5Password(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		salt := []byte("0123456789abcdef")
+		hashedPassword := md5.Sum([]byte("hunter22" + string(salt)))
+
+		_, err := db.Exec("INSERT INTO users(username, hash_algorithm, hashed_password, salt, role) VALUES(?,?,?,?,?)",
+			"legacy", models.PASSWORD_HASH_MD5, hashedPassword[:], salt, models.ROLE_VIEWER)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user := &models.User{}
+		err = user.LoadFromUsername("legacy", db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !user.IsCorrectPassword("hunter22") || !user.NeedsPasswordRehash() {
+			t.Fatal("MD5 hash does not match its password or does not need rehashing")
+		}
+
+		user.SetPassword("hunter22")
+		err = user.UpdatePassword(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rehashed := &models.User{}
+		err = rehashed.LoadFromUsername("legacy", db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !rehashed.IsCorrectPassword("hunter22") || rehashed.IsCorrectPassword("hunter23") {
+			t.Error("Stored Argon2id hash does not match only its password")
+		}
+		if rehashed.NeedsPasswordRehash() {
+			t.Error("Stored Argon2id hash needs rehashing")
+		}
+	})
+}
+
+func TestDeletesAPITokensAndPermissionsOfUser(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		bundle := saveTestBundle(t, db, "Lakes", "")
+
+		user := models.NewUser("hiker", "correct horse battery staple", models.ROLE_VIEWER)
+		err := models.Save(user, db)
+		if err == nil {
+			permission := &models.BundlePermission{BundleId: bundle.Id, UserId: user.Id, Role: models.BUNDLE_ROLE_EDITOR}
+			err = permission.Grant(db)
+		}
+		if err == nil {
+			token := &models.APIToken{Name: "sync", Scopes: []string{models.API_TOKEN_SCOPE_READ}, UserId: user.Id}
+			err = token.Save(db)
+		}
+		if err == nil {
+			err = models.DeleteUser(db, user.Id)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, table := range []string{"users", "api_tokens", "bundle_permissions"} {
+			column := "user_id"
+			if table == "users" {
+				column = "id"
+			}
+
+			var count int
+			err = db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE "+column+"=?", user.Id).Scan(&count)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if count != 0 {
+				t.Errorf("%d rows of deleted user left in %s", count, table)
+			}
+		}
+
+		err = models.DeleteUser(db, user.Id)
+		if apiError, isAPIError := err.(*models.APIError); !isAPIError || apiError.Status != 404 {
+			t.Errorf("Deleting deleted user failed with %v, expected 404", err)
+		}
+	})
+}
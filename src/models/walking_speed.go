@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+)
+
+// Model used to estimate how long it takes to hike a path. The default is
+// Naismith's rule: 5 km/h on flat ground plus one hour for every 600 meters
+// of ascent. WalkingSpeed is set from the configuration at startup.
+type WalkingSpeedModel struct {
+	// Horizontal walking speed in km/h.
+	SpeedKilometersPerHour float64
+
+	// Meters of ascent that add one hour of walking. Zero ignores ascent.
+	AscentMetersPerHour float64
+}
+
+var NaismithsRule = WalkingSpeedModel{5, 600}
+
+var WalkingSpeed = NaismithsRule
+
+func (model WalkingSpeedModel) EstimateDuration(lengthMeters float64, ascentMeters float64) time.Duration {
+	if model.SpeedKilometersPerHour <= 0 {
+		return 0
+	}
+
+	hours := lengthMeters / 1000 / model.SpeedKilometersPerHour
+	if model.AscentMetersPerHour > 0 {
+		hours += ascentMeters / model.AscentMetersPerHour
+	}
+
+	return time.Duration(int64(hours*3600+0.5)) * time.Second
+}